@@ -187,6 +187,31 @@ func TestFindOccurrences(t *testing.T) {
 			"testdata/src/foo/foo.go:285"}, t)
 }
 
+func TestFindOccurrencesWithProgress(t *testing.T) {
+	prog := setup(t)
+	ident, pkg := findFirstIdent(prog, "bar", "Exported", t)
+	obj := pkg.ObjectOf(ident)
+
+	calls, lastDone, lastTotal := 0, 0, 0
+	occs, ok := names.FindOccurrencesWithProgress(obj, prog, nil,
+		func(done, total int) {
+			calls++
+			lastDone, lastTotal = done, total
+		})
+	if !ok || len(occs) != 2 {
+		t.Fatalf("FindOccurrencesWithProgress: Expected 2 occurrences, got %d", len(occs))
+	}
+	if calls == 0 || lastDone != lastTotal {
+		t.Fatalf("FindOccurrencesWithProgress: Progress reported %d of %d", lastDone, lastTotal)
+	}
+
+	cancel := make(chan struct{})
+	close(cancel)
+	if _, ok := names.FindOccurrencesWithProgress(obj, prog, cancel, nil); ok {
+		t.Fatal("FindOccurrencesWithProgress: Expected search to be canceled")
+	}
+}
+
 func check(actual, expect []string, t *testing.T) {
 	if !equals(actual, expect) {
 		t.Fatalf("FindOccurrences: Expected %v, got %v", expect, actual)
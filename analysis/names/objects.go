@@ -18,13 +18,31 @@ import (
 // variables defined by type switch statements; those must be handled using
 // different methods in this package.
 func FindOccurrences(obj types.Object, prog *loader.Program) map[*ast.Ident]bool {
+	result, _ := FindOccurrencesWithProgress(obj, prog, nil, nil)
+	return result
+}
+
+// FindOccurrencesWithProgress is like FindOccurrences, but the search can be
+// interrupted, and its progress can be monitored.  After each package is
+// searched, progress (if non-nil) is invoked with the number of packages
+// searched so far and the total number of packages to search.  The search
+// stops as soon as the cancel channel (if non-nil) is closed; in this case,
+// the second return value is false, and the set of identifiers is incomplete.
+func FindOccurrencesWithProgress(obj types.Object, prog *loader.Program, cancel <-chan struct{}, progress func(done, total int)) (map[*ast.Ident]bool, bool) {
 	decls := map[types.Object]bool{obj: true}
 	if isMethod(obj) {
 		decls = FindDeclarationsAcrossInterfaces(obj, prog)
 	}
 
 	result := make(map[*ast.Ident]bool)
-	for pkgInfo := range packages(decls, prog) {
+	pkgs := packages(decls, prog)
+	done := 0
+	for pkgInfo := range pkgs {
+		select {
+		case <-cancel:
+			return result, false
+		default:
+		}
 		for id, obj := range pkgInfo.Defs {
 			if decls[obj] {
 				result[id] = true
@@ -35,8 +53,12 @@ func FindOccurrences(obj types.Object, prog *loader.Program) map[*ast.Ident]bool
 				result[id] = true
 			}
 		}
+		done++
+		if progress != nil {
+			progress(done, len(pkgs))
+		}
 	}
-	return result
+	return result, true
 }
 
 // packages returns a set of PackageInfos that may reference the given
@@ -9,7 +9,9 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/godoctor/godoctor/engine"
 	"github.com/godoctor/godoctor/engine/cli"
+	"github.com/godoctor/godoctor/refactoring"
 )
 
 // Name of the refactoring tool (Go Doctor).  This can be overridden using:
@@ -21,6 +23,14 @@ var name string = "Go Doctor"
 var version string = "0.1 (unofficial)"
 
 func main() {
+	// When -verify is used, this executable is restarted to run tests
+	refactoring.RunTestsIfChild()
+
+	// A broken plugin should not prevent the built-in refactorings (or
+	// -help and -list) from working, so plugin errors are only warnings
+	if err := engine.LoadPlugins(engine.DefaultPluginConfigFile()); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", err)
+	}
 	aboutText := fmt.Sprintf("%s %s", name, version)
 	os.Exit(cli.Run(aboutText, os.Stdin, os.Stdout, os.Stderr, os.Args))
 }
@@ -43,7 +43,7 @@ godoctor \- refactor Go source code
 .I ...
 .B ]
 .SH DESCRIPTION
-godoctor refactors Go Source code, outputting a patch file with the changes (unless the -w or -complete flag is specified).
+godoctor refactors Go Source code, outputting a patch file with the changes (unless the -w, -complete, or -sarif flag is specified).  With the -git flag, the patch is in the format produced by git diff, so it can be applied using git apply or patch -p1.
 .PP
 The Go Doctor can be run from the command line, but it is more easily used from an editor like Vim.
 .PP
@@ -95,6 +95,27 @@ bar
 Toy example: Pipe a file to the godoctor and rename n to foo, displaying the result:
 echo 'package main; import "fmt"; func main() { n := 1; fmt.Println(n) }' | godoctor -pos 1,43:1,43 -w rename foo
 .PP
+.SH FILES
+.TP
+.I .godoctor.json
+Project configuration file.  The directory containing the file being refactored and each of its ancestors are searched for this file; the first one found provides defaults for that project.  It is a JSON object with the optional keys
+.B scope
+(a list of packages or files, as for -scope),
+.B tests
+(false to avoid loading _test.go files),
+.B exclude
+(a list of directories, relative to the configuration file, that will not be loaded or modified),
+.B tags
+(a list of additional build tags),
+.B builds
+(a list of build configurations, as for -build),
+.B verbosity
+(0, 1, or 2), and
+.B args
+(an object mapping refactoring names to lists of default arguments).  Command line flags and arguments take precedence over these defaults.
+.TP
+.I ~/.godoctor/plugins.json
+Plugin configuration file, listing external executables that provide additional refactorings.  The GODOCTOR_PLUGINS environment variable may be set to use a different file.
 .SH EXIT STATUS
 .TP
 0
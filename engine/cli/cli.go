@@ -8,10 +8,14 @@ package cli
 import (
 	"flag"
 	"fmt"
+	"go/build"
 	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
+	"sync"
 	"time"
 
 	"strings"
@@ -59,10 +63,17 @@ type CLIFlags struct {
 	fileFlag        *string
 	posFlag         *string
 	scopeFlag       *string
+	buildFlag       *string
 	completeFlag    *bool
 	writeFlag       *bool
+	sarifFlag       *bool
+	colorFlag       *string
+	gitFlag         *bool
+	contextFlag     *int
+	columnsFlag     *string
 	verboseFlag     *bool
 	veryVerboseFlag *bool
+	verifyFlag      *bool
 	listFlag        *bool
 	jsonFlag        *bool
 	docFlag         *string
@@ -75,17 +86,31 @@ func Flags() *CLIFlags {
 	flags.fileFlag = flags.String("file", "",
 		"Filename containing an element to refactor (default: stdin)")
 	flags.posFlag = flags.String("pos", "1,1:1,1",
-		"Position of a syntax element to refactor (default: entire file)")
+		"Position or qualified name (pkg/path.Type.Method) of a syntax element to refactor (default: entire file)")
 	flags.scopeFlag = flags.String("scope", "",
 		"Package name(s), or source file containing a program entrypoint")
+	flags.buildFlag = flags.String("build", "",
+		"Build configurations to refactor under (GOOS/GOARCH[+tag...],...)")
 	flags.completeFlag = flags.Bool("complete", false,
 		"Output entire modified source files instead of displaying a diff")
 	flags.writeFlag = flags.Bool("w", false,
 		"Modify source files on disk (write) instead of displaying a diff")
+	flags.sarifFlag = flags.Bool("sarif", false,
+		"Output the log and changes in SARIF format instead of a diff")
+	flags.colorFlag = flags.String("color", "auto",
+		"Highlight changes in the diff: auto (on a terminal), always, never")
+	flags.gitFlag = flags.Bool("git", false,
+		"Output a git-format patch (for git apply or patch -p1)")
+	flags.contextFlag = flags.Int("context", 3,
+		"Number of lines of context to include in the diff")
+	flags.columnsFlag = flags.String("columns", "bytes",
+		"Count columns in -pos and the log as bytes, runes, or utf16")
 	flags.verboseFlag = flags.Bool("v", false,
 		"Verbose: list affected files")
 	flags.veryVerboseFlag = flags.Bool("vv", false,
 		"Very verbose: list individual edits (implies -v)")
+	flags.verifyFlag = flags.Bool("verify", false,
+		"Run tests before and after refactoring to verify behavior")
 	flags.listFlag = flags.Bool("list", false,
 		"List all refactorings and exit")
 	flags.jsonFlag = flags.Bool("json", false,
@@ -146,10 +171,10 @@ func Run(aboutText string, stdin io.Reader, stdout io.Writer, stderr io.Writer,
 		}
 		if *flags.verboseFlag || *flags.veryVerboseFlag ||
 			*flags.writeFlag || *flags.completeFlag ||
-			*flags.jsonFlag {
+			*flags.sarifFlag || *flags.jsonFlag {
 			fmt.Fprintln(stderr, "Error: The -list flag "+
 				"cannot be used with the -v, -vv, -w, "+
-				"-complete, or -json flags")
+				"-complete, -sarif, or -json flags")
 			return 1
 		}
 		// Invoked: godoctor [-file=""] [-pos=""] [-scope=""] -list
@@ -184,6 +209,45 @@ func Run(aboutText string, stdin io.Reader, stdout io.Writer, stderr io.Writer,
 		return 1
 	}
 
+	if *flags.sarifFlag && (*flags.writeFlag || *flags.completeFlag) {
+		fmt.Fprintln(stderr, "Error: The -sarif flag cannot be used "+
+			"with the -w or -complete flags")
+		return 1
+	}
+
+	if *flags.gitFlag && (*flags.writeFlag || *flags.completeFlag ||
+		*flags.sarifFlag) {
+		fmt.Fprintln(stderr, "Error: The -git flag cannot be used "+
+			"with the -w, -complete, or -sarif flags")
+		return 1
+	}
+
+	if *flags.contextFlag < 0 {
+		fmt.Fprintln(stderr, "Error: The -context flag must not "+
+			"be negative")
+		return 1
+	}
+
+	color := false
+	switch *flags.colorFlag {
+	case "auto":
+		color = isTerminal(stdout)
+	case "always":
+		color = true
+	case "never":
+		color = false
+	default:
+		fmt.Fprintln(stderr, "Error: The -color flag must be "+
+			"\"auto\", \"always\", or \"never\"")
+		return 1
+	}
+
+	columns, err := text.ParseColumnMode(*flags.columnsFlag)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s.\n", err)
+		return 1
+	}
+
 	if len(args) == 0 || args[0] == "" || args[0] == "help" {
 		// Invoked as "godoctor [flags]" or "godoctor [flags] help"
 		printHelp(aboutText, flags.FlagSet, stderr)
@@ -197,6 +261,12 @@ func Run(aboutText string, stdin io.Reader, stdout io.Writer, stderr io.Writer,
 			refacName)
 		return 1
 	}
+	if plugin, ok := refac.(*refactoring.Plugin); ok {
+		if err := plugin.Describe(); err != nil {
+			fmt.Fprintf(stderr, "Error: %s\n", err)
+			return 1
+		}
+	}
 
 	args = args[1:]
 
@@ -214,6 +284,17 @@ func Run(aboutText string, stdin io.Reader, stdout io.Writer, stderr io.Writer,
 	if *flags.fileFlag != "" && *flags.fileFlag != "-" {
 		fileName = *flags.fileFlag
 		fileSystem = &filesystem.LocalFileSystem{}
+	} else if *flags.fileFlag == "" && text.IsSymbol(*flags.posFlag) {
+		// A qualified name identifies its package; find a file in it
+		sym, err := text.NewSymbolSelection("", *flags.posFlag)
+		if err == nil {
+			fileName, err = packageFile(sym.Package)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %s.\n", err)
+			return 1
+		}
+		fileSystem = &filesystem.LocalFileSystem{}
 	} else {
 		// Filename is - or no filename given; read from standard input
 		var err error
@@ -241,6 +322,9 @@ func Run(aboutText string, stdin io.Reader, stdout io.Writer, stderr io.Writer,
 		fmt.Fprintf(stderr, "Error: %s.\n", err)
 		return 1
 	}
+	if lc, ok := selection.(*text.LineColSelection); ok {
+		lc.Columns = columns
+	}
 
 	var scope []string
 	if *flags.scopeFlag == "" {
@@ -254,6 +338,15 @@ func Run(aboutText string, stdin io.Reader, stdout io.Writer, stderr io.Writer,
 		scope = strings.Split(*flags.scopeFlag, ",")
 	}
 
+	var buildConfigs []refactoring.BuildConfig
+	if *flags.buildFlag != "" {
+		buildConfigs, err = refactoring.ParseBuildConfigs(*flags.buildFlag)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %s.\n", err)
+			return 1
+		}
+	}
+
 	verbosity := 0
 	if *flags.verboseFlag {
 		verbosity = 1
@@ -261,20 +354,70 @@ func Run(aboutText string, stdin io.Reader, stdout io.Writer, stderr io.Writer,
 	if *flags.veryVerboseFlag {
 		verbosity = 2
 	}
+	// Even -v=false overrides the verbosity in a project configuration
+	verbositySet := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "v" || f.Name == "vv" {
+			verbositySet = true
+		}
+	})
 
-	result := refac.Run(&refactoring.Config{
-		FileSystem: fileSystem,
-		Scope:      scope,
-		Selection:  selection,
-		Args:       refactoring.InterpretArgs(args, refac),
-		Verbosity:  verbosity})
-
-	// Display log in GNU-style 'file:line.col-line.col: message' format
-	cwd, err := os.Getwd()
+	// Cancel the refactoring if the user presses Ctrl+C
+	cancel := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		if _, ok := <-interrupt; ok {
+			close(cancel)
+		}
+	}()
+
+	config := &refactoring.Config{
+		FileSystem:   fileSystem,
+		Scope:        scope,
+		Selection:    selection,
+		Args:         refactoring.InterpretArgs(args, refac),
+		Verbosity:    verbosity,
+		BuildConfigs: buildConfigs,
+		Verify:       *flags.verifyFlag,
+		Cancel:       cancel}
+
+	// Apply defaults from the project configuration file, if any
+	configDir := fileName
+	if stdinPath != "" {
+		configDir = "."
+	}
+	project, err := engine.FindProjectConfig(configDir)
 	if err != nil {
-		cwd = ""
+		fmt.Fprintf(stderr, "Error: %s.\n", err)
+		return 1
+	}
+	project.Apply(config, refacName)
+	if verbositySet {
+		config.Verbosity = verbosity
+	}
+
+	loaded := time.Now()
+	origContents := &loadedFiles{contents: map[string][]byte{}}
+	if *flags.writeFlag {
+		config.Loaded = origContents.record
+	}
+	progress := newProgressBar(stderr)
+	config.Progress = progress.update
+	result := refactoring.RunInConfigurations(refac, config)
+	progress.finish()
+	signal.Stop(interrupt)
+	close(interrupt)
+
+	// Display log in GNU-style 'file:line.col-line.col: message' format,
+	// unless it will be included in SARIF output
+	if !*flags.sarifFlag {
+		cwd, err := os.Getwd()
+		if err != nil {
+			cwd = ""
+		}
+		result.Log.WriteColumns(stderr, cwd, columns, fileSystem)
 	}
-	result.Log.Write(stderr, cwd)
 
 	// If input was supplied on standard input, ensure that the refactoring
 	// makes changes only to that code (and does not affect any other files)
@@ -285,14 +428,22 @@ func Run(aboutText string, stdin io.Reader, stdout io.Writer, stderr io.Writer,
 				return 1
 			}
 		}
+		if len(result.NewFiles) > 0 || len(result.Moves) > 0 {
+			fmt.Fprintf(stderr, "Error: When source code is given on standard input, refactorings are prohibited from creating or moving files.\n")
+			return 1
+		}
 	}
 
 	if *flags.writeFlag {
-		err = writeToDisk(result, fileSystem)
+		err = writeToDisk(result, fileSystem, loaded, origContents)
+	} else if *flags.sarifFlag {
+		err = writeSARIF(stdout, aboutText, refac, selection, result, fileSystem)
 	} else if *flags.completeFlag {
-		err = writeFileContents(stdout, result.Edits, fileSystem)
+		err = writeFileContents(stdout, result, fileSystem)
+	} else if *flags.gitFlag {
+		err = writeGitDiff(stdout, result, fileSystem, color, *flags.contextFlag)
 	} else {
-		err = writeDiff(stdout, result.Edits, fileSystem)
+		err = writeDiff(stdout, result, fileSystem, color, *flags.contextFlag)
 	}
 	if err != nil {
 		fmt.Fprintf(stderr, "Error: %s.\n", err)
@@ -306,34 +457,178 @@ func Run(aboutText string, stdin io.Reader, stdout io.Writer, stderr io.Writer,
 	}
 }
 
+// A fileChange describes the changes a refactoring makes to a single file.
+type fileChange struct {
+	origFile string // Original filename, or "" if the file is created
+	newFile  string // Filename after the refactoring (if moved, its new name)
+	edits    *text.EditSet
+}
+
+// fileChanges returns the changes in a refactoring's result (edits, new files,
+// and moved files), sorted by new filename.
+func fileChanges(result *refactoring.Result) []*fileChange {
+	changes := []*fileChange{}
+	for filename, es := range result.Edits {
+		changes = append(changes, &fileChange{filename, filename, es})
+	}
+	for filename, contents := range result.NewFiles {
+		es := text.NewEditSet()
+		es.Add(&text.Extent{0, 0}, contents)
+		changes = append(changes, &fileChange{"", filename, es})
+	}
+	for oldName := range result.Moves {
+		if _, ok := result.Edits[oldName]; !ok {
+			changes = append(changes, &fileChange{oldName, oldName, nil})
+		}
+	}
+	for _, c := range changes {
+		if newName, ok := result.Moves[c.origFile]; ok {
+			c.newFile = newName
+		}
+	}
+	sort.Sort(byNewFile(changes))
+	return changes
+}
+
+type byNewFile []*fileChange
+
+func (s byNewFile) Len() int           { return len(s) }
+func (s byNewFile) Less(i, j int) bool { return s[i].newFile < s[j].newFile }
+func (s byNewFile) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// readOrig returns the original contents of a changed file ("" if the file is
+// being created).
+func (c *fileChange) readOrig(fs filesystem.FileSystem) (string, error) {
+	if c.origFile == "" {
+		return "", nil
+	}
+	r, err := fs.OpenFile(c.origFile)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	return string(data), err
+}
+
 // writeDiff outputs a multi-file unified diff describing this refactoring's
-// changes.  It can be applied using GNU patch.
-func writeDiff(out io.Writer, edits map[string]*text.EditSet, fs filesystem.FileSystem) error {
-	for f, e := range edits {
-		p, err := filesystem.CreatePatch(e, fs, f)
+// changes, with the files sorted by name.  It can be applied using GNU patch.
+// If color is true, the diff is colored using ANSI escape sequences, with
+// changed words highlighted.
+func writeDiff(out io.Writer, result *refactoring.Result, fs filesystem.FileSystem, color bool, context int) error {
+	for _, c := range fileChanges(result) {
+		if c.edits == nil {
+			continue
+		}
+		orig, err := c.readOrig(fs)
+		if err != nil {
+			return err
+		}
+		p, err := c.edits.CreatePatchWithContext(strings.NewReader(orig), context)
 		if err != nil {
 			return err
 		}
 
 		if !p.IsEmpty() {
-			inFile := f
-			outFile := f
-			stdinPath, _ := filesystem.FakeStdinPath()
-			if f == stdinPath {
-				inFile = os.Stdin.Name()
-				outFile = os.Stdout.Name()
+			inFile := displayName(c.origFile, os.Stdin.Name())
+			outFile := displayName(c.newFile, os.Stdout.Name())
+			if color {
+				fmt.Fprintf(out, "\x1b[1mdiff -u %s %s\x1b[0m\n", inFile, outFile)
+				p.WriteColor(inFile, outFile, time.Time{}, time.Time{}, out)
 			} else {
-				rel := relativePath(f)
-				inFile = rel
-				outFile = rel
+				fmt.Fprintf(out, "diff -u %s %s\n", inFile, outFile)
+				p.Write(inFile, outFile, time.Time{}, time.Time{}, out)
 			}
-			fmt.Fprintf(out, "diff -u %s %s\n", inFile, outFile)
-			p.Write(inFile, outFile, time.Time{}, time.Time{}, out)
 		}
 	}
 	return nil
 }
 
+// displayName returns the name of a file to display in a diff: a relative
+// path if possible, the given name if the file is the fake standard input
+// file, or /dev/null if filename is "".
+func displayName(filename, stdinName string) string {
+	if filename == "" {
+		return os.DevNull
+	}
+	if stdinPath, _ := filesystem.FakeStdinPath(); filename == stdinPath {
+		return stdinName
+	}
+	return relativePath(filename)
+}
+
+// writeGitDiff outputs a git-format patch describing this refactoring's
+// changes, with the files sorted by name.  Filenames are relative to the
+// current directory, so the patch can be applied from that directory using
+// "git apply" or "patch -p1".
+func writeGitDiff(out io.Writer, result *refactoring.Result, fs filesystem.FileSystem, color bool, context int) error {
+	for _, c := range fileChanges(result) {
+		orig, err := c.readOrig(fs)
+		if err != nil {
+			return err
+		}
+		d := &text.GitDiff{
+			OrigFile: gitName(c.origFile),
+			NewFile:  gitName(c.newFile),
+			Orig:     orig,
+			Edits:    c.edits,
+			Context:  context,
+		}
+		if color {
+			err = d.WriteColor(out)
+		} else {
+			err = d.Write(out)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gitName returns the name of a file to display in a git-format patch: a
+// slash-separated relative path if possible, or "" if filename is "".
+func gitName(filename string) string {
+	if filename == "" {
+		return ""
+	}
+	name := filepath.ToSlash(displayName(filename, os.Stdin.Name()))
+	return strings.TrimPrefix(name, "/")
+}
+
+// packageFile returns the name of a Go source file in the package with the
+// given import path, which is used to locate the package (and determine the
+// scope) when the -pos flag gives a qualified name but -file is not given.
+func packageFile(importPath string) (string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	pkg, err := build.Import(importPath, cwd, 0)
+	if err != nil {
+		return "", fmt.Errorf("cannot find package %s (use -file to "+
+			"give the name of a file in the package)", importPath)
+	}
+	files := append(append([]string{}, pkg.GoFiles...), pkg.CgoFiles...)
+	if len(files) == 0 {
+		return "", fmt.Errorf("package %s contains no Go files", importPath)
+	}
+	return filepath.Join(pkg.Dir, files[0]), nil
+}
+
+// isTerminal returns true iff the given writer is a character device.
+func isTerminal(out io.Writer) bool {
+	f, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
 // relativePath returns a relative path to fname, or fname if a relative path
 // cannot be computed due to an error
 func relativePath(fname string) string {
@@ -346,14 +641,24 @@ func relativePath(fname string) string {
 }
 
 // writeFileContents outputs the complete contents of each file affected by
-// this refactoring.
-func writeFileContents(out io.Writer, edits map[string]*text.EditSet, fs filesystem.FileSystem) error {
-	for filename, edits := range edits {
-		data, err := filesystem.ApplyEdits(edits, fs, filename)
+// this refactoring, sorted by name.  Moved files are output under their new
+// names.
+func writeFileContents(out io.Writer, result *refactoring.Result, fs filesystem.FileSystem) error {
+	for _, c := range fileChanges(result) {
+		orig, err := c.readOrig(fs)
+		if err != nil {
+			return err
+		}
+		edits := c.edits
+		if edits == nil {
+			edits = text.NewEditSet()
+		}
+		data, err := text.ApplyToReader(edits, strings.NewReader(orig))
 		if err != nil {
 			return err
 		}
 
+		filename := c.newFile
 		stdinPath, _ := filesystem.FakeStdinPath()
 		if filename == stdinPath {
 			filename = os.Stdin.Name()
@@ -379,8 +684,15 @@ func writeFileContents(out io.Writer, edits map[string]*text.EditSet, fs filesys
 
 // writeToDisk overwrites existing files with their refactored versions and
 // applies any other changes to the file system that the refactoring requires
-// (e.g., renaming directories).
-func writeToDisk(result *refactoring.Result, fs filesystem.FileSystem) error {
+// (e.g., renaming directories).  Changes to the local file system are made in
+// a single transaction, which fails if a file was modified after the given
+// time (when the program was loaded) or if its contents differ from those in
+// origContents (i.e., those from which the refactoring was computed).
+func writeToDisk(result *refactoring.Result, fs filesystem.FileSystem, loaded time.Time, origContents *loadedFiles) error {
+	if _, ok := fs.(*filesystem.LocalFileSystem); ok {
+		return commitToDisk(result, fs, loaded, origContents)
+	}
+
 	for filename, edits := range result.Edits {
 		data, err := filesystem.ApplyEdits(edits, fs, filename)
 		if err != nil {
@@ -402,5 +714,97 @@ func writeToDisk(result *refactoring.Result, fs filesystem.FileSystem) error {
 			return err
 		}
 	}
+
+	filenames := []string{}
+	for filename := range result.NewFiles {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		if err := fs.CreateFile(filename, result.NewFiles[filename]); err != nil {
+			return err
+		}
+	}
+
+	filenames = filenames[:0]
+	for filename := range result.Moves {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	for _, oldName := range filenames {
+		newName := result.Moves[oldName]
+		if filepath.Dir(oldName) != filepath.Dir(newName) {
+			return fmt.Errorf("cannot move %s to a different "+
+				"directory (%s)", oldName, newName)
+		}
+		if err := fs.Rename(oldName, filepath.Base(newName)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// commitToDisk writes a refactoring's changes to the local file system using
+// a filesystem.Transaction, so that either every file is changed or none are.
+// Each file's edits are applied to its contents when the program was loaded,
+// and the transaction fails if the file no longer has those contents.  Files
+// that were not read while loading the program (e.g., files changed by Apply
+// Patch that are not part of the program) are read now, so they are checked
+// only against the time the program was loaded.
+func commitToDisk(result *refactoring.Result, fs filesystem.FileSystem, loaded time.Time, origContents *loadedFiles) error {
+	tx := filesystem.NewTransaction(loaded)
+	for _, c := range fileChanges(result) {
+		if c.origFile == "" {
+			tx.Create(c.newFile, []byte(result.NewFiles[c.newFile]))
+			continue
+		}
+		if c.edits != nil {
+			expected := origContents.get(c.origFile)
+			orig := string(expected)
+			if expected == nil {
+				var err error
+				if orig, err = c.readOrig(fs); err != nil {
+					return err
+				}
+			}
+			data, err := text.ApplyToString(c.edits, orig)
+			if err != nil {
+				return err
+			}
+			tx.Overwrite(c.origFile, expected, []byte(data))
+		}
+		if c.newFile != c.origFile {
+			if filepath.Dir(c.origFile) != filepath.Dir(c.newFile) {
+				return fmt.Errorf("cannot move %s to a different "+
+					"directory (%s)", c.origFile, c.newFile)
+			}
+			tx.Move(c.origFile, c.newFile)
+		}
+	}
+	return tx.Commit()
+}
+
+// loadedFiles records the contents of each file when the program was loaded
+// (see refactoring.Config.Loaded).
+type loadedFiles struct {
+	mutex    sync.Mutex
+	contents map[string][]byte
+}
+
+// record saves the contents of the given file, unless they have already been
+// recorded (e.g., while loading the program in another build configuration).
+func (l *loadedFiles) record(filename string, contents []byte) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, ok := l.contents[filename]; !ok {
+		l.contents[filename] = contents
+	}
+}
+
+// get returns the contents of the given file when the program was loaded, or
+// nil if the file was not read.
+func (l *loadedFiles) get(filename string) []byte {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.contents[filename]
+}
@@ -6,8 +6,11 @@ package cli_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -23,6 +26,15 @@ func main() {
 }`
 	pos = "-pos=3,5:3,5" // position to rename (msg variable)
 
+	noImports = `package main
+var msgé string
+func main() {
+	msgé = "x"
+	var y int
+	_ = y
+}`
+	noImportsPos = "-pos=2,5:2,5" // position to rename (msgé variable)
+
 	diff = `diff -u /dev/stdin /dev/stdout
 --- /dev/stdin
 +++ /dev/stdout
@@ -138,6 +150,12 @@ func TestInvalidCombos(t *testing.T) {
 		[]string{"-list", "-doc=man"},
 		[]string{"-list", "-v"},
 		[]string{"-list", "-w"},
+		[]string{"-list", "-sarif"},
+		[]string{"-sarif", "-w"},
+		[]string{"-sarif", "-complete"},
+		[]string{"-git", "-w"},
+		[]string{"-git", "-complete"},
+		[]string{"-git", "-sarif"},
 		[]string{"-list", "somearg"},
 		[]string{"-doc=man", "-pos=1,1:1,1"},
 		[]string{"-doc=man", "-scope=golang.org/x/tools"},
@@ -238,3 +256,276 @@ func TestRenameInvalidScope(t *testing.T) {
 		t.Fatalf("Rename with invalid scope should not have output")
 	}
 }
+
+func TestRenameColor(t *testing.T) {
+	exit, stdout, stderr := runCLI(noImports, "-scope=-", noImportsPos, "-color=always", "rename", "z")
+	if exit != 0 {
+		t.Fatalf("Rename expected exit code 0; got %d\n%s", exit, stderr)
+	}
+	for _, expected := range []string{
+		"\x1b[31m-var \x1b[7mmsgé\x1b[27m string\x1b[0m\n",
+		"\x1b[32m+var \x1b[7mz\x1b[27m string\x1b[0m\n",
+		"\x1b[32m+\t\x1b[7mz\x1b[27m = \"x\"\x1b[0m\n",
+	} {
+		if !strings.Contains(stdout, expected) {
+			t.Fatalf("Expected %q in colored diff:\n%s", expected, stdout)
+		}
+	}
+
+	exit, stdout, _ = runCLI(noImports, "-scope=-", noImportsPos, "-color=sometimes", "rename", "z")
+	if exit != 1 || stdout != "" {
+		t.Fatalf("Invalid -color flag should produce exit code 1; got %d", exit)
+	}
+}
+
+func TestRenameGit(t *testing.T) {
+	exit, stdout, stderr := runCLI(noImports, "-scope=-", noImportsPos, "-git", "-context=1", "rename", "z")
+	if exit != 0 {
+		t.Fatalf("Rename expected exit code 0; got %d\n%s", exit, stderr)
+	}
+	expected := `diff --git a/dev/stdin b/dev/stdin
+index a2ee203..f106d1a 100644
+--- a/dev/stdin
++++ b/dev/stdin
+@@ -1,5 +1,5 @@
+ package main
+-var msgé string
++var z string
+ func main() {
+-	msgé = "x"
++	z = "x"
+ 	var y int
+`
+	if stdout != expected {
+		t.Fatalf("Output did not match expected diff:\n%s", stdout)
+	}
+
+	exit, _, _ = runCLI(noImports, "-scope=-", noImportsPos, "-context=-1", "rename", "z")
+	if exit != 1 {
+		t.Fatalf("Negative -context should produce exit code 1; got %d", exit)
+	}
+}
+
+func TestRenameSymbol(t *testing.T) {
+	exit, stdout, stderr := runCLI(noImports, "-file=-", "-scope=-", "-pos=main.msgé", "rename", "z")
+	if exit != 0 {
+		t.Fatalf("Rename expected exit code 0; got %d\n%s", exit, stderr)
+	}
+	if !strings.Contains(stdout, "+var z string\n") ||
+		!strings.Contains(stdout, "+\tz = \"x\"\n") {
+		t.Fatalf("Output did not rename msgé:\n%s", stdout)
+	}
+
+	exit, stdout, stderr = runCLI(noImports, "-file=-", "-scope=-", "-pos=main.missing", "rename", "z")
+	if exit != 3 || stdout != "" || !strings.Contains(stderr, "main.missing") {
+		t.Fatalf("Rename of missing symbol should produce exit code 3; got %d\n%s", exit, stderr)
+	}
+}
+
+// tempGoPath creates a temporary directory and makes it the GOPATH.  The
+// returned function restores the GOPATH and removes the directory.
+func tempGoPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "godoctor-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopath := os.Getenv("GOPATH")
+	os.Setenv("GOPATH", dir)
+	return dir, func() {
+		os.Setenv("GOPATH", gopath)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRenameWrite(t *testing.T) {
+	dir, cleanup := tempGoPath(t)
+	defer cleanup()
+	filename := filepath.Join(dir, "main.go")
+	if err := ioutil.WriteFile(filename, []byte(noImports), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	exit, stdout, stderr := runCLI("", "-file="+filename, "-scope="+filename, noImportsPos, "-w", "rename", "z")
+	if exit != 0 || stdout != "" {
+		t.Fatalf("Rename expected exit code 0; got %d\n%s", exit, stderr)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "var z string\n") {
+		t.Fatalf("File was not refactored:\n%s", data)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Permissions not preserved: %v", info.Mode())
+	}
+	if infos, _ := ioutil.ReadDir(dir); len(infos) != 1 {
+		t.Fatalf("Temporary files were not removed")
+	}
+}
+
+func TestProjectVerbosity(t *testing.T) {
+	dir, cleanup := tempGoPath(t)
+	defer cleanup()
+	filename := filepath.Join(dir, "main.go")
+	if err := ioutil.WriteFile(filename, []byte(noImports), 0600); err != nil {
+		t.Fatal(err)
+	}
+	project := filepath.Join(dir, ".godoctor.json")
+	if err := ioutil.WriteFile(project, []byte(`{"verbosity": 2}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The project's verbosity lists individual edits...
+	exit, _, stderr := runCLI("", "-file="+filename, "-scope="+filename, noImportsPos, "rename", "z")
+	if exit != 0 || !strings.Contains(stderr, "| Replace") {
+		t.Fatalf("Expected edits to be listed (exit %d):\n%s", exit, stderr)
+	}
+	// ...unless the verbosity is given explicitly
+	exit, _, stderr = runCLI("", "-file="+filename, "-scope="+filename, noImportsPos, "-v=false", "rename", "z")
+	if exit != 0 || strings.Contains(stderr, "| Replace") {
+		t.Fatalf("Expected edits not to be listed (exit %d):\n%s", exit, stderr)
+	}
+}
+
+func TestLineEndings(t *testing.T) {
+	const program = "\uFEFFpackage main\r\n\r\n" +
+		"func Exported() {\r\n}\r\n\r\n" +
+		"func main() {\r\n\tExported()\r\n}\r\n"
+	exit, stdout, stderr := runCLI(program, "-scope=-", "-complete", "godoc")
+	if exit != 0 {
+		t.Fatalf("godoc expected exit code 0; got %d\n%s", exit, stderr)
+	}
+	// Skip the "@@@@@ filename @@@@@ size @@@@@" header
+	stdout = stdout[strings.Index(stdout, "\n")+1:]
+	if !strings.HasPrefix(stdout, "\uFEFFpackage main\r\n") ||
+		!strings.Contains(stdout, "// Exported ") ||
+		strings.Count(stdout, "\n") != strings.Count(stdout, "\r\n") {
+		t.Fatalf("Line endings or BOM not preserved: %q", stdout)
+	}
+}
+
+func TestRenameColumns(t *testing.T) {
+	const program = `package main
+var é, msg string
+func main() {
+	é, msg = "x", "y"
+}
+`
+	// msg begins at byte column 9 but character (and UTF-16) column 8
+	for _, columns := range []string{"-columns=runes", "-columns=utf16"} {
+		exit, stdout, stderr := runCLI(program, "-scope=-", "-pos=2,8:2,10", columns, "rename", "z")
+		if exit != 0 {
+			t.Fatalf("%s: Rename expected exit code 0; got %d\n%s", columns, exit, stderr)
+		}
+		if !strings.Contains(stdout, "+var é, z string\n") {
+			t.Fatalf("%s: Output did not rename msg:\n%s", columns, stdout)
+		}
+	}
+
+	exit, stdout, _ := runCLI(program, "-scope=-", "-pos=2,8:2,10", "-columns=chars", "rename", "z")
+	if exit != 1 || stdout != "" {
+		t.Fatalf("Invalid -columns flag should produce exit code 1; got %d", exit)
+	}
+}
+
+func TestRenameSARIF(t *testing.T) {
+	exit, stdout, stderr := runCLI(noImports, "-scope=-", noImportsPos, "-sarif", "rename", "z")
+	if exit != 0 || stderr != "" {
+		t.Fatalf("Rename expected exit code 0; got %d\n%s", exit, stderr)
+	}
+	var log struct {
+		Version string
+		Runs    []struct {
+			Results []struct {
+				Level string
+				Fixes []struct {
+					ArtifactChanges []struct {
+						Replacements []struct {
+							DeletedRegion struct {
+								StartLine, StartColumn int
+								EndLine, EndColumn     int
+								ByteOffset, ByteLength int
+							}
+							InsertedContent struct{ Text string }
+						}
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(stdout), &log); err != nil {
+		t.Fatalf("Invalid SARIF output: %s\n%s", err, stdout)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("Expected SARIF 2.1.0 log with one run:\n%s", stdout)
+	}
+	results := log.Runs[0].Results
+	last := results[len(results)-1]
+	if len(last.Fixes) != 1 || len(last.Fixes[0].ArtifactChanges) != 1 {
+		t.Fatalf("Expected a fix with the proposed change:\n%s", stdout)
+	}
+	repls := last.Fixes[0].ArtifactChanges[0].Replacements
+	if len(repls) != 2 {
+		t.Fatalf("Expected 2 replacements; got %d", len(repls))
+	}
+	r := repls[0]
+	if r.InsertedContent.Text != "z" ||
+		r.DeletedRegion.StartLine != 2 || r.DeletedRegion.StartColumn != 5 ||
+		r.DeletedRegion.EndLine != 2 || r.DeletedRegion.EndColumn != 9 ||
+		r.DeletedRegion.ByteOffset != 17 || r.DeletedRegion.ByteLength != 5 {
+		t.Fatalf("Incorrect replacement: %+v", r)
+	}
+}
+
+func TestSARIFRegionAfterEdit(t *testing.T) {
+	// Renaming msgé to z shortens line 4 by 4 bytes, but the warning on
+	// line 5 must still be reported at its position in the original file
+	const program = `package main
+var msgé string
+func main() {
+	msgé = "x"
+	_ = undefinedName
+}
+`
+	_, stdout, _ := runCLI(program, "-scope=-", noImportsPos, "-sarif", "rename", "z")
+	var log struct {
+		Runs []struct {
+			Results []struct {
+				Message   struct{ Text string }
+				Locations []struct {
+					PhysicalLocation struct {
+						Region struct {
+							StartLine, StartColumn int
+							ByteOffset             int
+						}
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(stdout), &log); err != nil || len(log.Runs) != 1 {
+		t.Fatalf("Invalid SARIF output: %v\n%s", err, stdout)
+	}
+	found := false
+	for _, result := range log.Runs[0].Results {
+		if !strings.Contains(result.Message.Text, "undefinedName") {
+			continue
+		}
+		found = true
+		if len(result.Locations) != 1 {
+			t.Fatalf("Expected one location for %q", result.Message.Text)
+		}
+		r := result.Locations[0].PhysicalLocation.Region
+		if r.StartLine != 5 || r.StartColumn != 6 || r.ByteOffset != 62 {
+			t.Fatalf("Expected region at 5:6 (offset 62); got %+v", r)
+		}
+	}
+	if !found {
+		t.Fatalf("Expected a result for undefinedName:\n%s", stdout)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin freebsd netbsd openbsd solaris
+
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/godoctor/godoctor/internal/github.com/cheggaaa/pb"
+)
+
+// A progressBar displays a refactoring's progress on standard error, if
+// standard error is a terminal.  Each task reported by the refactoring is
+// displayed on a separate progress bar.
+type progressBar struct {
+	out  io.Writer
+	task string
+	bar  *pb.ProgressBar
+}
+
+// newProgressBar returns a progressBar that writes to the given writer, or
+// nil if the writer is not a terminal.
+func newProgressBar(out io.Writer) *progressBar {
+	if !isTerminal(out) {
+		return nil
+	}
+	return &progressBar{out: out}
+}
+
+// update is a refactoring.ProgressFunc that updates the progress bar.
+func (p *progressBar) update(task string, done, total int) {
+	if p == nil {
+		return
+	}
+	if p.bar == nil || task != p.task || int64(total) != p.bar.Total {
+		p.finish()
+		p.task = task
+		p.bar = pb.New(total)
+		p.bar.NotPrint = true
+		p.bar.ShowTimeLeft = false
+		p.bar.ShowFinalTime = false
+		p.bar.Callback = func(line string) {
+			fmt.Fprintf(p.out, "\r%s", line)
+		}
+		p.bar.Prefix(task + " ").Start()
+	}
+	p.bar.Set(done)
+}
+
+// finish stops the current progress bar, if any, and erases it.
+func (p *progressBar) finish() {
+	if p == nil || p.bar == nil {
+		return
+	}
+	p.bar.Finish()
+	p.bar = nil
+	fmt.Fprintf(p.out, "\r\033[K")
+}
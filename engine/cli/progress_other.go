@@ -0,0 +1,24 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!solaris
+
+package cli
+
+import "io"
+
+// A progressBar displays a refactoring's progress.  The vendored progress bar
+// package does not support this platform, so progress is not displayed.
+type progressBar struct{}
+
+// newProgressBar returns nil; progress is not displayed on this platform.
+func newProgressBar(out io.Writer) *progressBar {
+	return nil
+}
+
+// update is a refactoring.ProgressFunc that does nothing.
+func (p *progressBar) update(task string, done, total int) {}
+
+// finish does nothing.
+func (p *progressBar) finish() {}
@@ -0,0 +1,325 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file writes a refactoring's log and proposed changes in the Static
+// Analysis Results Interchange Format (SARIF), version 2.1.0, so they can be
+// displayed by code review tools.  Each log entry becomes a SARIF result; the
+// refactoring's changes are reported as a fix on one additional result.
+//
+// Regions use 1-based lines and UTF-16 columns (the SARIF default), and they
+// also give byte offsets.  All regions refer to the original files, since
+// that is what the fixes apply to; log entries that refer to the refactored
+// files are mapped back to the original files (see Log.OriginalOffset).
+
+package cli
+
+import (
+	"encoding/json"
+	"go/token"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/godoctor/godoctor/filesystem"
+	"github.com/godoctor/godoctor/refactoring"
+	"github.com/godoctor/godoctor/text"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool      `json:"tool"`
+	Results []*sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	FullName       string      `json:"fullName,omitempty"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules,omitempty"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID           string           `json:"ruleId,omitempty"`
+	Level            string           `json:"level"`
+	Message          sarifMessage     `json:"message"`
+	Locations        []*sarifLocation `json:"locations,omitempty"`
+	RelatedLocations []*sarifLocation `json:"relatedLocations,omitempty"`
+	Fixes            []*sarifFix      `json:"fixes,omitempty"`
+}
+
+type sarifLocation struct {
+	ID               *int                  `json:"id,omitempty"`
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	Message          *sarifMessage         `json:"message,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int  `json:"startLine,omitempty"`
+	StartColumn int  `json:"startColumn,omitempty"`
+	EndLine     int  `json:"endLine,omitempty"`
+	EndColumn   int  `json:"endColumn,omitempty"`
+	ByteOffset  *int `json:"byteOffset,omitempty"`
+	ByteLength  *int `json:"byteLength,omitempty"`
+}
+
+type sarifFix struct {
+	Description     sarifMessage           `json:"description"`
+	ArtifactChanges []*sarifArtifactChange `json:"artifactChanges"`
+}
+
+type sarifArtifactChange struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Replacements     []*sarifReplacement   `json:"replacements"`
+}
+
+type sarifReplacement struct {
+	DeletedRegion   sarifRegion   `json:"deletedRegion"`
+	InsertedContent *sarifMessage `json:"insertedContent,omitempty"`
+}
+
+// sarifWriter converts positions and edits to SARIF locations and fixes,
+// reading the original files' contents from a FileSystem to compute UTF-16
+// columns.
+type sarifWriter struct {
+	log      *refactoring.Log
+	contents *filesystem.ContentCache
+}
+
+// writeSARIF outputs a SARIF log describing the given refactoring result.
+// The selection, if valid, is used as the location of the proposed change.
+func writeSARIF(out io.Writer, aboutText string, refac refactoring.Refactoring, selection text.Selection, result *refactoring.Result, fs filesystem.FileSystem) error {
+	w := &sarifWriter{
+		log:      result.Log,
+		contents: filesystem.NewContentCache(fs),
+	}
+
+	results := []*sarifResult{}
+	codes := map[string]bool{}
+	for _, entry := range result.Log.Entries {
+		res := &sarifResult{
+			RuleID:  entry.Code,
+			Level:   sarifLevel(entry.Severity),
+			Message: sarifMessage{entry.Message},
+		}
+		if loc := w.entryLocation(entry.Filename, entry.Offset, entry.Length); loc != nil {
+			res.Locations = []*sarifLocation{loc}
+		}
+		for i, related := range entry.Related {
+			if loc := w.entryLocation(related.Filename, related.Offset, related.Length); loc != nil {
+				id := i + 1
+				loc.ID = &id
+				loc.Message = &sarifMessage{related.Message}
+				res.RelatedLocations = append(res.RelatedLocations, loc)
+			}
+		}
+		for _, fix := range entry.Fixes {
+			if f := w.fix(fix.Description, fix.Edits); f != nil {
+				res.Fixes = append(res.Fixes, f)
+			}
+		}
+		if entry.Code != "" {
+			codes[entry.Code] = true
+		}
+		results = append(results, res)
+	}
+
+	name := refac.Description().Name
+	if fix := w.fix(name, result.Edits); fix != nil {
+		res := &sarifResult{
+			Level:   "note",
+			Message: sarifMessage{"Proposed change: " + name},
+			Fixes:   []*sarifFix{fix},
+		}
+		if selection != nil {
+			if loc := w.selectionLocation(selection); loc != nil {
+				res.Locations = []*sarifLocation{loc}
+			}
+		}
+		results = append(results, res)
+	}
+
+	sortedCodes := []string{}
+	for code := range codes {
+		sortedCodes = append(sortedCodes, code)
+	}
+	sort.Strings(sortedCodes)
+	rules := []sarifRule{}
+	for _, code := range sortedCodes {
+		rules = append(rules, sarifRule{code})
+	}
+
+	log := sarifLog{
+		Version: sarifVersion,
+		Schema:  sarifSchema,
+		Runs: []sarifRun{{
+			Tool: sarifTool{sarifDriver{
+				Name:           "godoctor",
+				FullName:       aboutText,
+				InformationURI: "http://gorefactor.org",
+				Rules:          rules,
+			}},
+			Results: results,
+		}},
+	}
+	data, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = out.Write(data)
+	return err
+}
+
+// sarifLevel returns the SARIF level corresponding to a log entry's severity.
+func sarifLevel(severity refactoring.Severity) string {
+	switch severity {
+	case refactoring.Warning:
+		return "warning"
+	case refactoring.Error:
+		return "error"
+	default:
+		return "note"
+	}
+}
+
+// entryLocation returns a SARIF location for a log entry's region, given in
+// the coordinates of the file the log refers to, or nil if filename is "".
+func (w *sarifWriter) entryLocation(filename string, offset, length int) *sarifLocation {
+	offset, length = w.log.OriginalOffset(filename, offset, length)
+	return w.location(filename, offset, length)
+}
+
+// selectionLocation returns a SARIF location for the given selection in the
+// original file, or nil if the file cannot be read or the selection is
+// invalid.
+func (w *sarifWriter) selectionLocation(selection text.Selection) *sarifLocation {
+	filename, err := filepath.Abs(selection.GetFilename())
+	if err != nil {
+		return nil
+	}
+	data := w.contents.Contents(filename)
+	if data == nil {
+		return nil
+	}
+	fset := token.NewFileSet()
+	file := fset.AddFile(selection.GetFilename(), -1, len(data))
+	file.SetLinesForContent(data)
+	start, end, err := selection.Convert(fset)
+	if err != nil {
+		return nil
+	}
+	offset := file.Offset(start)
+	return w.location(filename, offset, file.Offset(end)-offset)
+}
+
+// location returns a SARIF location for the region of the given (original)
+// file with the given byte offset and length, or nil if filename is "".
+func (w *sarifWriter) location(filename string, offset, length int) *sarifLocation {
+	if filename == "" {
+		return nil
+	}
+	return &sarifLocation{PhysicalLocation: sarifPhysicalLocation{
+		ArtifactLocation: sarifArtifactLocation{sarifURI(filename)},
+		Region:           w.region(filename, offset, length),
+	}}
+}
+
+// fix returns a SARIF fix that applies the given edits, or nil if there are
+// no edits.
+func (w *sarifWriter) fix(description string, edits map[string]*text.EditSet) *sarifFix {
+	filenames := []string{}
+	for filename := range edits {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	fix := &sarifFix{Description: sarifMessage{description}}
+	for _, filename := range filenames {
+		change := &sarifArtifactChange{
+			ArtifactLocation: sarifArtifactLocation{sarifURI(filename)},
+			Replacements:     []*sarifReplacement{},
+		}
+		edits[filename].Iterate(func(extent *text.Extent, replacement string) bool {
+			r := &sarifReplacement{
+				DeletedRegion: *w.region(filename, extent.Offset, extent.Length),
+			}
+			if replacement != "" {
+				r.InsertedContent = &sarifMessage{replacement}
+			}
+			change.Replacements = append(change.Replacements, r)
+			return true
+		})
+		if len(change.Replacements) > 0 {
+			fix.ArtifactChanges = append(fix.ArtifactChanges, change)
+		}
+	}
+	if len(fix.ArtifactChanges) == 0 {
+		return nil
+	}
+	return fix
+}
+
+// region returns a SARIF region for the given byte offset and length in the
+// given file.  Line and column information is included if the file's
+// contents can be read.
+func (w *sarifWriter) region(filename string, offset, length int) *sarifRegion {
+	region := &sarifRegion{ByteOffset: &offset, ByteLength: &length}
+	startLine, startCol, ok := w.contents.Position(filename, offset, text.UTF16Columns)
+	if !ok {
+		return region
+	}
+	endLine, endCol, ok := w.contents.Position(filename, offset+length, text.UTF16Columns)
+	if !ok {
+		return region
+	}
+	region.StartLine, region.StartColumn = startLine, startCol
+	region.EndLine, region.EndColumn = endLine, endCol
+	return region
+}
+
+// sarifURI returns a URI for the given file: a path relative to the current
+// directory if possible, and otherwise a file URI.
+func sarifURI(filename string) string {
+	path := filename
+	if stdinPath, _ := filesystem.FakeStdinPath(); filename == stdinPath {
+		path = os.Stdin.Name()
+	} else if rel := relativePath(filename); !filepath.IsAbs(rel) {
+		return filepath.ToSlash(rel)
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	return u.String()
+}
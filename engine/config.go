@@ -0,0 +1,159 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file defines project configuration files, which provide default
+// settings (scope, build tags, etc.) for refactorings invoked on files in a
+// particular project.
+
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/godoctor/godoctor/refactoring"
+)
+
+// ProjectConfigFilename is the name of a project configuration file.  See
+// FindProjectConfig.
+const ProjectConfigFilename = ".godoctor.json"
+
+// A ProjectConfig provides default settings for refactorings invoked on files
+// in a particular project.  It is read from a JSON file of the form
+//
+//     {
+//         "scope":     ["github.com/user/project/cmd/tool"],
+//         "tests":     false,
+//         "exclude":   ["vendor", "testdata"],
+//         "tags":      ["integration"],
+//         "builds":    ["linux/amd64", "windows/amd64"],
+//         "verbosity": 1,
+//         "args":      {"rename": ["newName", true]}
+//     }
+//
+// All keys are optional.  Relative paths in "exclude" (and .go files in
+// "scope") are interpreted relative to the directory containing the
+// configuration file.  The "args" key maps refactoring short names to default
+// arguments, which are used when fewer arguments are supplied.
+type ProjectConfig struct {
+	// The configuration file from which this ProjectConfig was read
+	Filename string `json:"-"`
+	// The default scope (see refactoring.Config.Scope)
+	Scope []string `json:"scope"`
+	// Whether to load test files; if nil, they are loaded
+	Tests *bool `json:"tests"`
+	// Directories to exclude from loading and editing
+	Exclude []string `json:"exclude"`
+	// Additional build tags
+	Tags []string `json:"tags"`
+	// Build configurations to refactor under, in the form accepted by
+	// refactoring.ParseBuildConfig
+	Builds []string `json:"builds"`
+	// Parsed build configurations
+	buildConfigs []refactoring.BuildConfig
+	// The default verbosity (see refactoring.Config.Verbosity)
+	Verbosity int `json:"verbosity"`
+	// Default arguments, keyed by refactoring short name
+	Args map[string][]interface{} `json:"args"`
+}
+
+// FindProjectConfig searches for a project configuration file in the directory
+// containing the given file (or in the given directory, if path is a
+// directory) and each of its ancestors, returning the first one found.  If
+// there is no configuration file, it returns nil and no error.
+func FindProjectConfig(path string) (*ProjectConfig, error) {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	for {
+		filename := filepath.Join(dir, ProjectConfigFilename)
+		if _, err := os.Stat(filename); err == nil {
+			return ReadProjectConfig(filename)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// ReadProjectConfig reads the given project configuration file.
+func ReadProjectConfig(filename string) (*ProjectConfig, error) {
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	result := &ProjectConfig{}
+	if err := json.Unmarshal(bytes, result); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	if result.Verbosity < 0 || result.Verbosity > 2 {
+		return nil, fmt.Errorf("%s: verbosity must be 0, 1, or 2",
+			filename)
+	}
+	result.Filename = filename
+	for _, build := range result.Builds {
+		bc, err := refactoring.ParseBuildConfig(build)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+		result.buildConfigs = append(result.buildConfigs, bc)
+	}
+
+	dir := filepath.Dir(filename)
+	for i, scope := range result.Scope {
+		if strings.HasSuffix(scope, ".go") && !filepath.IsAbs(scope) {
+			result.Scope[i] = filepath.Join(dir, scope)
+		}
+	}
+	for i, exclude := range result.Exclude {
+		if !filepath.IsAbs(exclude) {
+			result.Exclude[i] = filepath.Join(dir, exclude)
+		}
+	}
+	return result, nil
+}
+
+// Apply fills in settings from this ProjectConfig that were not set explicitly
+// in the given refactoring.Config.  The scope, build configurations, and
+// verbosity are set only if they are empty (or 0), excluded directories and
+// build tags are added to those already present, and default arguments for
+// the refactoring with the given short name are appended to any arguments
+// already supplied.  Apply does nothing if the ProjectConfig is nil.  Since an
+// explicit verbosity of 0 is indistinguishable from an unset one, a caller
+// that allows it (e.g., the command line's -v=false) must set the verbosity
+// again after calling Apply.
+func (c *ProjectConfig) Apply(config *refactoring.Config, shortName string) {
+	if c == nil {
+		return
+	}
+	if config.Scope == nil && len(c.Scope) > 0 {
+		config.Scope = c.Scope
+	}
+	if c.Tests != nil {
+		config.ExcludeTests = !*c.Tests
+	}
+	config.ExcludeDirs = append(config.ExcludeDirs, c.Exclude...)
+	config.BuildTags = append(config.BuildTags, c.Tags...)
+	if len(config.BuildConfigs) == 0 {
+		config.BuildConfigs = c.buildConfigs
+	}
+	if config.Verbosity == 0 {
+		config.Verbosity = c.Verbosity
+	}
+	if defaults, ok := c.Args[shortName]; ok {
+		for i := len(config.Args); i < len(defaults); i++ {
+			config.Args = append(config.Args, defaults[i])
+		}
+	}
+}
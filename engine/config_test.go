@@ -0,0 +1,77 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package engine_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/godoctor/godoctor/engine"
+	"github.com/godoctor/godoctor/refactoring"
+)
+
+func TestProjectConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "godoctor-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	subdir := filepath.Join(dir, "a", "b")
+	if err := os.MkdirAll(subdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(subdir, "main.go")
+	if err := ioutil.WriteFile(file, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	project, err := engine.FindProjectConfig(file)
+	if err != nil || project != nil {
+		t.Fatalf("Expected no project configuration, got %v, %v",
+			project, err)
+	}
+
+	json := `{"scope": ["main.go"], "tests": false, "exclude": ["gen"],
+		"tags": ["integration"], "verbosity": 1,
+		"args": {"rename": ["newName", true]}}`
+	filename := filepath.Join(dir, engine.ProjectConfigFilename)
+	if err := ioutil.WriteFile(filename, []byte(json), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	project, err = engine.FindProjectConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project == nil || project.Filename != filename {
+		t.Fatalf("Did not find %s", filename)
+	}
+
+	config := &refactoring.Config{Args: []interface{}{"x"}}
+	project.Apply(config, "rename")
+	expected := &refactoring.Config{
+		Scope:        []string{filepath.Join(dir, "main.go")},
+		ExcludeTests: true,
+		ExcludeDirs:  []string{filepath.Join(dir, "gen")},
+		BuildTags:    []string{"integration"},
+		Verbosity:    1,
+		Args:         []interface{}{"x", true},
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Fatalf("Expected %#v, got %#v", expected, config)
+	}
+
+	config = &refactoring.Config{Scope: []string{"pkg"}, Verbosity: 2}
+	project.Apply(config, "toggle")
+	if config.Scope[0] != "pkg" || config.Verbosity != 2 ||
+		config.Args != nil {
+		t.Fatalf("Explicit settings should not be overridden: %#v",
+			config)
+	}
+}
@@ -25,6 +25,9 @@ func init() {
 	AddRefactoring("rename", new(refactoring.Rename))
 	AddRefactoring("toggle", new(refactoring.ToggleVar))
 	AddRefactoring("godoc", new(refactoring.AddGoDoc))
+	AddRefactoring("imports", new(refactoring.OrganizeImports))
+	AddRefactoring("implement", new(refactoring.ImplementInterface))
+	AddRefactoring("apply", new(refactoring.ApplyPatch))
 	AddRefactoring("debug", new(refactoring.Debug))
 	AddRefactoring("null", new(refactoring.Null))
 }
@@ -0,0 +1,102 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file loads refactorings implemented by external executables ("plugins")
+// from a configuration file.  See refactoring/plugin.go for the protocol used
+// to communicate with plugins.
+
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/godoctor/godoctor/refactoring"
+)
+
+// PluginConfigEnv is the name of an environment variable that, if set,
+// contains the path of the plugin configuration file.
+const PluginConfigEnv = "GODOCTOR_PLUGINS"
+
+// A PluginConfig describes a single plugin in a plugin configuration file.
+//
+// A plugin configuration file is a JSON file of the form
+//
+//     {"plugins": [
+//         {"name": "extract", "command": "/path/to/extract", "args": ["-v"]}
+//     ]}
+//
+// where name is the short name used to invoke the refactoring (e.g., with the
+// -refactoring flag on the command line), command is the plugin executable,
+// and args are additional command line arguments to pass to it.
+type PluginConfig struct {
+	Name    string   `json:"name"`
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+// DefaultPluginConfigFile returns the path of the plugin configuration file:
+// the value of the GODOCTOR_PLUGINS environment variable, if it is set, or
+// .godoctor/plugins.json in the user's home directory otherwise.
+func DefaultPluginConfigFile() string {
+	if filename := os.Getenv(PluginConfigEnv); filename != "" {
+		return filename
+	}
+	home := os.Getenv("HOME")
+	if home == "" {
+		home = os.Getenv("USERPROFILE")
+	}
+	return filepath.Join(home, ".godoctor", "plugins.json")
+}
+
+// LoadPlugins reads the given plugin configuration file and adds each plugin
+// listed in it to the refactoring engine, as if by AddRefactoring.  A missing
+// configuration file is not an error; in that case, no plugins are added.  A
+// plugin that cannot be added does not prevent the others from being added;
+// the returned error describes every plugin that was skipped.  Invoke this
+// method before starting the command line or protocol driver.
+//
+// The plugin executables are not started here; each is asked to describe
+// itself only when it is first looked up or listed, so a slow or broken plugin
+// does not delay unrelated refactorings.
+func LoadPlugins(filename string) error {
+	bytes, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var config struct {
+		Plugins []PluginConfig `json:"plugins"`
+	}
+	if err := json.Unmarshal(bytes, &config); err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+
+	errors := []string{}
+	for i, plugin := range config.Plugins {
+		if err := loadPlugin(plugin); err != nil {
+			errors = append(errors, fmt.Sprintf(
+				"%s: skipping plugin %d (%s): %s",
+				filename, i+1, plugin.Name, err))
+		}
+	}
+	if len(errors) > 0 {
+		return fmt.Errorf("%s", strings.Join(errors, "\n"))
+	}
+	return nil
+}
+
+func loadPlugin(plugin PluginConfig) error {
+	if plugin.Name == "" || plugin.Command == "" {
+		return fmt.Errorf("every plugin must have a name and a command")
+	}
+	return AddRefactoring(plugin.Name,
+		refactoring.NewPlugin(plugin.Command, plugin.Args...))
+}
@@ -0,0 +1,266 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package engine_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godoctor/godoctor/engine"
+	"github.com/godoctor/godoctor/filesystem"
+	"github.com/godoctor/godoctor/refactoring"
+	"github.com/godoctor/godoctor/text"
+)
+
+// pluginScript is a plugin that inserts a comment at the beginning of the
+// selected file (at offset 0 of main.go) and logs a warning.
+const pluginScript = `#!/bin/sh
+read request
+case "$request" in
+*'"describe"'*)
+	printf '%s\n' '{"description":{"Name":"Add Comment","Synopsis":"Adds a comment","Usage":"","Params":null}}'
+	;;
+*)
+	file=$(echo "$request" | sed 's/.*"selection":{"filename":"\([^"]*\)".*/\1/')
+	printf '%s\n' '{"edits":{"'"$file"'":[{"offset":0,"length":0,"replacement":"// Hello\n"}]},"log":[{"severity":"warning","message":"Plugin warning"}]}'
+	;;
+esac
+`
+
+func TestPlugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin test requires a POSIX shell")
+	}
+
+	dir, err := ioutil.TempDir("", "godoctor-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "plugin.sh")
+	if err := ioutil.WriteFile(script, []byte(pluginScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(dir, "plugins.json")
+	json := `{"plugins":[{"name":"zz_plugin","command":"` + script + `"}]}`
+	if err := ioutil.WriteFile(config, []byte(json), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mainFile := filepath.Join(dir, "main.go")
+	src := "package main\n\nfunc main() {}\n"
+	if err := ioutil.WriteFile(mainFile, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.LoadPlugins(filepath.Join(dir, "missing.json")); err != nil {
+		t.Fatalf("Missing config file should be ignored: %s", err)
+	}
+	if err := engine.LoadPlugins(config); err != nil {
+		t.Fatal(err)
+	}
+	r := engine.GetRefactoring("zz_plugin")
+	if r == nil {
+		t.Fatalf("Plugin was not added")
+	}
+	if r.Description().Name != "Add Comment" {
+		t.Fatalf("Incorrect description: %s", r.Description().Name)
+	}
+
+	result := r.Run(&refactoring.Config{
+		FileSystem: filesystem.NewLocalFileSystem(),
+		Scope:      []string{mainFile},
+		Selection: &text.OffsetLengthSelection{
+			Filename: mainFile,
+			Offset:   20,
+			Length:   4,
+		},
+	})
+	if result.Log.ContainsErrors() {
+		t.Fatalf("Unexpected errors:\n%s", result.Log)
+	}
+	found := false
+	for _, entry := range result.Log.Entries {
+		if entry.Severity == refactoring.Warning &&
+			entry.Message == "Plugin warning" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Plugin warning was not logged:\n%s", result.Log)
+	}
+	edits, ok := result.Edits[mainFile]
+	if !ok {
+		t.Fatalf("No edits to %s", mainFile)
+	}
+	output, err := text.ApplyToString(edits, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "// Hello\n"+src {
+		t.Fatalf("Incorrect output:\n%s", output)
+	}
+}
+
+func TestPluginErrors(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin test requires a POSIX shell")
+	}
+
+	dir, err := ioutil.TempDir("", "godoctor-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A plugin that produces an edit with a negative offset
+	script := filepath.Join(dir, "plugin.sh")
+	negative := strings.Replace(pluginScript, `"offset":0`, `"offset":-1`, 1)
+	if err := ioutil.WriteFile(script, []byte(negative), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// A plugin without a command should be skipped, but the plugin after
+	// it should still be loaded.  A plugin whose executable is missing is
+	// not started until it is used, so it is loaded but fails when run.
+	config := filepath.Join(dir, "plugins.json")
+	json := `{"plugins":[{"name":"zz_broken"},` +
+		`{"name":"zz_negative","command":"` + script + `"},` +
+		`{"name":"zz_missing","command":"` + filepath.Join(dir, "missing") + `"}]}`
+	if err := ioutil.WriteFile(config, []byte(json), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mainFile := filepath.Join(dir, "main.go")
+	src := "package main\n\nfunc main() {}\n"
+	if err := ioutil.WriteFile(mainFile, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = engine.LoadPlugins(config)
+	if err == nil || !strings.Contains(err.Error(), "zz_broken") ||
+		strings.Contains(err.Error(), "zz_missing") {
+		t.Fatalf("Expected an error for zz_broken only; got %v", err)
+	}
+	if engine.GetRefactoring("zz_broken") != nil {
+		t.Fatalf("Broken plugin was added")
+	}
+	r := engine.GetRefactoring("zz_negative")
+	if r == nil {
+		t.Fatalf("Plugin after the broken plugin was not added")
+	}
+
+	result := r.Run(&refactoring.Config{
+		FileSystem: filesystem.NewLocalFileSystem(),
+		Scope:      []string{mainFile},
+		Selection: &text.OffsetLengthSelection{
+			Filename: mainFile,
+			Offset:   20,
+			Length:   4,
+		},
+	})
+	if !strings.Contains(result.Log.String(), "negative offset") {
+		t.Fatalf("Negative offset was not reported:\n%s", result.Log)
+	}
+	if code := result.Log.Entries[len(result.Log.Entries)-1].Code; code != "plugin/invalid-edit" {
+		t.Fatalf("Expected code plugin/invalid-edit, got %q", code)
+	}
+
+	// Arguments are checked before the program is loaded
+	result = r.Run(&refactoring.Config{
+		FileSystem: filesystem.NewLocalFileSystem(),
+		Scope:      []string{filepath.Join(dir, "missing.go")},
+		Selection: &text.OffsetLengthSelection{
+			Filename: mainFile,
+			Offset:   20,
+			Length:   4,
+		},
+		Args: []interface{}{"extra"},
+	})
+	if len(result.Log.Entries) != 1 || result.Log.Entries[0].Code != "args/count" {
+		t.Fatalf("Expected only an argument count error:\n%s", result.Log)
+	}
+
+	missing := engine.GetRefactoring("zz_missing")
+	if missing == nil {
+		t.Fatalf("Plugin with a missing executable was not added")
+	}
+	if !missing.Description().Hidden {
+		t.Fatalf("Plugin with a missing executable should be hidden")
+	}
+	result = missing.Run(&refactoring.Config{
+		FileSystem: filesystem.NewLocalFileSystem(),
+		Scope:      []string{mainFile},
+		Selection: &text.OffsetLengthSelection{
+			Filename: mainFile,
+			Offset:   20,
+			Length:   4,
+		},
+	})
+	if !result.Log.ContainsErrors() {
+		t.Fatalf("Missing executable was not reported:\n%s", result.Log)
+	}
+}
+
+func TestPluginCanceled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin test requires a POSIX shell")
+	}
+
+	dir, err := ioutil.TempDir("", "godoctor-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A plugin that never responds to a run request
+	script := filepath.Join(dir, "plugin.sh")
+	slow := strings.Replace(pluginScript, "\tfile=", "\texec sleep 60\n\tfile=", 1)
+	if err := ioutil.WriteFile(script, []byte(slow), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(dir, "plugins.json")
+	json := `{"plugins":[{"name":"zz_slow","command":"` + script + `"}]}`
+	if err := ioutil.WriteFile(config, []byte(json), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mainFile := filepath.Join(dir, "main.go")
+	src := "package main\n\nfunc main() {}\n"
+	if err := ioutil.WriteFile(mainFile, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.LoadPlugins(config); err != nil {
+		t.Fatal(err)
+	}
+	r := engine.GetRefactoring("zz_slow")
+	if r == nil {
+		t.Fatalf("Plugin was not added")
+	}
+	r.Description()
+
+	cancel := make(chan struct{})
+	time.AfterFunc(500*time.Millisecond, func() { close(cancel) })
+	start := time.Now()
+	result := r.Run(&refactoring.Config{
+		FileSystem: filesystem.NewLocalFileSystem(),
+		Scope:      []string{mainFile},
+		Selection: &text.OffsetLengthSelection{
+			Filename: mainFile,
+			Offset:   20,
+			Length:   4,
+		},
+		Cancel: cancel,
+	})
+	if !strings.Contains(result.Log.String(), refactoring.ErrCanceled.Error()) {
+		t.Fatalf("Cancellation was not reported:\n%s", result.Log)
+	}
+	if time.Since(start) > 30*time.Second {
+		t.Fatalf("Plugin was not killed when the refactoring was canceled")
+	}
+}
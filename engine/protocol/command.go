@@ -7,6 +7,7 @@ package protocol
 import (
 	"errors"
 	"fmt"
+	"go/build"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -50,8 +51,10 @@ func (a *About) Validate(state *State, input map[string]interface{}) (bool, erro
 
 // -=-= List =-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-
 
-// TODO add in implementation of fileselection and textselection keys
+// TODO add in implementation of fileselection key
 
+// If a textselection is given, only the refactorings that are applicable to
+// that selection are listed (see refactoring.PreconditionChecker).
 type List struct {
 	Fileselection []string               `json:"fileselection"`
 	Textselection map[string]interface{} `json:"textselection"`
@@ -68,13 +71,57 @@ func (l *List) Run(state *State, input map[string]interface{}) (Reply, error) {
 			hiddenOK = false
 		}
 
+		// if a text selection was given, list only the refactorings
+		// that are applicable to it
+		var config *refactoring.Config
+		if textselection, found := input["textselection"]; found {
+			selection, ok := textselection.(map[string]interface{})
+			if !ok {
+				err := errors.New("textselection must be an object")
+				return Reply{map[string]interface{}{"reply": "Error", "message": err.Error()}}, err
+			}
+			ts, err := parseSelection(state, selection)
+			if err != nil {
+				return Reply{map[string]interface{}{"reply": "Error", "message": err.Error()}}, err
+			}
+			ts, err = resolveStdin(ts)
+			if err != nil {
+				return Reply{map[string]interface{}{"reply": "Error", "message": err.Error()}}, err
+			}
+			config = &refactoring.Config{
+				FileSystem: state.Filesystem,
+				Scope:      nil,
+				Selection:  ts,
+			}
+			project, err := engine.FindProjectConfig(ts.GetFilename())
+			if err != nil {
+				return Reply{map[string]interface{}{"reply": "Error", "message": err.Error()}}, err
+			}
+			project.Apply(config, "")
+		}
+
 		// get all of the refactoring names
-		namesList := make([]map[string]string, 0)
+		shortNames := []string{}
+		refactorings := []refactoring.Refactoring{}
 		for _, shortName := range engine.AllRefactoringNames() {
-			refactoring := engine.GetRefactoring(shortName)
-			if hiddenOK || !refactoring.Description().Hidden {
-				namesList = append(namesList, map[string]string{"shortName": shortName, "name": refactoring.Description().Name})
+			r := engine.GetRefactoring(shortName)
+			if !hiddenOK && r.Description().Hidden {
+				continue
 			}
+			shortNames = append(shortNames, shortName)
+			refactorings = append(refactorings, r)
+		}
+		// the program is loaded once and shared by all of the checks
+		var applicable []bool
+		if config != nil {
+			applicable = refactoring.AreApplicable(refactorings, config)
+		}
+		namesList := make([]map[string]string, 0)
+		for i, r := range refactorings {
+			if applicable != nil && !applicable[i] {
+				continue
+			}
+			namesList = append(namesList, map[string]string{"shortName": shortNames[i], "name": r.Description().Name})
 		}
 		return Reply{map[string]interface{}{"reply": "OK", "transformations": namesList}}, nil
 	} else {
@@ -112,7 +159,11 @@ func (l *List) Validate(state *State, input map[string]interface{}) (bool, error
 		if state.State < 2 {
 			return false, errors.New("File system not yet configured, cannot use textselection")
 		}
-		_, err := parseSelection(state, textselection.(map[string]interface{}))
+		selection, ok := textselection.(map[string]interface{})
+		if !ok {
+			return false, errors.New("textselection must be an object")
+		}
+		_, err := parseSelection(state, selection)
 		if err != nil {
 			return false, err
 		}
@@ -158,7 +209,7 @@ func (p *Params) Run(state *State, input map[string]interface{}) (Reply, error)
 		// since GetParams returns just a string, assume it as prompt and label
 		params := make([]map[string]interface{}, 0)
 		for _, param := range refactoring.Description().Params {
-			params = append(params, map[string]interface{}{"label": param.Label, "prompt": param.Prompt, "type": reflect.TypeOf(param.DefaultValue).String(), "default": param.DefaultValue})
+			params = append(params, paramInfo(param))
 		}
 		return Reply{map[string]interface{}{"reply": "OK", "params": params}}, nil
 	} else {
@@ -166,6 +217,39 @@ func (p *Params) Run(state *State, input map[string]interface{}) (Reply, error)
 	}
 }
 
+// paramInfo describes a refactoring parameter for the params reply.  The
+// "type" is the Go type of the argument (string, bool, or int), while the
+// "kind" describes how it is validated; additional keys are included for
+// kinds that carry validation metadata.
+func paramInfo(param refactoring.Parameter) map[string]interface{} {
+	kind := param.EffectiveKind()
+	var typ string
+	switch kind {
+	case refactoring.BoolParam:
+		typ = "bool"
+	case refactoring.IntParam:
+		typ = "int"
+	default:
+		typ = "string"
+	}
+	info := map[string]interface{}{"label": param.Label, "prompt": param.Prompt, "type": typ, "kind": kind.String(), "default": param.DefaultValue}
+	switch kind {
+	case refactoring.EnumParam:
+		info["choices"] = param.Choices
+	case refactoring.IntParam:
+		if param.Min < param.Max {
+			info["min"] = param.Min
+			info["max"] = param.Max
+		}
+	case refactoring.FileParam:
+		info["mustExist"] = param.MustExist
+	}
+	if param.Optional {
+		info["optional"] = true
+	}
+	return info
+}
+
 func (p *Params) Validate(state *State, input map[string]interface{}) (bool, error) {
 	if state.State < 2 {
 		return false, errors.New("State of 2 (file system configured) is required")
@@ -181,7 +265,11 @@ func (p *Params) Validate(state *State, input map[string]interface{}) (bool, err
 	if tsfound && fsfound {
 		return false, errors.New("Both textseleciton and fileselection cannot be used together")
 	} else if tsfound {
-		_, err := parseSelection(state, textselection.(map[string]interface{}))
+		selection, ok := textselection.(map[string]interface{})
+		if !ok {
+			return false, errors.New("textselection must be an object")
+		}
+		_, err := parseSelection(state, selection)
 		if err != nil {
 			return false, err
 		}
@@ -335,19 +423,25 @@ func (x *XRun) Run(state *State, input map[string]interface{}) (Reply, error) {
 	textselection := input["textselection"].(map[string]interface{})
 
 	ts, _ := parseSelection(state, textselection)
+	ts, err := resolveStdin(ts)
+	if err != nil {
+		return Reply{map[string]interface{}{"reply": "Error",
+			"message": err.Error()}}, err
+	}
 
-	if ts.GetFilename() == filesystem.FakeStdinFilename {
-		stdinPath, err := filesystem.FakeStdinPath()
+	// If "columns" is given, line/column selections and log positions
+	// count columns in that mode (e.g., "utf16" for LSP clients)
+	var lineCols *lineColInfo
+	if name, ok := input["columns"].(string); ok {
+		columns, err := text.ParseColumnMode(name)
 		if err != nil {
 			return Reply{map[string]interface{}{"reply": "Error",
 				"message": err.Error()}}, err
 		}
-		switch ts := ts.(type) {
-		case *text.OffsetLengthSelection:
-			ts.Filename = stdinPath
-		case *text.LineColSelection:
-			ts.Filename = stdinPath
+		if lc, ok := ts.(*text.LineColSelection); ok {
+			lc.Columns = columns
 		}
+		lineCols = &lineColInfo{mode: columns}
 	}
 
 	// get refactoring
@@ -358,12 +452,45 @@ func (x *XRun) Run(state *State, input map[string]interface{}) (Reply, error) {
 		Scope:      nil,
 		Selection:  ts,
 		Args:       input["arguments"].([]interface{}),
+		Cancel:     state.Cancel,
+	}
+	if verify, ok := input["verify"].(bool); ok {
+		config.Verify = verify
+	}
+	if builds, ok := input["builds"].([]interface{}); ok {
+		for _, build := range builds {
+			s, _ := build.(string)
+			bc, err := refactoring.ParseBuildConfig(s)
+			if err != nil {
+				return Reply{map[string]interface{}{"reply": "Error",
+					"message": err.Error()}}, err
+			}
+			config.BuildConfigs = append(config.BuildConfigs, bc)
+		}
+	}
+	project, err := engine.FindProjectConfig(ts.GetFilename())
+	if err != nil {
+		return Reply{map[string]interface{}{"reply": "Error",
+			"message": err.Error()}}, err
+	}
+	project.Apply(config, input["transformation"].(string))
+	if state.Notify != nil {
+		// send progress notifications while the refactoring runs
+		config.Progress = func(task string, done, total int) {
+			state.Notify(Reply{map[string]interface{}{"reply": "Progress", "task": task, "done": done, "total": total}})
+		}
 	}
 
 	// run
-	result := refac.Run(config)
+	result := refactoring.RunInConfigurations(refac, config)
 
 	// grab logs
+	if lineCols != nil {
+		// Entries may refer to the refactored files (see
+		// Log.EntryFileSystem), so count columns in those files
+		lineCols.contents = filesystem.NewContentCache(
+			result.Log.EntryFileSystem(state.Filesystem))
+	}
 	logs := make([]map[string]interface{}, 0)
 	for _, entry := range result.Log.Entries {
 		var severity string
@@ -376,10 +503,32 @@ func (x *XRun) Run(state *State, input map[string]interface{}) (Reply, error) {
 			severity = "error"
 		}
 		log := map[string]interface{}{"severity": severity, "message": entry.Message}
+		if entry.Code != "" {
+			log["code"] = entry.Code
+		}
+		addPosition(log, entry.Filename, entry.Offset, entry.Length, lineCols)
+		if len(entry.Related) > 0 {
+			related := make([]map[string]interface{}, 0, len(entry.Related))
+			for _, r := range entry.Related {
+				loc := map[string]interface{}{"message": r.Message}
+				addPosition(loc, r.Filename, r.Offset, r.Length, lineCols)
+				related = append(related, loc)
+			}
+			log["related"] = related
+		}
+		if len(entry.Fixes) > 0 {
+			fixes := make([]map[string]interface{}, 0, len(entry.Fixes))
+			for _, fix := range entry.Fixes {
+				fixes = append(fixes, map[string]interface{}{
+					"description": fix.Description,
+					"edits":       editInfo(fix.Edits)})
+			}
+			log["fixes"] = fixes
+		}
 		logs = append(logs, log)
 	}
 
-	changes := make([]map[string]string, 0)
+	changes := make([]map[string]interface{}, 0)
 
 	// if mode == patch or no mode was given
 	if mode, found := input["mode"]; !found || mode.(string) == "patch" {
@@ -394,8 +543,16 @@ func (x *XRun) Run(state *State, input map[string]interface{}) (Reply, error) {
 			p.Write(f, f, time.Time{}, time.Time{}, diffFile)
 			//fmt.Println(f)
 			//fmt.Println(diffFile.Name())
-			changes = append(changes, map[string]string{"filename": f, "patchFile": diffFile.Name()})
+			change := map[string]interface{}{"filename": f, "patchFile": diffFile.Name()}
 			diffFile.Close()
+			if highlight, ok := input["highlight"].(bool); ok && highlight {
+				hunks, err := p.Hunks()
+				if err != nil {
+					return Reply{map[string]interface{}{"reply": "Error", "message": err.Error()}}, err
+				}
+				change["hunks"] = hunkInfo(hunks)
+			}
+			changes = append(changes, change)
 		}
 	} else {
 		for f, e := range result.Edits {
@@ -403,7 +560,7 @@ func (x *XRun) Run(state *State, input map[string]interface{}) (Reply, error) {
 			if err != nil {
 				return Reply{map[string]interface{}{"reply": "Error", "message": err.Error()}}, err
 			}
-			changes = append(changes, map[string]string{"filename": f, "content": string(content)})
+			changes = append(changes, map[string]interface{}{"filename": f, "content": string(content)})
 		}
 	}
 
@@ -411,6 +568,94 @@ func (x *XRun) Run(state *State, input map[string]interface{}) (Reply, error) {
 	return Reply{map[string]interface{}{"reply": "OK", "description": refac.Description().Name, "log": logs, "files": changes}}, nil
 }
 
+// addPosition adds "filename", "offset", and "length" keys to the given map,
+// if filename is not "".
+func addPosition(m map[string]interface{}, filename string, offset, length int, lineCols *lineColInfo) {
+	if filename == "" {
+		return
+	}
+	m["filename"] = filename
+	m["offset"] = offset
+	m["length"] = length
+	if lineCols != nil {
+		lineCols.add(m, filename, offset, length)
+	}
+}
+
+// A lineColInfo adds line and column numbers, counted in a particular
+// ColumnMode, to the positions in a reply.  File contents are read from a
+// ContentCache as needed.
+type lineColInfo struct {
+	mode     text.ColumnMode
+	contents *filesystem.ContentCache
+}
+
+// add sets the "startline", "startcol", "endline", and "endcol" keys of the
+// given map, if the file can be read.  The end position is exclusive.
+func (l *lineColInfo) add(m map[string]interface{}, filename string, offset, length int) {
+	startLine, startCol, ok := l.contents.Position(filename, offset, l.mode)
+	if !ok {
+		return
+	}
+	endLine, endCol, ok := l.contents.Position(filename, offset+length, l.mode)
+	if !ok {
+		return
+	}
+	m["startline"], m["startcol"] = startLine, startCol
+	m["endline"], m["endcol"] = endLine, endCol
+}
+
+// editInfo describes a set of edits as a list of replacements, each with a
+// "filename", "offset", "length", and "replacement".
+func editInfo(edits map[string]*text.EditSet) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0)
+	for filename, es := range edits {
+		es.Iterate(func(extent *text.Extent, replacement string) bool {
+			result = append(result, map[string]interface{}{
+				"filename":    filename,
+				"offset":      extent.Offset,
+				"length":      extent.Length,
+				"replacement": replacement})
+			return true
+		})
+	}
+	return result
+}
+
+// hunkInfo describes the hunks in a patch.  Each hunk has "origStart",
+// "origLines", "newStart", "newLines", and "lines"; each line has a "kind"
+// (" ", "-", or "+") and "text", and a modified line has "changed": a list of
+// the regions of the line (each with an "offset" and "length" in bytes) that
+// differ from the line it replaces or is replaced by.
+func hunkInfo(hunks []*text.PatchHunk) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(hunks))
+	for _, h := range hunks {
+		lines := make([]map[string]interface{}, 0, len(h.Lines))
+		for _, line := range h.Lines {
+			l := map[string]interface{}{
+				"kind": string(line.Kind),
+				"text": line.Text}
+			if line.Changed != nil {
+				changed := make([]map[string]interface{}, 0, len(line.Changed))
+				for _, c := range line.Changed {
+					changed = append(changed, map[string]interface{}{
+						"offset": c.Offset,
+						"length": c.Length})
+				}
+				l["changed"] = changed
+			}
+			lines = append(lines, l)
+		}
+		result = append(result, map[string]interface{}{
+			"origStart": h.OrigStart,
+			"origLines": h.OrigLines,
+			"newStart":  h.NewStart,
+			"newLines":  h.NewLines,
+			"lines":     lines})
+	}
+	return result
+}
+
 // TODO validate TextSelection, FileSelection, arguments
 func (x *XRun) Validate(state *State, input map[string]interface{}) (bool, error) {
 	if state.State < 2 {
@@ -430,7 +675,11 @@ func (x *XRun) Validate(state *State, input map[string]interface{}) (bool, error
 	if tsfound && fsfound {
 		return false, errors.New("Both textseleciton and fileselection cannot be used together")
 	} else if tsfound {
-		_, err := parseSelection(state, textselection.(map[string]interface{}))
+		selection, ok := textselection.(map[string]interface{})
+		if !ok {
+			return false, errors.New("textselection must be an object")
+		}
+		_, err := parseSelection(state, selection)
 		if err != nil {
 			return false, err
 		}
@@ -445,6 +694,17 @@ func (x *XRun) Validate(state *State, input map[string]interface{}) (bool, error
 		}
 	}
 
+	// check columns key if exists
+	if columns, found := input["columns"]; found {
+		name, ok := columns.(string)
+		if !ok {
+			return false, errors.New("\"columns\" key must be a string")
+		}
+		if _, err := text.ParseColumnMode(name); err != nil {
+			return false, err
+		}
+	}
+
 	// check mode key if exists
 	if mode, found := input["mode"]; found {
 		field, _ := reflect.TypeOf(x).Elem().FieldByName("Mode")
@@ -461,10 +721,35 @@ func (x *XRun) Validate(state *State, input map[string]interface{}) (bool, error
 
 // -=-= Helpers =-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-
 
+// resolveStdin replaces the filename of a text selection on the fake standard
+// input file (used in web mode) with the absolute path of that file
+func resolveStdin(ts text.Selection) (text.Selection, error) {
+	if ts.GetFilename() == filesystem.FakeStdinFilename {
+		stdinPath, err := filesystem.FakeStdinPath()
+		if err != nil {
+			return ts, err
+		}
+		switch ts := ts.(type) {
+		case *text.OffsetLengthSelection:
+			ts.Filename = stdinPath
+		case *text.LineColSelection:
+			ts.Filename = stdinPath
+		case *text.SymbolSelection:
+			ts.Filename = stdinPath
+		}
+	}
+	return ts, nil
+}
+
 // takes a map for a text selection, either in line/col form or offset/length
-// and returns the appropriate type (LineColSelection or OffsetLengthSelection)
+// or as a qualified name ("symbol") and returns the appropriate type
+// (LineColSelection, OffsetLengthSelection, or SymbolSelection)
 // also can be used to simply validate the text selection given
 func parseSelection(state *State, input map[string]interface{}) (text.Selection, error) {
+	if symbol, found := input["symbol"]; found {
+		return parseSymbolSelection(state, input, symbol)
+	}
+
 	// validate filename
 	filename, filefound := input["filename"]
 	if !filefound {
@@ -517,3 +802,31 @@ func parseSelection(state *State, input map[string]interface{}) (text.Selection,
 	}
 
 }
+
+// parseSymbolSelection returns a SymbolSelection for a text selection given as
+// a qualified name.  The "filename" key is optional; if it is not given, the
+// file is located by finding the package relative to the state's directory.
+func parseSymbolSelection(state *State, input map[string]interface{}, symbol interface{}) (text.Selection, error) {
+	name, ok := symbol.(string)
+	if !ok {
+		return nil, fmt.Errorf("Invalid type of value given for symbol: given %T", symbol)
+	}
+	sym, err := text.NewSymbolSelection("", name)
+	if err != nil {
+		return nil, err
+	}
+	if filename, found := input["filename"]; found {
+		f, ok := filename.(string)
+		if !ok {
+			return nil, fmt.Errorf("Invalid type of value given for file: given %T", filename)
+		}
+		sym.Filename = filepath.Join(state.Dir, f)
+		return sym, nil
+	}
+	pkg, err := build.Import(sym.Package, state.Dir, 0)
+	if err != nil || len(pkg.GoFiles)+len(pkg.CgoFiles) == 0 {
+		return nil, fmt.Errorf("The package %s was not found", sym.Package)
+	}
+	sym.Filename = filepath.Join(pkg.Dir, append(pkg.GoFiles, pkg.CgoFiles...)[0])
+	return sym, nil
+}
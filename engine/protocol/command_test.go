@@ -4,7 +4,14 @@
 
 package protocol
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/godoctor/godoctor/filesystem"
+)
 
 func TestAboutValidatePass(t *testing.T) {
 	// about requires state > 0 to pass validation
@@ -56,3 +63,72 @@ func TestAboutRunPass(t *testing.T) {
 		}
 	}
 }
+
+func TestListWithTextselection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "godoctor-list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := "package main\n\nfunc main() {\n\tn := 1\n\tn++\n}\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	state := State{State: 2, Mode: "local", Dir: dir,
+		Filesystem: filesystem.NewLocalFileSystem()}
+	list := List{}
+
+	// Selecting the package keyword: only file-level refactorings apply
+	reply, err := list.Run(&state, map[string]interface{}{
+		"quality": "production",
+		"textselection": map[string]interface{}{
+			"filename": "main.go", "offset": 0.0, "length": 7.0},
+	})
+	if err != nil {
+		t.Fatal("List.Run: ", err)
+	}
+	names := shortNames(reply)
+	if names["rename"] || names["toggle"] || !names["godoc"] {
+		t.Fatalf("List.Run: wrong refactorings for package keyword: %v", names)
+	}
+
+	// Selecting n in n := 1: rename and toggle apply
+	reply, err = list.Run(&state, map[string]interface{}{
+		"quality": "production",
+		"textselection": map[string]interface{}{
+			"filename": "main.go", "offset": 29.0, "length": 1.0},
+	})
+	if err != nil {
+		t.Fatal("List.Run: ", err)
+	}
+	names = shortNames(reply)
+	if !names["rename"] || !names["toggle"] {
+		t.Fatalf("List.Run: wrong refactorings for identifier: %v", names)
+	}
+}
+
+func TestListWithMalformedTextselection(t *testing.T) {
+	state := State{State: 2, Mode: "local", Dir: os.TempDir(),
+		Filesystem: filesystem.NewLocalFileSystem()}
+	list := List{}
+
+	reply, err := list.Run(&state, map[string]interface{}{
+		"quality":       "production",
+		"textselection": "main.go",
+	})
+	if err == nil {
+		t.Fatal("List.Run: should fail when textselection is not an object")
+	}
+	if reply.Params["reply"] != "Error" || reply.Params["message"] != err.Error() {
+		t.Fatalf("List.Run: expected an error reply; got %v", reply.Params)
+	}
+}
+
+func shortNames(reply Reply) map[string]bool {
+	result := map[string]bool{}
+	for _, t := range reply.Params["transformations"].([]map[string]string) {
+		result[t["shortName"]] = true
+	}
+	return result
+}
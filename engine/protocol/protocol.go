@@ -14,6 +14,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"sync"
 
 	"github.com/godoctor/godoctor/filesystem"
 )
@@ -32,9 +33,19 @@ type State struct {
 	Mode       string
 	Dir        string
 	Filesystem filesystem.FileSystem
+	// Closed when the client sends a "cancel" command while another
+	// command is running (single command console only)
+	Cancel chan struct{}
+	// If non-nil, used to send notifications (e.g., progress reports) to
+	// the client while a command is running
+	Notify func(Reply)
 }
 
 func Run(writer io.Writer, aboutText string, args []string) {
+	// Progress notifications are sent from the goroutine running a
+	// command while other replies are sent from the input loop, so writes
+	// must be serialized to keep replies on separate lines
+	writer = &syncWriter{w: writer}
 
 	// single command console
 	if len(args) == 0 {
@@ -69,19 +80,30 @@ func Run(writer io.Writer, aboutText string, args []string) {
 
 func runSingle(writer io.Writer, aboutText string) {
 	cmdList := setup(aboutText)
-	var state = State{0, "", "", nil}
-	var inputJson map[string]interface{}
-	ioreader := bufio.NewReader(os.Stdin)
-	for {
-		input, err := ioreader.ReadBytes('\n')
-		if err == io.EOF {
-			// exit
-			break
-		} else if err != nil {
-			printReply(writer, Reply{map[string]interface{}{"reply": "Error", "message": err.Error()}})
-			continue
+	var state = State{State: 0}
+	state.Notify = func(reply Reply) { printReply(writer, reply) }
+
+	// Read input on a separate goroutine, so that a "cancel" command can
+	// be received while another command is running
+	inputs := make(chan []byte)
+	go func() {
+		ioreader := bufio.NewReader(os.Stdin)
+		for {
+			input, err := ioreader.ReadBytes('\n')
+			if err == io.EOF {
+				close(inputs)
+				return
+			} else if err != nil {
+				printReply(writer, Reply{map[string]interface{}{"reply": "Error", "message": err.Error()}})
+				continue
+			}
+			inputs <- input
 		}
-		err = json.Unmarshal(input, &inputJson)
+	}()
+
+	for input := range inputs {
+		var inputJson map[string]interface{}
+		err := json.Unmarshal(input, &inputJson)
 		if err != nil {
 			printReply(writer, Reply{map[string]interface{}{"reply": "Error", "message": err.Error()}})
 			continue
@@ -96,20 +118,79 @@ func runSingle(writer io.Writer, aboutText string) {
 		if cmd == "close" {
 			break
 		}
+		// cancel is only meaningful while a command is running
+		if cmd == "cancel" {
+			printReply(writer, Reply{map[string]interface{}{"reply": "Error", "message": "No command is running"}})
+			continue
+		}
 		// check command is one we support
 		if _, found := cmdList[cmd.(string)]; !found {
 			printReply(writer, Reply{map[string]interface{}{"reply": "Error", "message": "Invalid JSON command"}})
 			continue
 		}
 		// everything good to run command
-		result, _ := cmdList[cmd.(string)].Run(&state, inputJson) // run the command
-		printReply(writer, result)
+		if !runCancelable(writer, &state, cmdList[cmd.(string)], inputJson, inputs) {
+			break
+		}
+	}
+}
+
+// runCancelable runs a command on a separate goroutine and prints its reply.
+// While the command is running, input is read from the given channel; a
+// "cancel" command cancels the running command, and any other command is
+// rejected.  Returns false iff the input channel was closed or a "close"
+// command was received while the command was running.
+func runCancelable(writer io.Writer, state *State, cmd Command, inputJson map[string]interface{}, inputs <-chan []byte) bool {
+	state.Cancel = make(chan struct{})
+	defer func() { state.Cancel = nil }()
+
+	results := make(chan Reply)
+	go func() {
+		result, _ := cmd.Run(state, inputJson) // run the command
+		results <- result
+	}()
+
+	canceled := false
+	keepRunning := true
+	for {
+		select {
+		case result := <-results:
+			printReply(writer, result)
+			return keepRunning
+		case input, ok := <-inputs:
+			if !ok {
+				inputs = nil
+				keepRunning = false
+				if !canceled {
+					close(state.Cancel)
+					canceled = true
+				}
+				continue
+			}
+			var json2 map[string]interface{}
+			if err := json.Unmarshal(input, &json2); err != nil {
+				printReply(writer, Reply{map[string]interface{}{"reply": "Error", "message": err.Error()}})
+				continue
+			}
+			switch json2["command"] {
+			case "cancel", "close":
+				if !canceled {
+					close(state.Cancel)
+					canceled = true
+				}
+				if json2["command"] == "close" {
+					keepRunning = false
+				}
+			default:
+				printReply(writer, Reply{map[string]interface{}{"reply": "Error", "message": "Another command is already running"}})
+			}
+		}
 	}
 }
 
 func runList(writer io.Writer, aboutText string, argJson []map[string]interface{}) {
 	cmdList := setup(aboutText)
-	var state = State{1, "", "", nil}
+	var state = State{State: 1}
 	for i, cmdObj := range argJson {
 		// has command?
 		cmd, found := cmdObj["command"]
@@ -148,6 +229,21 @@ func setup(aboutText string) map[string]Command {
 	return cmds
 }
 
+// printReply writes the given reply, followed by a newline, in a single call
+// to the writer's Write method.
 func printReply(writer io.Writer, reply Reply) {
 	fmt.Fprintf(writer, "%s\n", reply)
 }
+
+// A syncWriter is an io.Writer that can be written to from several goroutines
+// concurrently; each call to Write completes before the next one begins.
+type syncWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.w.Write(p)
+}
@@ -248,7 +248,7 @@ func (fs *EditedFileSystem) OverwriteFile(path string) (io.WriteCloser, error) {
 }
 
 func (fs *EditedFileSystem) ReadDir(dirPath string) ([]os.FileInfo, error) {
-	origInfos, err := ioutil.ReadDir(dirPath)
+	origInfos, err := fs.BaseFS.ReadDir(dirPath)
 	if err != nil {
 		return nil, err
 	}
@@ -344,3 +344,97 @@ func ApplyEdits(es *text.EditSet, fs FileSystem, filename string) ([]byte, error
 
 	return text.ApplyToReader(es, file)
 }
+
+// A ContentCache reads the contents of files from a FileSystem, reading each
+// file at most once.  It is used to convert byte offsets into line and column
+// numbers.
+type ContentCache struct {
+	fs       FileSystem
+	contents map[string][]byte // nil if the file could not be read
+}
+
+// NewContentCache returns a ContentCache that reads files from the given
+// FileSystem, which may be nil (in which case no files can be read).
+func NewContentCache(fs FileSystem) *ContentCache {
+	return &ContentCache{fs: fs, contents: map[string][]byte{}}
+}
+
+// Contents returns the contents of the given file, or nil if it cannot be
+// read.
+func (c *ContentCache) Contents(filename string) []byte {
+	if contents, ok := c.contents[filename]; ok {
+		return contents
+	}
+	var contents []byte
+	if c.fs != nil {
+		if reader, err := c.fs.OpenFile(filename); err == nil {
+			contents, err = ioutil.ReadAll(reader)
+			reader.Close()
+			if err != nil {
+				contents = nil
+			}
+		}
+	}
+	c.contents[filename] = contents
+	return contents
+}
+
+// Position returns the 1-based line and column number, with columns counted
+// in the given mode, of the given byte offset in the given file.  It returns
+// false if the file cannot be read or the offset is not in the file.
+func (c *ContentCache) Position(filename string, offset int, mode text.ColumnMode) (line, col int, ok bool) {
+	contents := c.Contents(filename)
+	if contents == nil || offset < 0 || offset > len(contents) {
+		return 0, 0, false
+	}
+	line, col = mode.Position(contents, offset)
+	return line, col, true
+}
+
+// ReadPatch reads a multi-file unified diff and returns EditSets that make the
+// changes it describes to files in the given FileSystem.  The EditSets are
+// keyed by filename; relative filenames in the patch are joined to the
+// directory dir, so the keys are absolute paths if dir is.  If fuzzy is
+// true, hunks may be applied at lines other than those given in their
+// headers, as described for text.FileDiff.EditSet.  Patches that create or
+// delete files are not supported, since an EditSet can only modify an
+// existing file.
+func ReadPatch(in io.Reader, fs FileSystem, dir string, fuzzy bool) (map[string]*text.EditSet, error) {
+	diffs, err := text.ParsePatch(in)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]*text.EditSet{}
+	for _, diff := range diffs {
+		filename := diff.Filename()
+		if diff.OrigFile == os.DevNull || diff.NewFile == os.DevNull {
+			return nil, fmt.Errorf("%s: creating and deleting files "+
+				"is not supported", filename)
+		}
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(dir, filepath.FromSlash(filename))
+		}
+		if _, ok := result[filename]; ok {
+			return nil, fmt.Errorf("%s: file appears more than once "+
+				"in the patch", filename)
+		}
+
+		file, err := fs.OpenFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		contents, err := ioutil.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		es, err := diff.EditSet(string(contents), fuzzy)
+		if err != nil {
+			return nil, err
+		}
+		result[filename] = es
+	}
+	return result, nil
+}
@@ -130,6 +130,30 @@ func TestEditedFileSystem(t *testing.T) {
 	}
 }
 
+func TestContentCache(t *testing.T) {
+	fs := NewMemoryFileSystem()
+	if err := fs.WriteFile("/a.txt", "ab\n\U0001F600c"); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewContentCache(fs)
+	if line, col, ok := cache.Position("/a.txt", 7, text.UTF16Columns); !ok || line != 2 || col != 3 {
+		t.Fatalf("Expected 2:3; got %d:%d (%v)", line, col, ok)
+	}
+	// The cached contents are used even if the file changes
+	if err := fs.WriteFile("/a.txt", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := cache.Position("/a.txt", 7, text.ByteColumns); !ok {
+		t.Fatal("Expected cached contents to be used")
+	}
+	if _, _, ok := cache.Position("/a.txt", 9, text.ByteColumns); ok {
+		t.Fatal("Offset past the end of the file should not be found")
+	}
+	if cache.Contents("/missing.txt") != nil {
+		t.Fatal("Expected nil contents for a missing file")
+	}
+}
+
 func TestPatchOnFile(t *testing.T) {
 	// Insert "Before line 1" at the top of testdata/diff/lines.txt
 	testfile := "testdata/lines.txt"
@@ -193,6 +217,46 @@ func TestPatchOnMissingFile(t *testing.T) {
 	}
 }
 
+func TestReadPatch(t *testing.T) {
+	fs := &LocalFileSystem{}
+	patch := `diff -u testdata/lines.txt testdata/lines.txt
+--- testdata/lines.txt
++++ testdata/lines.txt
+@@ -2,2 +2,2 @@
+ Line 2
+-Line 3
++Line three
+`
+	edits, err := ReadPatch(strings.NewReader(patch), fs, ".", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edits) != 1 || edits["testdata/lines.txt"] == nil {
+		t.Fatalf("Expected edits to testdata/lines.txt, got %v", edits)
+	}
+	result, err := ApplyEdits(edits["testdata/lines.txt"], fs, "testdata/lines.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != "Line 1\nLine 2\nLine three\n" {
+		t.Fatalf("ReadPatch failed:\n%s", result)
+	}
+
+	// The hunk header is off by one line, so only fuzzy matching works
+	patch = strings.Replace(patch, "@@ -2,2 +2,2 @@", "@@ -1,2 +1,2 @@", 1)
+	if _, err := ReadPatch(strings.NewReader(patch), fs, ".", false); err == nil {
+		t.Fatal("Strict matching should have failed")
+	}
+	if _, err := ReadPatch(strings.NewReader(patch), fs, ".", true); err != nil {
+		t.Fatal(err)
+	}
+
+	patch = strings.Replace(patch, "+++ testdata/lines.txt", "+++ /dev/null", 1)
+	if _, err := ReadPatch(strings.NewReader(patch), fs, ".", true); err == nil {
+		t.Fatal("Deleting files should not be supported")
+	}
+}
+
 func TestLoader(t *testing.T) {
 	local := NewLocalFileSystem()
 	var lconfig loader.Config
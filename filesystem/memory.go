@@ -0,0 +1,356 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file defines MemoryFileSystem, a FileSystem whose files and
+// directories are stored entirely in memory, and a function to populate one
+// from a txtar archive.
+
+package filesystem
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* -=-=- Memory File System -=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=- */
+
+// A memEntry is a file or directory in a MemoryFileSystem.
+type memEntry struct {
+	isDir    bool
+	contents []byte // nil for directories
+	modTime  time.Time
+}
+
+// MemoryFileSystem implements the FileSystem interface using files and
+// directories stored in memory; it never reads or writes the local file
+// system.  This allows refactorings to be run on code that does not exist on
+// disk (e.g., in tests, or when the Go Doctor is embedded in another tool).
+//
+// Paths are cleaned and made absolute (relative to the current directory), so
+// a MemoryFileSystem can be used with FakeStdinPath.  The root directory
+// always exists; all other directories must be created, either explicitly by
+// CreateDirectory or implicitly by WriteFile.  A MemoryFileSystem is safe for
+// concurrent use.
+//
+// Note that the loader reads GOROOT through the same FileSystem, so a program
+// that imports standard library packages can only be loaded if their sources
+// have been added as well.
+type MemoryFileSystem struct {
+	mutex   sync.Mutex
+	entries map[string]*memEntry
+}
+
+// NewMemoryFileSystem returns a MemoryFileSystem containing only an empty
+// root directory.
+func NewMemoryFileSystem() *MemoryFileSystem {
+	root := filepath.VolumeName(absPath(".")) + string(filepath.Separator)
+	return &MemoryFileSystem{
+		entries: map[string]*memEntry{
+			root: &memEntry{isDir: true, modTime: time.Now()},
+		},
+	}
+}
+
+// absPath returns a clean, absolute path equivalent to the given path.
+func absPath(path string) string {
+	if result, err := filepath.Abs(path); err == nil {
+		return result
+	}
+	return filepath.Clean(path)
+}
+
+// notExist returns an error satisfying os.IsNotExist.
+func notExist(op, path string) error {
+	return &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+}
+
+// WriteFile sets the contents of the given file, creating it (and its parent
+// directories) if it does not exist.  It is intended for populating a
+// MemoryFileSystem before it is used.
+func (fs *MemoryFileSystem) WriteFile(path, contents string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	path = absPath(path)
+	if entry, ok := fs.entries[path]; ok && entry.isDir {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	if err := fs.mkdirAll(filepath.Dir(path)); err != nil {
+		return err
+	}
+	fs.entries[path] = &memEntry{
+		contents: []byte(contents),
+		modTime:  time.Now(),
+	}
+	return nil
+}
+
+// mkdirAll creates the given directory and any missing parent directories.
+// The caller must hold the mutex.
+func (fs *MemoryFileSystem) mkdirAll(dir string) error {
+	if entry, ok := fs.entries[dir]; ok {
+		if !entry.isDir {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := fs.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	fs.entries[dir] = &memEntry{isDir: true, modTime: time.Now()}
+	return nil
+}
+
+// checkParent returns an error unless the parent directory of the given
+// (absolute) path exists.  The caller must hold the mutex.
+func (fs *MemoryFileSystem) checkParent(op, path string) error {
+	parent := filepath.Dir(path)
+	if entry, ok := fs.entries[parent]; !ok || !entry.isDir {
+		return notExist(op, parent)
+	}
+	return nil
+}
+
+func (fs *MemoryFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	path = absPath(path)
+	if entry, ok := fs.entries[path]; !ok || !entry.isDir {
+		return nil, notExist("readdir", path)
+	}
+	result := []os.FileInfo{}
+	for p, entry := range fs.entries {
+		if filepath.Dir(p) == path && p != path {
+			result = append(result, entry.info(filepath.Base(p)))
+		}
+	}
+	sort.Sort(byName(result))
+	return result, nil
+}
+
+// info returns an os.FileInfo describing this entry, which has the given name.
+func (entry *memEntry) info(name string) os.FileInfo {
+	if entry.isDir {
+		return &fileInfo{
+			name:    name,
+			mode:    os.ModeDir | 0755,
+			modTime: entry.modTime,
+			isDir:   true,
+		}
+	}
+	return &fileInfo{
+		name:    name,
+		size:    int64(len(entry.contents)),
+		mode:    0644,
+		modTime: entry.modTime,
+	}
+}
+
+type byName []os.FileInfo
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Less(i, j int) bool { return s[i].Name() < s[j].Name() }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (fs *MemoryFileSystem) OpenFile(path string) (io.ReadCloser, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	path = absPath(path)
+	entry, ok := fs.entries[path]
+	if !ok {
+		return nil, notExist("open", path)
+	} else if entry.isDir {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+	// Contents are replaced (not modified) when a file is written, so the
+	// reader is unaffected by subsequent writes
+	return ioutil.NopCloser(bytes.NewReader(entry.contents)), nil
+}
+
+// OverwriteFile opens a file for writing.  The file's contents are replaced
+// when the returned WriteCloser is closed.
+func (fs *MemoryFileSystem) OverwriteFile(path string) (io.WriteCloser, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	path = absPath(path)
+	entry, ok := fs.entries[path]
+	if !ok {
+		return nil, notExist("open", path)
+	} else if entry.isDir {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+	return &memWriter{fs: fs, path: path}, nil
+}
+
+// A memWriter buffers the contents written to a file in a MemoryFileSystem.
+type memWriter struct {
+	bytes.Buffer
+	fs   *MemoryFileSystem
+	path string
+}
+
+func (w *memWriter) Close() error {
+	w.fs.mutex.Lock()
+	defer w.fs.mutex.Unlock()
+	entry, ok := w.fs.entries[w.path]
+	if !ok || entry.isDir {
+		return notExist("write", w.path)
+	}
+	w.fs.entries[w.path] = &memEntry{
+		contents: w.Bytes(),
+		modTime:  time.Now(),
+	}
+	return nil
+}
+
+func (fs *MemoryFileSystem) CreateFile(path, contents string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	path = absPath(path)
+	if _, ok := fs.entries[path]; ok {
+		return fmt.Errorf("Path already exists: %s", path)
+	}
+	if err := fs.checkParent("create", path); err != nil {
+		return err
+	}
+	fs.entries[path] = &memEntry{
+		contents: []byte(contents),
+		modTime:  time.Now(),
+	}
+	return nil
+}
+
+// CreateDirectory creates an empty directory.  Its parent directory must
+// already exist.
+func (fs *MemoryFileSystem) CreateDirectory(path string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	path = absPath(path)
+	if _, ok := fs.entries[path]; ok {
+		return fmt.Errorf("Path already exists: %s", path)
+	}
+	if err := fs.checkParent("mkdir", path); err != nil {
+		return err
+	}
+	fs.entries[path] = &memEntry{isDir: true, modTime: time.Now()}
+	return nil
+}
+
+func (fs *MemoryFileSystem) Rename(oldPath, newName string) error {
+	if !isBareFilename(newName) {
+		return fmt.Errorf("newName must be a bare filename: %s",
+			newName)
+	}
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	oldPath = absPath(oldPath)
+	newPath := filepath.Join(filepath.Dir(oldPath), newName)
+	entry, ok := fs.entries[oldPath]
+	if !ok {
+		return notExist("rename", oldPath)
+	}
+	if newPath == oldPath {
+		return nil
+	}
+	if _, ok := fs.entries[newPath]; ok {
+		return fmt.Errorf("Path already exists: %s", newPath)
+	}
+	delete(fs.entries, oldPath)
+	fs.entries[newPath] = entry
+	if entry.isDir {
+		// Move the directory's contents as well
+		prefix := oldPath + string(filepath.Separator)
+		moved := map[string]*memEntry{}
+		for p, e := range fs.entries {
+			if strings.HasPrefix(p, prefix) {
+				moved[p] = e
+			}
+		}
+		for p, e := range moved {
+			delete(fs.entries, p)
+			fs.entries[filepath.Join(newPath, p[len(prefix):])] = e
+		}
+	}
+	return nil
+}
+
+func (fs *MemoryFileSystem) Remove(path string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	path = absPath(path)
+	entry, ok := fs.entries[path]
+	if !ok {
+		return notExist("remove", path)
+	}
+	if entry.isDir {
+		if filepath.Dir(path) == path {
+			return fmt.Errorf("Cannot remove the root directory")
+		}
+		prefix := path + string(filepath.Separator)
+		for p := range fs.entries {
+			if strings.HasPrefix(p, prefix) {
+				return fmt.Errorf("Directory not empty: %s", path)
+			}
+		}
+	}
+	delete(fs.entries, path)
+	return nil
+}
+
+/* -=-=- Txtar Archives -=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=- */
+
+// LoadTxtar adds the files in a txtar archive to this file system, in the
+// given directory.  A txtar archive is a text file containing an optional
+// comment followed by a sequence of files; each file begins with a marker line
+// of the form
+//
+//	-- path/to/file.go --
+//
+// and its contents are the lines up to the next marker (or the end of the
+// archive).  File paths are slash-separated and relative to dir.  Any
+// existing files with the same names are replaced.
+func (fs *MemoryFileSystem) LoadTxtar(dir string, archive string) error {
+	name := ""
+	var contents bytes.Buffer
+	flush := func() error {
+		if name == "" {
+			return nil // Comment
+		}
+		return fs.WriteFile(filepath.Join(dir, filepath.FromSlash(name)),
+			contents.String())
+	}
+	for _, line := range strings.SplitAfter(archive, "\n") {
+		if fileName, ok := txtarMarker(line); ok {
+			if err := flush(); err != nil {
+				return err
+			}
+			name = fileName
+			contents.Reset()
+		} else {
+			contents.WriteString(line)
+		}
+	}
+	return flush()
+}
+
+// txtarMarker returns the filename in the given line, if the line is a txtar
+// file marker (e.g., "-- main.go --").
+func txtarMarker(line string) (string, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) < 6 || !strings.HasPrefix(line, "-- ") ||
+		!strings.HasSuffix(line, " --") {
+		return "", false
+	}
+	name := strings.TrimSpace(line[3 : len(line)-3])
+	return name, name != ""
+}
@@ -0,0 +1,206 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"go/build"
+	"go/parser"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/godoctor/godoctor/internal/golang.org/x/tools/go/loader"
+	"github.com/godoctor/godoctor/text"
+)
+
+const archive = `This comment is ignored.
+-- src/main.go --
+package main
+
+import "foo"
+import "bar"
+
+func main() {
+	foo.Foo()
+	bar.Bar()
+}
+-- src/bar/bar.go --
+package bar
+func Bar() {}
+-- src/foo/foo.go --
+package foo
+func Foo() {}
+`
+
+func readMemFile(fs FileSystem, path string, t *testing.T) string {
+	file, err := fs.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	bytes, err := ioutil.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bytes)
+}
+
+func dirNames(fs FileSystem, path string, t *testing.T) string {
+	infos, err := fs.ReadDir(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, fi := range infos {
+		if fi.IsDir() {
+			names = append(names, fi.Name()+"/")
+		} else {
+			names = append(names, fi.Name())
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+func TestMemoryFileSystem(t *testing.T) {
+	fs := NewMemoryFileSystem()
+	if err := fs.CreateFile("/a/b.txt", "x"); !os.IsNotExist(err) {
+		t.Fatal("CreateFile should fail if the directory does not exist")
+	}
+	if err := fs.CreateDirectory("/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.CreateFile("/a/b.txt", "Hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.CreateFile("/a/b.txt", "x"); err == nil {
+		t.Fatal("Create over existing file should have failed")
+	}
+	if err := fs.WriteFile("/a/c/d.txt", "Nested"); err != nil {
+		t.Fatal(err)
+	}
+	assertEquals("b.txt c/", dirNames(fs, "/a", t), t)
+	assertEquals("Hello", readMemFile(fs, "/a/b.txt", t), t)
+	assertEquals("Nested", readMemFile(fs, "/a/c/../c/d.txt", t), t)
+	if _, err := fs.OpenFile("/a/missing.txt"); !os.IsNotExist(err) {
+		t.Fatal("OpenFile should fail for a nonexistent file")
+	}
+	if _, err := fs.OpenFile("/a/c"); err == nil {
+		t.Fatal("OpenFile should fail for a directory")
+	}
+
+	w, err := fs.OverwriteFile("/a/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "Goodbye")
+	assertEquals("Hello", readMemFile(fs, "/a/b.txt", t), t)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assertEquals("Goodbye", readMemFile(fs, "/a/b.txt", t), t)
+	if _, err := fs.OverwriteFile("/a/missing.txt"); err == nil {
+		t.Fatal("OverwriteFile should fail for a nonexistent file")
+	}
+
+	if err := fs.Rename("/a/c", "e/f"); err == nil {
+		t.Fatal("Rename should require a bare filename")
+	}
+	if err := fs.Rename("/a/c", "b.txt"); err == nil {
+		t.Fatal("Rename over an existing file should have failed")
+	}
+	if err := fs.Rename("/a/c", "e"); err != nil {
+		t.Fatal(err)
+	}
+	assertEquals("b.txt e/", dirNames(fs, "/a", t), t)
+	assertEquals("Nested", readMemFile(fs, "/a/e/d.txt", t), t)
+
+	if err := fs.Remove("/a/e"); err == nil {
+		t.Fatal("Remove of a nonempty directory should have failed")
+	}
+	if err := fs.Remove("/a/e/d.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/a/e"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/a/e"); !os.IsNotExist(err) {
+		t.Fatal("Remove of a nonexistent directory should have failed")
+	}
+	assertEquals("b.txt", dirNames(fs, "/a", t), t)
+}
+
+func TestLoadTxtar(t *testing.T) {
+	fs := NewMemoryFileSystem()
+	if err := fs.LoadTxtar("/gopath", archive); err != nil {
+		t.Fatal(err)
+	}
+	assertEquals("bar/ foo/ main.go", dirNames(fs, "/gopath/src", t), t)
+	assertEquals("package bar\nfunc Bar() {}\n",
+		readMemFile(fs, "/gopath/src/bar/bar.go", t), t)
+
+	if err := fs.LoadTxtar("/x", "-- a --\n-- b --\nB"); err != nil {
+		t.Fatal(err)
+	}
+	assertEquals("", readMemFile(fs, "/x/a", t), t)
+	assertEquals("B", readMemFile(fs, "/x/b", t), t)
+}
+
+func TestMemoryLoader(t *testing.T) {
+	fs := NewMemoryFileSystem()
+	if err := fs.LoadTxtar("/gopath", archive); err != nil {
+		t.Fatal(err)
+	}
+	var lconfig loader.Config
+	build := build.Default
+	build.GOPATH = "/gopath"
+	build.OpenFile = fs.OpenFile
+	build.ReadDir = fs.ReadDir
+	build.IsDir = func(path string) bool {
+		_, err := fs.ReadDir(path)
+		return err == nil
+	}
+	build.HasSubdir = nil // FIXME
+	lconfig.Build = &build
+	lconfig.ParserMode = parser.ParseComments | parser.DeclarationErrors
+	lconfig.AllowErrors = false
+	lconfig.SourceImports = true
+	lconfig.TypeChecker.Error = func(err error) {
+		t.Fatal(err)
+	}
+	lconfig.CreateFromFilenames("main", filepath.FromSlash("/gopath/src/main.go"))
+	if _, err := lconfig.Load(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEditedMemoryFileSystem(t *testing.T) {
+	base := NewMemoryFileSystem()
+	if err := base.WriteFile("/dir/file.txt", "123456789"); err != nil {
+		t.Fatal(err)
+	}
+	es := text.NewEditSet()
+	es.Add(&text.Extent{3, 5}, "xyz")
+	fs := NewEditedFileSystem(base,
+		map[string]*text.EditSet{"/dir/file.txt": es})
+	assertEquals("123xyz9", readMemFile(fs, "/dir/file.txt", t), t)
+	infos, err := fs.ReadDir("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Size() != 7 {
+		t.Fatal("Incorrect directory listing")
+	}
+}
+
+// assertEquals is a utility method for unit tests that marks a function as
+// having failed if expected != actual
+func assertEquals(expected string, actual string, t *testing.T) {
+	if expected != actual {
+		t.Fatalf("Expected: %q Actual: %q", expected, actual)
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file defines Transaction, which commits changes to several files on the
+// local file system so that either every change is made or none are.
+
+package filesystem
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A Transaction describes changes to files on the local file system (files to
+// overwrite, create, and move) that are committed together.  Commit writes
+// every new file to a temporary file in the same directory before changing
+// anything, then renames each temporary file over its target.  If any step
+// fails, the changes that have already been made are rolled back (restoring
+// overwritten files from their original contents, which are kept in memory),
+// so the files are never left partially refactored.
+type Transaction struct {
+	// If Loaded is nonzero, Commit fails if any file to be overwritten or
+	// moved was modified after this time (e.g., by an editor while the
+	// refactoring was running).
+	Loaded time.Time
+
+	overwrites []*txWrite
+	creates    []*txWrite
+	moves      []*txMove
+}
+
+// A txWrite is a file to be overwritten or created.
+type txWrite struct {
+	path     string
+	orig     []byte      // Expected contents (nil if not checked)
+	contents []byte      // New contents
+	info     os.FileInfo // The original file (nil if created)
+	saved    []byte      // The original file's contents, for rollback
+	temp     string      // Temporary file containing the new contents
+	done     bool
+}
+
+// A txMove is a file to be renamed.
+type txMove struct {
+	oldPath, newPath string
+	info             os.FileInfo
+	done             bool
+}
+
+// NewTransaction returns an empty Transaction that checks for files modified
+// after the given time (which may be the zero Time to skip this check).
+func NewTransaction(loaded time.Time) *Transaction {
+	return &Transaction{Loaded: loaded}
+}
+
+// Overwrite replaces the contents of an existing file.  The file keeps its
+// permissions.  If path is a symbolic link, the file it refers to is
+// overwritten, and the link is left in place.  If orig is non-nil, Commit
+// fails unless the file's current contents are orig (i.e., the contents from
+// which the new contents were derived).
+func (t *Transaction) Overwrite(path string, orig, contents []byte) {
+	t.overwrites = append(t.overwrites,
+		&txWrite{path: path, orig: orig, contents: contents})
+}
+
+// Create creates a new file with the given contents and default permissions.
+// Commit fails if the file already exists.
+func (t *Transaction) Create(path string, contents []byte) {
+	t.creates = append(t.creates, &txWrite{path: path, contents: contents})
+}
+
+// Move renames a file.  Moves are performed after files are overwritten, so
+// the oldPath of a moved file may also be overwritten.  Commit fails if
+// newPath already exists.
+func (t *Transaction) Move(oldPath, newPath string) {
+	t.moves = append(t.moves, &txMove{oldPath: oldPath, newPath: newPath})
+}
+
+// Commit makes the changes described by this Transaction.  If it returns an
+// error, no files have been changed (unless rolling back also failed, in
+// which case the error describes the files that could not be restored).
+func (t *Transaction) Commit() error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	if err := t.writeTemps(); err != nil {
+		t.removeTemps()
+		return err
+	}
+	// Check again, in case a file changed while the temps were written
+	if err := t.checkUnchanged(); err != nil {
+		t.removeTemps()
+		return err
+	}
+	if err := t.apply(); err != nil {
+		if rbErr := t.rollback(); rbErr != nil {
+			return fmt.Errorf("%s; additionally, %s", err, rbErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// check ensures that the files to be overwritten and moved exist and have not
+// changed, and that the files to be created do not exist.
+func (t *Transaction) check() error {
+	for _, w := range t.overwrites {
+		// Renaming over a symbolic link would replace the link, so
+		// write to the file it refers to instead
+		path, err := filepath.EvalSymlinks(w.path)
+		if err != nil {
+			return err
+		}
+		w.path = path
+		info, err := os.Stat(w.path)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", w.path)
+		}
+		w.info = info
+		current, err := ioutil.ReadFile(w.path)
+		if err != nil {
+			return err
+		}
+		if w.orig != nil && !bytes.Equal(current, w.orig) {
+			return t.modifiedError(w.path)
+		}
+		w.saved = current
+	}
+	for _, m := range t.moves {
+		info, err := os.Stat(m.oldPath)
+		if err != nil {
+			return err
+		}
+		m.info = info
+	}
+	if err := t.checkUnchanged(); err != nil {
+		return err
+	}
+	for _, w := range t.creates {
+		if err := checkAbsent(w.path); err != nil {
+			return err
+		}
+	}
+	for _, m := range t.moves {
+		if err := checkAbsent(m.newPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkUnchanged ensures that the files to be overwritten and moved have not
+// been modified since Loaded, or since they were first examined by check.
+func (t *Transaction) checkUnchanged() error {
+	changed := func(path string, before os.FileInfo) error {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !t.Loaded.IsZero() && info.ModTime().After(t.Loaded) ||
+			!info.ModTime().Equal(before.ModTime()) ||
+			info.Size() != before.Size() {
+			return t.modifiedError(path)
+		}
+		return nil
+	}
+	for _, w := range t.overwrites {
+		if err := changed(w.path, w.info); err != nil {
+			return err
+		}
+	}
+	for _, m := range t.moves {
+		if err := changed(m.oldPath, m.info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Transaction) modifiedError(path string) error {
+	return fmt.Errorf("%s has been modified since it was loaded; "+
+		"no files were changed", path)
+}
+
+// checkAbsent returns an error if a file exists at the given path.
+func checkAbsent(path string) error {
+	if _, err := os.Lstat(path); err == nil {
+		return fmt.Errorf("Path already exists: %s", path)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// writeTemps writes the new contents of each file to a temporary file in the
+// same directory, so that it can be renamed into place.
+func (t *Transaction) writeTemps() error {
+	for _, w := range append(append([]*txWrite{}, t.overwrites...), t.creates...) {
+		perm := os.FileMode(0666) // Reduced by umask
+		if w.info != nil {
+			perm = w.info.Mode().Perm()
+		}
+		f, err := tempSibling(w.path, "tmp", perm)
+		if err != nil {
+			return err
+		}
+		w.temp = f.Name()
+		_, err = f.Write(w.contents)
+		if err == nil && w.info != nil {
+			// Set the original permissions exactly (ignoring umask)
+			err = f.Chmod(perm)
+		}
+		if err1 := f.Close(); err == nil {
+			err = err1
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tempSibling creates a new, empty file in the same directory as the given
+// path, with the given permissions (reduced by umask).
+func tempSibling(path, suffix string, perm os.FileMode) (*os.File, error) {
+	dir, base := filepath.Split(path)
+	for i := 0; ; i++ {
+		name := filepath.Join(dir, fmt.Sprintf(".%s.godoctor-%d-%d.%s",
+			base, os.Getpid(), i, suffix))
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if !os.IsExist(err) || i >= 100 {
+			return f, err
+		}
+	}
+}
+
+func (t *Transaction) removeTemps() {
+	for _, w := range append(append([]*txWrite{}, t.overwrites...), t.creates...) {
+		if w.temp != "" && !w.done {
+			os.Remove(w.temp)
+		}
+	}
+}
+
+// apply renames the temporary files into place and moves files.  Each change
+// is marked as done, so it can be rolled back.
+func (t *Transaction) apply() error {
+	for _, w := range t.overwrites {
+		// Renaming over the original replaces it atomically, so the
+		// file always has either its original or its new contents
+		if err := os.Rename(w.temp, w.path); err != nil {
+			return err
+		}
+		w.done = true
+	}
+	for _, w := range t.creates {
+		if err := checkAbsent(w.path); err != nil {
+			return err
+		}
+		if err := os.Rename(w.temp, w.path); err != nil {
+			return err
+		}
+		w.done = true
+	}
+	for _, m := range t.moves {
+		if err := checkAbsent(m.newPath); err != nil {
+			return err
+		}
+		if err := os.Rename(m.oldPath, m.newPath); err != nil {
+			return err
+		}
+		m.done = true
+	}
+	return nil
+}
+
+// rollback undoes the changes made by apply, in reverse order, and removes
+// any temporary files.
+func (t *Transaction) rollback() error {
+	failed := []string{}
+	for i := len(t.moves) - 1; i >= 0; i-- {
+		if m := t.moves[i]; m.done {
+			if err := os.Rename(m.newPath, m.oldPath); err != nil {
+				failed = append(failed, m.oldPath)
+			}
+		}
+	}
+	for _, w := range t.creates {
+		if w.done {
+			if err := os.Remove(w.path); err != nil {
+				failed = append(failed, w.path)
+			}
+		}
+	}
+	for i := len(t.overwrites) - 1; i >= 0; i-- {
+		if w := t.overwrites[i]; w.done {
+			if err := restore(w); err != nil {
+				failed = append(failed, w.path)
+			}
+		}
+	}
+	t.removeTemps()
+	if len(failed) > 0 {
+		return fmt.Errorf("the following files could not be restored: %v",
+			failed)
+	}
+	return nil
+}
+
+// restore writes an overwritten file's original contents back, using a
+// temporary file (renamed over the new file) so that the file is never left
+// partially written.
+func restore(w *txWrite) error {
+	perm := w.info.Mode().Perm()
+	f, err := tempSibling(w.path, "orig", perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(w.saved)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), w.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
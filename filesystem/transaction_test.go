@@ -0,0 +1,176 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const txDir = "zz_tx"
+
+// setupTxDir creates txDir containing the given files, each with 0640
+// permissions.
+func setupTxDir(files map[string]string, t *testing.T) {
+	os.RemoveAll(txDir)
+	if err := os.Mkdir(txDir, 0775); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		path := filepath.Join(txDir, name)
+		if err := ioutil.WriteFile(path, []byte(contents), 0640); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// txDirContents describes the files in txDir (including temporary files) and
+// their contents.
+func txDirContents(t *testing.T) string {
+	infos, err := ioutil.ReadDir(txDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := []string{}
+	for _, fi := range infos {
+		data, err := ioutil.ReadFile(filepath.Join(txDir, fi.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, fi.Name()+"="+string(data))
+	}
+	return strings.Join(result, " ")
+}
+
+func TestTransactionCommit(t *testing.T) {
+	setupTxDir(map[string]string{"a.txt": "A", "b.txt": "B"}, t)
+	defer os.RemoveAll(txDir)
+
+	a := filepath.Join(txDir, "a.txt")
+	b := filepath.Join(txDir, "b.txt")
+	tx := NewTransaction(time.Now())
+	tx.Overwrite(a, []byte("A"), []byte("A2"))
+	tx.Overwrite(b, []byte("B"), []byte("B2"))
+	tx.Move(b, filepath.Join(txDir, "d.txt"))
+	tx.Create(filepath.Join(txDir, "c.txt"), []byte("C"))
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	assertEquals("a.txt=A2 c.txt=C d.txt=B2", txDirContents(t), t)
+
+	info, err := os.Stat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Fatalf("Permissions not preserved: %v", info.Mode())
+	}
+}
+
+func TestTransactionSymlink(t *testing.T) {
+	setupTxDir(map[string]string{"real.go": "package a"}, t)
+	defer os.RemoveAll(txDir)
+
+	link := filepath.Join(txDir, "link.go")
+	if err := os.Symlink("real.go", link); err != nil {
+		t.Skip("symbolic links are not supported: ", err)
+	}
+	tx := NewTransaction(time.Time{})
+	tx.Overwrite(link, []byte("package a"), []byte("package b"))
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	assertEquals("link.go=package b real.go=package b", txDirContents(t), t)
+
+	info, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Fatal("Symbolic link was replaced by a regular file")
+	}
+}
+
+func TestTransactionModified(t *testing.T) {
+	setupTxDir(map[string]string{"a.txt": "A", "b.txt": "B"}, t)
+	defer os.RemoveAll(txDir)
+
+	a := filepath.Join(txDir, "a.txt")
+	b := filepath.Join(txDir, "b.txt")
+
+	// Contents differ from those the changes were derived from
+	tx := NewTransaction(time.Time{})
+	tx.Overwrite(a, []byte("A"), []byte("A2"))
+	tx.Overwrite(b, []byte("Old B"), []byte("B2"))
+	if err := tx.Commit(); err == nil ||
+		!strings.Contains(err.Error(), "modified") {
+		t.Fatalf("Commit should fail if a file changed (%v)", err)
+	}
+	assertEquals("a.txt=A b.txt=B", txDirContents(t), t)
+
+	// File modified after it was loaded
+	loaded := time.Now().Add(-time.Hour)
+	tx = NewTransaction(loaded)
+	tx.Overwrite(a, nil, []byte("A2"))
+	if err := tx.Commit(); err == nil {
+		t.Fatal("Commit should fail if a file was modified after loading")
+	}
+	assertEquals("a.txt=A b.txt=B", txDirContents(t), t)
+
+	// Created file already exists
+	tx = NewTransaction(time.Time{})
+	tx.Overwrite(a, nil, []byte("A2"))
+	tx.Create(b, []byte("B2"))
+	if err := tx.Commit(); err == nil {
+		t.Fatal("Commit should fail if a created file exists")
+	}
+	assertEquals("a.txt=A b.txt=B", txDirContents(t), t)
+}
+
+func TestTransactionRollback(t *testing.T) {
+	setupTxDir(map[string]string{"a.txt": "A", "b.txt": "B"}, t)
+	defer os.RemoveAll(txDir)
+
+	tx := NewTransaction(time.Time{})
+	tx.Overwrite(filepath.Join(txDir, "a.txt"), nil, []byte("A2"))
+	tx.Create(filepath.Join(txDir, "c.txt"), []byte("C"))
+	tx.Move(filepath.Join(txDir, "b.txt"), filepath.Join(txDir, "d.txt"))
+	if err := tx.check(); err != nil {
+		t.Fatal(err)
+	}
+	// Add a move after the checks in Commit, so that apply fails on it
+	tx.Move(filepath.Join(txDir, "missing.txt"), filepath.Join(txDir, "e.txt"))
+	if err := tx.writeTemps(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.apply(); err == nil {
+		t.Fatal("apply should have failed")
+	}
+	assertEquals("a.txt=A2 c.txt=C d.txt=B", strings.Join(
+		filterTemps(txDirContents(t)), " "), t)
+	if err := tx.rollback(); err != nil {
+		t.Fatal(err)
+	}
+	assertEquals("a.txt=A b.txt=B", txDirContents(t), t)
+}
+
+// filterTemps removes backup files from a description returned by
+// txDirContents.
+func filterTemps(contents string) []string {
+	result := []string{}
+	for _, s := range strings.Split(contents, " ") {
+		if !strings.HasPrefix(s, ".") {
+			result = append(result, s)
+		}
+	}
+	return result
+}
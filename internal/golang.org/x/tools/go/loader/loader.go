@@ -197,6 +197,15 @@ type Config struct {
 	// to startup, or by setting Build.CgoEnabled=false.
 	Build *build.Context
 
+	// LOCAL CHANGE (godoctor; see internal/versions.txt):
+	// If ParseCgoFiles is true, files that import the fake package
+	// "C" are parsed as ordinary Go source files instead of being
+	// preprocessed by cgo.  Unlike preprocessing, this preserves
+	// every token.Position.Offset in those files, but references to
+	// package C cannot be resolved; set TypeChecker.FakeImportC to
+	// avoid reporting them as errors.
+	ParseCgoFiles bool
+
 	// If DisplayPath is non-nil, it is used to transform each
 	// file name obtained from Build.Import().  This can be used
 	// to prevent a virtualized build.Config's file names from
@@ -695,6 +704,9 @@ func (conf *Config) parsePackageFiles(bp *build.Package, which rune) ([]*ast.Fil
 	switch which {
 	case 'g':
 		filenames = bp.GoFiles
+		if conf.ParseCgoFiles { // LOCAL CHANGE (godoctor)
+			filenames = append(append([]string{}, bp.GoFiles...), bp.CgoFiles...)
+		}
 	case 't':
 		filenames = bp.TestGoFiles
 	case 'x':
@@ -706,7 +718,8 @@ func (conf *Config) parsePackageFiles(bp *build.Package, which rune) ([]*ast.Fil
 	files, errs := parseFiles(conf.fset(), conf.build(), conf.DisplayPath, bp.Dir, filenames, conf.ParserMode)
 
 	// Preprocess CgoFiles and parse the outputs (sequentially).
-	if which == 'g' && bp.CgoFiles != nil {
+	// LOCAL CHANGE (godoctor): skipped if ParseCgoFiles is set.
+	if which == 'g' && bp.CgoFiles != nil && !conf.ParseCgoFiles {
 		cgofiles, err := processCgoFiles(bp, conf.fset(), conf.DisplayPath, conf.ParserMode)
 		if err != nil {
 			errs = append(errs, err)
@@ -0,0 +1,154 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file defines the Apply Patch refactoring, which reads changes from a
+// unified diff (e.g., one saved from an earlier run of the Go Doctor, or one
+// generated by another tool) and checks them as if they had been produced by
+// a refactoring.
+
+package refactoring
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/godoctor/godoctor/filesystem"
+)
+
+// ApplyPatch is a refactoring that makes the changes described by a unified
+// diff.  Like any other refactoring's changes, they are checked to ensure
+// that they do not introduce compilation errors.
+type ApplyPatch struct {
+	base RefactoringBase
+}
+
+func (r *ApplyPatch) Description() *Description {
+	return &Description{
+		Name:      "Apply Patch",
+		Synopsis:  "Applies a unified diff, checking that it does not introduce errors",
+		Usage:     "<patch_file> [<fuzzy?>]",
+		HTMLDoc:   applyDoc,
+		Multifile: true,
+		Params: []Parameter{
+			Parameter{
+				Label:        "Patch File",
+				Prompt:       "Unified diff to apply",
+				DefaultValue: "",
+				Kind:         FileParam,
+				MustExist:    true,
+			},
+			Parameter{
+				Label:        "Fuzzy",
+				Prompt:       "Allow hunks to apply at different line numbers?",
+				DefaultValue: false,
+				Optional:     true,
+			},
+		},
+		Hidden: false,
+	}
+}
+
+func (r *ApplyPatch) Run(config *Config) *Result {
+	if r.CheckInitialConditions(config).ContainsErrors() {
+		return &r.base.Result
+	}
+	if r.CheckFinalConditions(config, config.Args).ContainsErrors() {
+		return &r.base.Result
+	}
+
+	fuzzy := len(config.Args) > 1 && config.Args[1].(bool)
+	r.readPatch(config, config.Args[0].(string), fuzzy)
+	if r.base.Log.ContainsErrors() {
+		return &r.base.Result
+	}
+	r.base.UpdateLog(config, true)
+	return &r.base.Result
+}
+
+// CheckInitialConditions loads the program.  Errors in the original program
+// are reported as warnings.
+func (r *ApplyPatch) CheckInitialConditions(config *Config) *Log {
+	r.base.Run(config)
+	r.base.Log.ChangeInitialErrorsToWarnings()
+	return r.base.Log
+}
+
+// CheckFinalConditions validates the arguments.
+func (r *ApplyPatch) CheckFinalConditions(config *Config, args []interface{}) *Log {
+	validateArgs(config, args, r.Description(), r.base.Log)
+	return r.base.Log
+}
+
+// readPatch reads the given patch file, converting it to EditSets, and warns
+// about any changes to files that are not part of the loaded program (since
+// those changes cannot be checked).
+func (r *ApplyPatch) readPatch(config *Config, patchFile string, fuzzy bool) {
+	dir, err := os.Getwd()
+	if err != nil {
+		r.base.Log.Error(err)
+		return
+	}
+	file, err := config.FileSystem.OpenFile(patchFile)
+	if err != nil {
+		r.base.Log.Error(err)
+		r.base.Log.AssociateCode("apply/invalid-patch")
+		return
+	}
+	defer file.Close()
+
+	edits, err := filesystem.ReadPatch(file, config.FileSystem, dir, fuzzy)
+	if err != nil {
+		r.base.Log.Errorf("The patch cannot be applied: %s", err)
+		r.base.Log.AssociateCode("apply/invalid-patch")
+		return
+	}
+
+	// Use the same filenames as the loaded program, which may be relative
+	programFiles := map[string]string{}
+	for filename := range r.base.Log.files() {
+		if abs, err := filepath.Abs(filename); err == nil {
+			programFiles[abs] = filename
+		}
+	}
+	unchecked := []string{}
+	for filename, es := range edits {
+		if programFile, ok := programFiles[filename]; ok {
+			r.base.Edits[programFile] = es
+		} else {
+			r.base.Edits[filename] = es
+			unchecked = append(unchecked, filename)
+		}
+	}
+	sort.Strings(unchecked)
+	for _, filename := range unchecked {
+		r.base.Log.Warnf("%s is not part of the program being "+
+			"refactored, so changes to it will not be checked",
+			filepath.Base(filename))
+		r.base.Log.AssociateOffset(filename, 0, 0)
+		r.base.Log.AssociateCode("apply/unchecked")
+	}
+}
+
+const applyDoc = `
+  <h4>Purpose</h4>
+  <p>The Apply Patch refactoring makes the changes described by a unified diff
+  (a patch file), checking them in the same way as any other refactoring's
+  changes.  The patch may have been saved from an earlier run of the Go
+  Doctor, or it may have been generated by another tool.</p>
+
+  <h4>Usage</h4>
+  <p>Supply the name of the patch file.  Relative filenames in the patch are
+  interpreted relative to the current directory, and the <tt>a/</tt> and
+  <tt>b/</tt> prefixes used by git are recognized.  Patches that create or
+  delete files are not supported.</p>
+  <p>By default, every hunk must match the current file contents exactly at
+  the line number given in its header.  If the optional second argument is
+  true, a hunk that does not match at that line is applied at the nearest line
+  where it does match (as long as it follows the preceding hunk).</p>
+  <p>After the changes are applied, the program is type checked again, and an
+  error is reported if the changes would introduce compilation errors.
+  Changes to files that are not part of the program being refactored are
+  applied, but they cannot be checked.</p>
+`
@@ -0,0 +1,291 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file allows a refactoring to be performed under several build
+// configurations (combinations of GOOS, GOARCH, and build tags).  A program
+// loaded under a single configuration omits files excluded by build
+// constraints (e.g., foo_windows.go when refactoring on Linux), so a refactoring
+// performed under only that configuration will not update those files.
+// RunInConfigurations performs the refactoring under each configuration and
+// merges the results.
+
+package refactoring
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/godoctor/godoctor/text"
+)
+
+// A BuildConfig describes a build configuration under which a program can be
+// loaded.  Empty GOOS and GOARCH fields denote the host operating system and
+// architecture.
+type BuildConfig struct {
+	GOOS   string
+	GOARCH string
+	Tags   []string
+}
+
+// ParseBuildConfig parses a build configuration of the form
+// GOOS/GOARCH[+tag...], e.g., "windows/amd64" or "linux/arm+integration".
+func ParseBuildConfig(s string) (BuildConfig, error) {
+	parts := strings.Split(s, "+")
+	osArch := strings.Split(parts[0], "/")
+	if len(osArch) != 2 || osArch[0] == "" || osArch[1] == "" {
+		return BuildConfig{}, fmt.Errorf("Invalid build configuration "+
+			"\"%s\" (expected GOOS/GOARCH[+tag...])", s)
+	}
+	for _, tag := range parts[1:] {
+		if tag == "" {
+			return BuildConfig{}, fmt.Errorf("Invalid build "+
+				"configuration \"%s\" (empty build tag)", s)
+		}
+	}
+	return BuildConfig{
+		GOOS:   osArch[0],
+		GOARCH: osArch[1],
+		Tags:   parts[1:],
+	}, nil
+}
+
+// ParseBuildConfigs parses a comma-separated list of build configurations,
+// each of which must be in the form accepted by ParseBuildConfig.
+func ParseBuildConfigs(s string) ([]BuildConfig, error) {
+	result := []BuildConfig{}
+	for _, config := range strings.Split(s, ",") {
+		bc, err := ParseBuildConfig(strings.TrimSpace(config))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, bc)
+	}
+	return result, nil
+}
+
+func (bc BuildConfig) String() string {
+	goos, goarch := bc.GOOS, bc.GOARCH
+	if goos == "" {
+		goos = "host"
+	}
+	if goarch == "" {
+		goarch = "host"
+	}
+	return strings.Join(append([]string{goos + "/" + goarch}, bc.Tags...),
+		"+")
+}
+
+// RunInConfigurations runs the given refactoring once for each of the build
+// configurations in config.BuildConfigs, then merges the results.  If
+// config.BuildConfigs is empty, it simply runs the refactoring.
+//
+// Configurations in which the selected file is excluded by build constraints
+// are skipped.  The Edits in the merged Result are the union of the edits from
+// every configuration; if two configurations require different edits to the
+// same region of a file, an error is logged.  The Log in the merged Result is
+// the Log from the first configuration, followed by any distinct entries from
+// the other configurations (prefixed by the name of the configuration).
+func RunInConfigurations(r Refactoring, config *Config) *Result {
+	if len(config.BuildConfigs) == 0 {
+		return r.Run(config)
+	}
+
+	var result *Result
+	var merger *editMerger
+	skipped := []string{}
+	for i := range config.BuildConfigs {
+		bc := &config.BuildConfigs[i]
+		c := *config
+		c.BuildConfigs = nil
+		c.build = bc
+
+		if !c.selectionIncluded() {
+			skipped = append(skipped, bc.String())
+			continue
+		}
+
+		res := r.Run(&c)
+		if config.Scope == nil {
+			// Avoid guessing the scope in each configuration
+			config.Scope = c.Scope
+		}
+		if result == nil {
+			result = &Result{Log: res.Log, Edits: res.Edits,
+				NewFiles: res.NewFiles, Moves: res.Moves}
+			if result.Edits == nil {
+				result.Edits = map[string]*text.EditSet{}
+			}
+			merger = newEditMerger(result.Edits, bc.String())
+		} else {
+			mergeLog(result.Log, res.Log, bc.String())
+			merger.merge(res.Edits, bc.String(), result.Log)
+			result.NewFiles = mergeFileMap(result.NewFiles, res.NewFiles,
+				"different contents for the new file", bc.String(), result.Log)
+			result.Moves = mergeFileMap(result.Moves, res.Moves,
+				"a different new name for", bc.String(), result.Log)
+		}
+		if config.isCanceled() {
+			break
+		}
+	}
+
+	if result == nil {
+		result = &Result{Log: NewLog(), Edits: map[string]*text.EditSet{}}
+		result.Log.Errorf("The selected file, %s, is excluded by build "+
+			"constraints in every build configuration",
+			config.Selection.GetFilename())
+		result.Log.AssociateCode("build/excluded")
+		return result
+	}
+	for _, name := range skipped {
+		result.Log.Infof("Skipped build configuration %s, which "+
+			"excludes the selected file", name)
+	}
+	return result
+}
+
+// mergeFileMap adds the entries in from to to (allocating to if necessary),
+// logging an error if the two maps have different values for the same file.
+func mergeFileMap(to, from map[string]string, what, configName string, log *Log) map[string]string {
+	for filename, value := range from {
+		if to == nil {
+			to = map[string]string{}
+		}
+		if existing, ok := to[filename]; !ok {
+			to[filename] = value
+		} else if existing != value {
+			log.Errorf("Build configuration %s requires %s %s",
+				configName, what, filename)
+			log.AssociateCode("build/conflict")
+		}
+	}
+	return to
+}
+
+// selectionIncluded returns true iff the selected file is included in the
+// program under this Config's build configuration.
+func (config *Config) selectionIncluded() bool {
+	filename := config.Selection.GetFilename()
+	ctxt := newBuildContext(config, loadingTask)
+	match, err := ctxt.MatchFile(filepath.Dir(filename), filepath.Base(filename))
+	// If the file cannot be matched for some other reason, let the
+	// refactoring report the problem
+	return match || err != nil
+}
+
+// mergeLog appends the entries in from that do not duplicate entries already
+// in to.  Since the two logs' FileSets are unrelated, entries' Pos and End are
+// set only if the file they refer to is also in to.Fset; their filenames are
+// retained regardless, and their offsets and lengths are translated to refer
+// to the same files as to's entries (see Log.OriginalOffset).
+func mergeLog(to, from *Log, configName string) {
+	existing := map[string]bool{}
+	for _, entry := range to.Entries {
+		existing[entry.String()] = true
+	}
+	files := to.files()
+	from.locateAll()
+	for _, entry := range from.Entries {
+		if existing[entry.String()] {
+			continue
+		}
+		existing[entry.String()] = true
+		newEntry := &Entry{
+			isInitial: entry.isInitial,
+			Severity:  entry.Severity,
+			Message:   fmt.Sprintf("[%s] %s", configName, entry.Message),
+			Filename:  entry.Filename,
+			Code:      entry.Code,
+			Fixes:     entry.Fixes,
+		}
+		newEntry.Offset, newEntry.Length = translateOffset(to, from, entry.Filename, entry.Offset, entry.Length)
+		newEntry.Pos, newEntry.End = resolvePos(entry.Filename, newEntry.Offset, newEntry.Length, files)
+		for _, related := range entry.Related {
+			newRelated := &RelatedLocation{
+				Message:  related.Message,
+				Filename: related.Filename,
+			}
+			newRelated.Offset, newRelated.Length = translateOffset(to, from, related.Filename, related.Offset, related.Length)
+			newRelated.Pos, newRelated.End = resolvePos(related.Filename, newRelated.Offset, newRelated.Length, files)
+			newEntry.Related = append(newEntry.Related, newRelated)
+		}
+		to.Entries = append(to.Entries, newEntry)
+	}
+}
+
+// translateOffset translates a region of a file referred to by the entries in
+// from to the corresponding region of the file referred to by to's entries.
+func translateOffset(to, from *Log, filename string, offset, length int) (int, int) {
+	offset, length = from.OriginalOffset(filename, offset, length)
+	return mapOffsets(to.edits[filename], offset, length, false)
+}
+
+// An editMerger merges EditSets produced by different build configurations.
+// Edits that are identical in both configurations are added only once.
+type editMerger struct {
+	edits map[string]*text.EditSet
+	// For each file and region, the replacement text and the name of the
+	// configuration that required that edit
+	added map[string]map[text.Extent]mergedEdit
+}
+
+type mergedEdit struct {
+	replacement string
+	configName  string
+}
+
+func newEditMerger(edits map[string]*text.EditSet, configName string) *editMerger {
+	m := &editMerger{
+		edits: edits,
+		added: map[string]map[text.Extent]mergedEdit{},
+	}
+	for filename, es := range edits {
+		m.added[filename] = map[text.Extent]mergedEdit{}
+		es.Iterate(func(extent *text.Extent, replacement string) bool {
+			m.added[filename][*extent] = mergedEdit{replacement, configName}
+			return true
+		})
+	}
+	return m
+}
+
+// merge adds the given edits to the merged EditSets, logging an error for
+// each edit that conflicts with an edit required by another configuration.
+func (m *editMerger) merge(edits map[string]*text.EditSet, configName string, log *Log) {
+	filenames := []string{}
+	for filename := range edits {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	for _, filename := range filenames {
+		if m.edits[filename] == nil {
+			m.edits[filename] = text.NewEditSet()
+			m.added[filename] = map[text.Extent]mergedEdit{}
+		}
+		edits[filename].Iterate(func(extent *text.Extent, replacement string) bool {
+			if prev, ok := m.added[filename][*extent]; ok {
+				if prev.replacement != replacement {
+					log.Errorf("Build configurations %s and %s "+
+						"require different edits to %s at offset %d",
+						prev.configName, configName,
+						filename, extent.Offset)
+					log.AssociateCode("build/conflict")
+				}
+				return true
+			}
+			if err := m.edits[filename].Add(extent, replacement); err != nil {
+				log.Errorf("Build configuration %s requires an "+
+					"edit to %s that conflicts with another "+
+					"configuration: %s", configName, filename, err)
+				log.AssociateCode("build/conflict")
+				return true
+			}
+			m.added[filename][*extent] = mergedEdit{replacement, configName}
+			return true
+		})
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package refactoring_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/godoctor/godoctor/filesystem"
+	"github.com/godoctor/godoctor/refactoring"
+	"github.com/godoctor/godoctor/text"
+)
+
+// touchAll is a refactoring that inserts a comment at the beginning of every
+// file in the initial packages.
+type touchAll struct {
+	refactoring.RefactoringBase
+}
+
+func (r *touchAll) Description() *refactoring.Description {
+	return &refactoring.Description{Name: "Touch All"}
+}
+
+func (r *touchAll) Run(config *refactoring.Config) *refactoring.Result {
+	r.RefactoringBase.Run(config)
+	if r.Log.ContainsErrors() {
+		return &r.Result
+	}
+	for _, pkg := range r.Program.InitialPackages() {
+		for _, file := range pkg.Files {
+			filename := r.Program.Fset.Position(file.Pos()).Filename
+			if r.Edits[filename] == nil {
+				r.Edits[filename] = text.NewEditSet()
+			}
+			r.Edits[filename].Add(&text.Extent{Offset: 0, Length: 0}, "//\n")
+		}
+	}
+	return &r.Result
+}
+
+func TestRunInConfigurations(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "godoctor-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+
+	dir := filepath.Join(gopath, "src", "p")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"p.go":         "package p\n\nvar X = Y\n",
+		"y_linux.go":   "package p\n\nvar Y = 1\n",
+		"y_windows.go": "package p\n\nvar Y = 2\n",
+	}
+	for name, contents := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	configs, err := refactoring.ParseBuildConfigs("linux/amd64,windows/amd64+foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configs[1].String() != "windows/amd64+foo" {
+		t.Fatalf("Expected windows/amd64+foo, got %s", configs[1])
+	}
+	if _, err := refactoring.ParseBuildConfigs("linux"); err == nil {
+		t.Fatalf("Should have rejected \"linux\"")
+	}
+
+	result := refactoring.RunInConfigurations(new(touchAll), &refactoring.Config{
+		FileSystem: filesystem.NewLocalFileSystem(),
+		Scope:      []string{"p"},
+		GoPath:     gopath,
+		Selection: &text.OffsetLengthSelection{
+			Filename: filepath.Join(dir, "p.go"),
+			Offset:   0,
+			Length:   0,
+		},
+		BuildConfigs: configs,
+	})
+	if result.Log.ContainsErrors() {
+		t.Fatalf("Unexpected errors:\n%s", result.Log)
+	}
+
+	edited := []string{}
+	for filename, edits := range result.Edits {
+		name := filepath.Base(filename)
+		edited = append(edited, name)
+		output, err := text.ApplyToString(edits, files[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != "//\n"+files[name] {
+			t.Fatalf("Incorrect output for %s:\n%s", name, output)
+		}
+	}
+	sort.Strings(edited)
+	expected := []string{"p.go", "y_linux.go", "y_windows.go"}
+	if !reflect.DeepEqual(edited, expected) {
+		t.Fatalf("Expected edits to %v, got %v", expected, edited)
+	}
+}
+
+// markX is a refactoring that inserts a comment into p.go, at a position that
+// depends on the build configuration, and logs the location of X.
+type markX struct {
+	refactoring.RefactoringBase
+}
+
+func (r *markX) Description() *refactoring.Description {
+	return &refactoring.Description{Name: "Mark X"}
+}
+
+func (r *markX) Run(config *refactoring.Config) *refactoring.Result {
+	r.RefactoringBase.Run(config)
+	if r.Log.ContainsErrors() {
+		return &r.Result
+	}
+	offset, comment := 0, "// linux\n"
+	for _, pkg := range r.Program.InitialPackages() {
+		for _, file := range pkg.Files {
+			filename := r.Program.Fset.Position(file.Pos()).Filename
+			if filepath.Base(filename) == "y_windows.go" {
+				offset, comment = len("package p\n\n"), "/* w */ "
+			}
+		}
+	}
+	for _, obj := range r.SelectedNodePkg.Defs {
+		if obj != nil && obj.Name() == "X" {
+			r.Log.Infof("X (%s)", strings.TrimSpace(comment))
+			r.Log.AssociatePos(obj.Pos(), obj.Pos()+1)
+		}
+	}
+	r.Edits[r.Filename].Add(&text.Extent{Offset: offset, Length: 0}, comment)
+	r.UpdateLog(config, false)
+	return &r.Result
+}
+
+func TestRunInConfigurationsLog(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "godoctor-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+
+	dir := filepath.Join(gopath, "src", "p")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"p.go":         "package p\n\nvar X = Y\n",
+		"y_linux.go":   "package p\n\nvar Y = 1\n",
+		"y_windows.go": "package p\n\nvar Y = 2\n",
+	}
+	for name, contents := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	configs, err := refactoring.ParseBuildConfigs("linux/amd64,windows/amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(dir, "p.go")
+	result := refactoring.RunInConfigurations(new(markX), &refactoring.Config{
+		FileSystem: filesystem.NewLocalFileSystem(),
+		Scope:      []string{"p"},
+		GoPath:     gopath,
+		Selection: &text.OffsetLengthSelection{
+			Filename: filename,
+			Offset:   0,
+			Length:   0,
+		},
+		BuildConfigs: configs,
+	})
+	if result.Log.ContainsErrors() {
+		t.Fatalf("Unexpected errors:\n%s", result.Log)
+	}
+	if len(result.Edits[filename].String()) == 0 {
+		t.Fatalf("No edits to p.go")
+	}
+
+	// X is at offset 15 in the original file, in both configurations
+	found := 0
+	for _, entry := range result.Log.Entries {
+		if entry.Filename != filename {
+			continue
+		}
+		found++
+		offset, length := result.Log.OriginalOffset(entry.Filename,
+			entry.Offset, entry.Length)
+		if offset != 15 || length != 1 {
+			t.Errorf("%s: expected original offset 15, length 1; "+
+				"got %d, %d", entry.Message, offset, length)
+		}
+	}
+	if found != 2 {
+		t.Fatalf("Expected an entry from each configuration:\n%s",
+			result.Log)
+	}
+}
@@ -0,0 +1,328 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file defines FormatEditedRegions, which reformats only the parts of a
+// file that a refactoring changed.
+
+package refactoring
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"sort"
+	"strings"
+
+	"github.com/godoctor/godoctor/text"
+)
+
+// A formatRegion is a sequence of consecutive statements (in a block or case
+// clause) or top-level declarations that will be reformatted together.
+type formatRegion struct {
+	nodes      []ast.Node // The statement or declaration list
+	first      int        // Index of the first node to format
+	last       int        // Index of the last node to format
+	start, end int        // Offsets of the first and last nodes
+	indent     int        // Number of tabs to indent each node
+}
+
+// FormatEditedRegions reformats the code affected by the edits to the current
+// file (r.Edits[r.Filename]).  Unlike FormatFileInEditor, it does not reprint
+// the entire file: only the statements and top-level declarations that
+// contain edited text are reformatted, and the resulting changes are added to
+// the existing edits as minimal, line-based edits.  Code that the refactoring
+// did not touch is left exactly as the user wrote it.
+func (r *RefactoringBase) FormatEditedRegions() {
+	edits := r.Edits[r.Filename]
+	contents, err := text.ApplyToString(edits, string(r.FileContents))
+	if err != nil {
+		r.Log.Errorf("Transformation produced invalid EditSet: %v",
+			err.Error())
+		return
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", contents, parser.ParseComments)
+	if err != nil {
+		r.Log.Errorf("Transformation will introduce syntax errors: %v", err)
+		r.Log.AssociatePos(r.File.Pos(), r.File.End())
+		return
+	}
+
+	tfile := fset.File(file.Package)
+	regions := []*formatRegion{}
+	for _, extent := range changedExtents(edits) {
+		if region := enclosingRegion(file, tfile, contents, extent); region != nil {
+			regions = append(regions, region)
+		}
+	}
+
+	formatEdits := text.NewEditSet()
+	for _, region := range mergeRegions(regions) {
+		err := r.formatRegion(region, fset, file, contents, formatEdits)
+		if err != nil {
+			r.Log.Error(err)
+			return
+		}
+	}
+	r.Edits[r.Filename] = text.Compose(edits, formatEdits)
+}
+
+// changedExtents returns the regions of the edited text that were inserted by
+// the given edits, excluding leading and trailing whitespace (unless the
+// replacement text is entirely whitespace).  An edit that only deletes text
+// produces an empty extent at the point where the text was removed.
+func changedExtents(edits *text.EditSet) []*text.Extent {
+	result := []*text.Extent{}
+	delta := 0
+	edits.Iterate(func(extent *text.Extent, replacement string) bool {
+		start := extent.Offset + delta
+		end := start + len(replacement)
+		delta += len(replacement) - extent.Length
+		if trimmed := strings.TrimLeft(replacement, whitespace); trimmed != "" {
+			start += len(replacement) - len(trimmed)
+			end -= len(trimmed) - len(strings.TrimRight(trimmed, whitespace))
+		}
+		result = append(result, &text.Extent{start, end - start})
+		return true
+	})
+	return result
+}
+
+const whitespace = " \t\r\n"
+
+// enclosingRegion returns the smallest run of statements or top-level
+// declarations that contains the given extent, or nil if the extent is not
+// inside any statement or declaration (e.g., it is in the package clause).
+// The run includes any nodes that end where the extent begins or begin where
+// it ends, so inserting whitespace between two nodes reformats both.
+func enclosingRegion(file *ast.File, tfile *token.File, contents string, extent *text.Extent) *formatRegion {
+	var best *formatRegion
+	consider := func(nodes []ast.Node, indent int) {
+		region := runCovering(nodes, tfile, extent)
+		if region != nil && (best == nil ||
+			region.end-region.start < best.end-best.start) {
+			region.indent = indent
+			best = region
+		}
+	}
+
+	decls := make([]ast.Node, len(file.Decls))
+	for i, decl := range file.Decls {
+		decls[i] = decl
+	}
+	consider(decls, 0)
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n == nil {
+			return true
+		}
+		if start, end := nodeExtent(n, tfile); end < extent.Offset ||
+			start > extent.OffsetPastEnd() {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			consider(stmtNodes(n.List),
+				indentOfLine(contents, tfile, n.Lbrace)+1)
+		case *ast.CaseClause:
+			consider(stmtNodes(n.Body),
+				indentOfLine(contents, tfile, n.Case)+1)
+		case *ast.CommClause:
+			consider(stmtNodes(n.Body),
+				indentOfLine(contents, tfile, n.Case)+1)
+		}
+		return true
+	})
+	return best
+}
+
+func stmtNodes(stmts []ast.Stmt) []ast.Node {
+	result := make([]ast.Node, len(stmts))
+	for i, stmt := range stmts {
+		result[i] = stmt
+	}
+	return result
+}
+
+// runCovering returns the run of consecutive nodes that together contain the
+// given extent, or nil if the extent is not contained in the nodes.
+func runCovering(nodes []ast.Node, tfile *token.File, extent *text.Extent) *formatRegion {
+	var region *formatRegion
+	s, e := extent.Offset, extent.OffsetPastEnd()
+	for i, n := range nodes {
+		start, end := nodeExtent(n, tfile)
+		if start > e || end < s {
+			continue
+		}
+		if region == nil {
+			region = &formatRegion{nodes: nodes, first: i, start: start}
+		}
+		region.last, region.end = i, end
+	}
+	if region == nil || s < region.start || e > region.end {
+		return nil
+	}
+	return region
+}
+
+// nodeExtent returns the offsets of the start and end of the given node,
+// including its doc comment (if it is a declaration).
+func nodeExtent(n ast.Node, tfile *token.File) (start, end int) {
+	pos := n.Pos()
+	switch n := n.(type) {
+	case *ast.FuncDecl:
+		if n.Doc != nil {
+			pos = n.Doc.Pos()
+		}
+	case *ast.GenDecl:
+		if n.Doc != nil {
+			pos = n.Doc.Pos()
+		}
+	}
+	return tfile.Offset(pos), tfile.Offset(n.End())
+}
+
+// indentOfLine returns the number of tabs at the start of the line containing
+// the given position.
+func indentOfLine(contents string, tfile *token.File, pos token.Pos) int {
+	offset := lineStart(contents, tfile.Offset(pos))
+	indent := 0
+	for offset+indent < len(contents) && contents[offset+indent] == '\t' {
+		indent++
+	}
+	return indent
+}
+
+// lineStart returns the offset of the first character on the line containing
+// the given offset.
+func lineStart(contents string, offset int) int {
+	return strings.LastIndex(contents[:offset], "\n") + 1
+}
+
+// mergeRegions sorts the given regions, combines overlapping regions in the
+// same list, and removes regions that are nested inside other regions.
+func mergeRegions(regions []*formatRegion) []*formatRegion {
+	sort.Sort(byRegionStart(regions))
+	result := []*formatRegion{}
+	for _, region := range regions {
+		if len(result) > 0 {
+			prev := result[len(result)-1]
+			if region.start <= prev.end && sameList(region, prev) {
+				if region.last > prev.last {
+					prev.last, prev.end = region.last, region.end
+				}
+				continue
+			} else if region.end <= prev.end {
+				continue // Nested inside prev
+			}
+		}
+		result = append(result, region)
+	}
+	return result
+}
+
+func sameList(a, b *formatRegion) bool {
+	return len(a.nodes) > 0 && len(b.nodes) > 0 && a.nodes[0] == b.nodes[0]
+}
+
+type byRegionStart []*formatRegion
+
+func (s byRegionStart) Len() int      { return len(s) }
+func (s byRegionStart) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byRegionStart) Less(i, j int) bool {
+	if s[i].start == s[j].start {
+		return s[i].end > s[j].end
+	}
+	return s[i].start < s[j].start
+}
+
+// formatRegion prints the nodes in the given region and adds edits to
+// formatEdits that replace the region's lines that changed.  Whitespace
+// between the nodes is normalized, but comments between them are preserved.
+func (r *RefactoringBase) formatRegion(region *formatRegion, fset *token.FileSet, file *ast.File, contents string, formatEdits *text.EditSet) error {
+	tabs := strings.Repeat("\t", region.indent)
+	start := region.start
+	var b bytes.Buffer
+	if ls := lineStart(contents, start); strings.TrimSpace(contents[ls:start]) == "" {
+		start = ls
+		b.WriteString(tabs)
+	}
+
+	printConfig := &printer.Config{
+		Mode:     printer.UseSpaces | printer.TabIndent,
+		Tabwidth: 8,
+		Indent:   region.indent,
+	}
+	tfile := fset.File(file.Package)
+	for i := region.first; i <= region.last; i++ {
+		n := region.nodes[i]
+		if i > region.first {
+			_, prevEnd := nodeExtent(region.nodes[i-1], tfile)
+			nodeStart, _ := nodeExtent(n, tfile)
+			b.WriteString(normalizeGap(contents[prevEnd:nodeStart],
+				tabs, region.indent == 0))
+		}
+		var nb bytes.Buffer
+		node := &printer.CommentedNode{Node: n, Comments: file.Comments}
+		if err := printConfig.Fprint(&nb, fset, node); err != nil {
+			return err
+		}
+		b.WriteString(strings.TrimLeft(nb.String(), "\t"))
+	}
+
+	// go/printer always uses \n line endings
+	formatted := text.FileFormat{CRLF: r.FileFormat.CRLF}.Apply(b.String())
+	return addLineDiff(formatEdits, start, contents[start:region.end],
+		formatted)
+}
+
+// addLineDiff adds edits to the given EditSet that change the text orig,
+// which begins at the given offset, into the text formatted.  Only the lines
+// that differ are replaced.
+func addLineDiff(edits *text.EditSet, start int, orig, formatted string) error {
+	diff := text.Diff(
+		strings.SplitAfter(orig, "\n"),
+		strings.SplitAfter(formatted, "\n"))
+	// Combine adjacent edits (e.g., a deleted line followed by its
+	// replacement) so that each changed run of lines is a single edit
+	extents, replacements := []*text.Extent{}, []string{}
+	diff.Iterate(func(extent *text.Extent, replacement string) bool {
+		if n := len(extents); n > 0 &&
+			extents[n-1].OffsetPastEnd() == start+extent.Offset {
+			extents[n-1].Length += extent.Length
+			replacements[n-1] += replacement
+		} else {
+			extents = append(extents,
+				&text.Extent{start + extent.Offset, extent.Length})
+			replacements = append(replacements, replacement)
+		}
+		return true
+	})
+	for i, extent := range extents {
+		if err := edits.Add(extent, replacements[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeGap returns the text to place between two consecutive nodes in a
+// formatted region, given the original text between them.  Gaps containing
+// comments are unchanged.  Otherwise, nodes on separate lines remain on
+// separate lines (separated by at most one blank line, or exactly one between
+// top-level declarations), and nodes on the same line are separated by a
+// newline.
+func normalizeGap(gap string, tabs string, topLevel bool) string {
+	if strings.TrimSpace(gap) != "" {
+		return gap
+	}
+	newlines := strings.Count(gap, "\n")
+	if topLevel || newlines > 1 {
+		return "\n\n" + tabs
+	}
+	return "\n" + tabs
+}
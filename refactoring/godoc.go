@@ -32,21 +32,33 @@ func (r *AddGoDoc) Description() *Description {
 }
 
 func (r *AddGoDoc) Run(config *Config) *Result {
-	r.base.Run(config)
-	r.base.Log.ChangeInitialErrorsToWarnings()
-	if r.base.Log.ContainsErrors() {
+	if r.CheckInitialConditions(config).ContainsErrors() {
 		return &r.base.Result
 	}
-	if !ValidateArgs(config, r.Description(), r.base.Log) {
+	if r.CheckFinalConditions(config, config.Args).ContainsErrors() {
 		return &r.base.Result
 	}
 
 	r.removeSemicolons()
 	r.addComments()
-	r.base.FormatFileInEditor()
+	r.base.FormatEditedRegions()
 	return &r.base.Result
 }
 
+// CheckInitialConditions loads the program.  Since this refactoring applies
+// to an entire file, any selection within a loaded file is acceptable.
+func (r *AddGoDoc) CheckInitialConditions(config *Config) *Log {
+	r.base.Run(config)
+	r.base.Log.ChangeInitialErrorsToWarnings()
+	return r.base.Log
+}
+
+// CheckFinalConditions verifies that no arguments were supplied.
+func (r *AddGoDoc) CheckFinalConditions(config *Config, args []interface{}) *Log {
+	validateArgs(config, args, r.Description(), r.base.Log)
+	return r.base.Log
+}
+
 // removeSemicolons iterates through the top-level declarations in a File and
 // the specs of general declarations, and if two consecutive declarations occur
 // on the same line, splits them onto separate lines.  The intention is to
@@ -125,7 +137,8 @@ const godocDoc = `
   <h4>Purpose</h4>
   <p>This refactoring searches a file for exported declarations that do not have
   GoDoc comments and adds TODO comment stubs to those declarations.</p>
-  <p>The refactored source code is formatted (similarly to gofmt).</p>
+  <p>The declarations that are changed are formatted (similarly to gofmt);
+  the rest of the file is left unchanged.</p>
 
   <h4>Usage</h4>
   <p>This refactoring is applied to an entire file.  It does not require any
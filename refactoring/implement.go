@@ -0,0 +1,472 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file defines a refactoring that adds stub methods to a named type so
+// that it implements a given interface.
+
+package refactoring
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/godoctor/godoctor/internal/golang.org/x/tools/go/types"
+)
+
+// The ImplementInterface refactoring adds a stub method to a named type for
+// each method of an interface that the type does not already have.
+type ImplementInterface struct {
+	RefactoringBase
+	// The type to add methods to, found by CheckInitialConditions
+	typeName *types.TypeName
+	// The interface to implement, found by CheckFinalConditions
+	iface *types.TypeName
+}
+
+func (r *ImplementInterface) Description() *Description {
+	return &Description{
+		Name:      "Implement Interface",
+		Synopsis:  "Adds stub methods so that a type implements an interface",
+		Usage:     "<interface>",
+		HTMLDoc:   implementInterfaceDoc,
+		Multifile: false,
+		Params: []Parameter{Parameter{
+			Label:        "Interface:",
+			Prompt:       "The interface to implement (e.g., io.Reader).",
+			DefaultValue: "",
+		}},
+		Hidden: false,
+	}
+}
+
+func (r *ImplementInterface) Run(config *Config) *Result {
+	if r.CheckInitialConditions(config).ContainsErrors() {
+		return &r.Result
+	}
+	if r.CheckFinalConditions(config, config.Args).ContainsErrors() {
+		return &r.Result
+	}
+
+	missing := r.missingMethods()
+	if r.Log.ContainsErrors() {
+		return &r.Result
+	}
+	if len(missing) == 0 {
+		r.Log.Infof("%s already implements %s", r.typeName.Name(),
+			r.iface.Name())
+		return &r.Result
+	}
+	r.addStubs(missing)
+	r.UpdateImports()
+	r.UpdateLog(config, true)
+	return &r.Result
+}
+
+// CheckInitialConditions loads the program and determines whether the
+// selected identifier refers to a named type to which methods can be added.
+func (r *ImplementInterface) CheckInitialConditions(config *Config) *Log {
+	r.typeName = nil
+	r.RefactoringBase.Run(config)
+	// Type errors are common before an interface is implemented (e.g.,
+	// var _ I = &T{}), and the refactoring may well fix them
+	r.Log.ChangeInitialErrorsToWarnings()
+	if r.Log.ContainsErrors() {
+		return r.Log
+	}
+
+	ident, ok := r.SelectedNode.(*ast.Ident)
+	if !ok {
+		r.Log.Errorf("Please select the name of a type.\n\nSelected node: %s", reflect.TypeOf(r.SelectedNode))
+		r.Log.AssociatePos(r.SelectionStart, r.SelectionEnd)
+		return r.Log
+	}
+	typeName, ok := r.SelectedNodePkg.ObjectOf(ident).(*types.TypeName)
+	if !ok {
+		r.Log.Errorf("Please select the name of a type (%s is not a type).", ident.Name)
+		r.Log.AssociateNode(ident)
+		return r.Log
+	}
+
+	named, ok := typeName.Type().(*types.Named)
+	switch {
+	case !ok || typeName.Pkg() != r.SelectedNodePkg.Pkg ||
+		typeName.Parent() != typeName.Pkg().Scope():
+		r.Log.Errorf("Methods can only be added to a named type declared at the top level of this package.")
+	case isInterface(named):
+		r.Log.Errorf("%s is an interface; methods cannot be added to it.", ident.Name)
+	case isPointer(named):
+		r.Log.Errorf("%s is a pointer type; methods cannot be added to it.", ident.Name)
+	case typeName.Pos() < r.File.Pos() || typeName.Pos() >= r.File.End():
+		r.Log.Errorf("%s is declared in %s.  Please select it in that file.",
+			ident.Name, r.Program.Fset.Position(typeName.Pos()).Filename)
+	default:
+		r.typeName = typeName
+		return r.Log
+	}
+	r.Log.AssociateNode(ident)
+	return r.Log
+}
+
+func isInterface(t types.Type) bool {
+	_, ok := t.Underlying().(*types.Interface)
+	return ok
+}
+
+func isPointer(t types.Type) bool {
+	_, ok := t.Underlying().(*types.Pointer)
+	return ok
+}
+
+// CheckFinalConditions determines whether the interface named in args can be
+// found in the loaded program.  CheckInitialConditions must have been invoked
+// first.
+func (r *ImplementInterface) CheckFinalConditions(config *Config, args []interface{}) *Log {
+	r.iface = nil
+	if !validateArgs(config, args, r.Description(), r.Log) {
+		return r.Log
+	}
+
+	iface, err := r.lookupInterface(args[0].(string))
+	if err != nil {
+		r.Log.Error(err)
+		return r.Log
+	}
+	r.iface = iface
+	return r.Log
+}
+
+// lookupInterface returns the interface with the given name.  The name may be
+// an identifier in the current package or the universe scope (e.g., error),
+// or it may be qualified by a package (e.g., io.Reader or
+// example.com/pkg.Iface).
+func (r *ImplementInterface) lookupInterface(name string) (*types.TypeName, error) {
+	var obj types.Object
+	if dot := strings.LastIndex(name, "."); dot < 0 {
+		obj = r.SelectedNodePkg.Pkg.Scope().Lookup(name)
+		if obj == nil {
+			obj = types.Universe.Lookup(name)
+		}
+	} else {
+		pkg, err := r.lookupPackage(name[:dot])
+		if err != nil {
+			return nil, err
+		}
+		obj = pkg.Scope().Lookup(name[dot+1:])
+		if obj != nil && !obj.Exported() && pkg != r.SelectedNodePkg.Pkg {
+			obj = nil
+		}
+	}
+
+	typeName, ok := obj.(*types.TypeName)
+	if !ok {
+		return nil, fmt.Errorf("No type named %s was found", name)
+	}
+	if !isInterface(typeName.Type()) {
+		return nil, fmt.Errorf("%s is not an interface", name)
+	}
+	return typeName, nil
+}
+
+// lookupPackage returns the loaded package with the given import path, the
+// package imported under the given name in the current file, or the (unique)
+// loaded package with the given name, in that order of preference.
+func (r *ImplementInterface) lookupPackage(qualifier string) (*types.Package, error) {
+	paths := map[string]*types.Package{}
+	for pkg := range r.Program.AllPackages {
+		paths[pkg.Path()] = pkg
+	}
+	if pkg, ok := paths[qualifier]; ok {
+		return pkg, nil
+	}
+	for path, name := range r.Imports().names {
+		if pkg, ok := paths[path]; ok && name == qualifier {
+			return pkg, nil
+		}
+	}
+
+	candidates := []string{}
+	for path, pkg := range paths {
+		if pkg.Name() == qualifier {
+			candidates = append(candidates, path)
+		}
+	}
+	sort.Strings(candidates)
+	switch len(candidates) {
+	case 0:
+		return nil, fmt.Errorf("No package %s was found in the program", qualifier)
+	case 1:
+		return paths[candidates[0]], nil
+	default:
+		return nil, fmt.Errorf("There are several packages named %s (%s); "+
+			"please use an import path to identify the interface",
+			qualifier, strings.Join(candidates, ", "))
+	}
+}
+
+// missingMethods returns the methods of the interface that are not in the
+// method set of the selected type (or a pointer to it).  An error is logged
+// if the type has a method or field with the same name as an interface
+// method but a different type, or if an interface method is unexported and
+// the interface is in a different package.
+func (r *ImplementInterface) missingMethods() []*types.Func {
+	ptr := types.NewPointer(r.typeName.Type())
+	iface := r.iface.Type().Underlying().(*types.Interface)
+	if m, _ := types.MissingMethod(ptr, iface, true); m == nil {
+		return nil
+	}
+
+	methods := types.NewMethodSet(ptr)
+	result := []*types.Func{}
+	for i := 0; i < iface.NumMethods(); i++ {
+		m := iface.Method(i)
+		if sel := methods.Lookup(m.Pkg(), m.Name()); sel != nil {
+			if !types.Identical(sel.Obj().Type(), m.Type()) {
+				r.Log.Errorf("%s already has a method %s with a "+
+					"different signature", r.typeName.Name(), m.Name())
+				r.Log.AssociatePos(sel.Obj().Pos(), sel.Obj().Pos())
+			}
+			continue
+		}
+		if obj, _, _ := types.LookupFieldOrMethod(ptr, true, m.Pkg(), m.Name()); obj != nil {
+			r.Log.Errorf("%s has a field named %s, so it cannot have "+
+				"a method with that name", r.typeName.Name(), m.Name())
+			r.Log.AssociatePos(obj.Pos(), obj.Pos())
+			continue
+		}
+		if !m.Exported() && m.Pkg() != r.SelectedNodePkg.Pkg {
+			r.Log.Errorf("%s cannot be implemented outside package %s, "+
+				"since its method %s is not exported",
+				r.iface.Name(), m.Pkg().Name(), m.Name())
+			continue
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+// addStubs adds a stub method for each of the given methods immediately after
+// the last method of the selected type that is declared in the current file,
+// or after the type's declaration if there are no such methods.
+func (r *ImplementInterface) addStubs(methods []*types.Func) {
+	rw := r.Rewriter()
+	anchor := r.anchor()
+	// Insertions at the same offset are applied in the reverse of the
+	// order in which they are added, so add the last stub first
+	for i := len(methods) - 1; i >= 0; i-- {
+		stub, err := r.stub(methods[i])
+		if err == nil {
+			err = rw.InsertAfter(anchor, stub)
+		}
+		if err != nil {
+			r.Log.Error(err)
+			return
+		}
+	}
+}
+
+// stub returns a declaration of a method of the selected type with the name
+// and signature of the given method and a body that panics.
+func (r *ImplementInterface) stub(m *types.Func) (*ast.FuncDecl, error) {
+	sig := m.Type().(*types.Signature)
+	funcType, err := parser.ParseExpr("func" + r.Imports().SignatureString(sig))
+	if err != nil {
+		return nil, err
+	}
+
+	var recvType ast.Expr = ast.NewIdent(r.typeName.Name())
+	if r.usePointerReceiver() {
+		recvType = &ast.StarExpr{X: recvType}
+	}
+	recv := &ast.Field{Type: recvType}
+	if name := r.receiverName(); name != "" && !hasParamNamed(sig, name) {
+		recv.Names = []*ast.Ident{ast.NewIdent(name)}
+	}
+
+	return &ast.FuncDecl{
+		Recv: &ast.FieldList{List: []*ast.Field{recv}},
+		Name: ast.NewIdent(m.Name()),
+		Type: funcType.(*ast.FuncType),
+		Body: &ast.BlockStmt{List: []ast.Stmt{
+			&ast.ExprStmt{X: &ast.CallExpr{
+				Fun: ast.NewIdent("panic"),
+				Args: []ast.Expr{&ast.BasicLit{
+					Kind:  token.STRING,
+					Value: strconv.Quote("not implemented"),
+				}},
+			}},
+		}},
+	}, nil
+}
+
+// anchor returns the declaration after which stubs will be inserted: the last
+// method declaration for the selected type in the current file, or the
+// declaration of the type itself.
+func (r *ImplementInterface) anchor() ast.Decl {
+	var result ast.Decl
+	for _, decl := range r.File.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			if decl.Tok != token.TYPE || result != nil {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				if spec.(*ast.TypeSpec).Name.Pos() == r.typeName.Pos() {
+					result = decl
+				}
+			}
+		case *ast.FuncDecl:
+			if fn, ok := r.SelectedNodePkg.Defs[decl.Name].(*types.Func); ok &&
+				decl.Recv != nil && r.isMethodOfType(fn) {
+				result = decl
+			}
+		}
+	}
+	return result
+}
+
+// isMethodOfType returns true iff the given function is a method whose
+// receiver is the selected type or a pointer to it.
+func (r *ImplementInterface) isMethodOfType(fn *types.Func) bool {
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return false
+	}
+	t := recv.Type()
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	return t == r.typeName.Type()
+}
+
+// usePointerReceiver determines whether stubs should have pointer receivers.
+// If any of the type's existing methods have pointer receivers, the stubs do
+// as well; if the type only has methods with value receivers, the stubs do
+// too.  If the type has no methods, pointer receivers are used for struct
+// types and value receivers for all other types.
+func (r *ImplementInterface) usePointerReceiver() bool {
+	named := r.typeName.Type().(*types.Named)
+	if named.NumMethods() == 0 {
+		_, isStruct := named.Underlying().(*types.Struct)
+		return isStruct
+	}
+	for i := 0; i < named.NumMethods(); i++ {
+		recv := named.Method(i).Type().(*types.Signature).Recv()
+		if _, ok := recv.Type().(*types.Pointer); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// receiverName returns the name of the receiver used most often in the
+// type's existing methods (preferring the earliest declared in case of a
+// tie), or the type's initial letter, in lowercase, if it has no methods.
+func (r *ImplementInterface) receiverName() string {
+	named := r.typeName.Type().(*types.Named)
+	if named.NumMethods() == 0 {
+		first, _ := utf8.DecodeRuneInString(r.typeName.Name())
+		return string(unicode.ToLower(first))
+	}
+	counts := map[string]int{}
+	result := ""
+	for i := 0; i < named.NumMethods(); i++ {
+		name := named.Method(i).Type().(*types.Signature).Recv().Name()
+		counts[name]++
+		if i == 0 || counts[name] > counts[result] {
+			result = name
+		}
+	}
+	if result == "_" {
+		return ""
+	}
+	return result
+}
+
+// hasParamNamed returns true iff one of the parameters or results of the
+// given signature has the given name.
+func hasParamNamed(sig *types.Signature, name string) bool {
+	for _, tuple := range []*types.Tuple{sig.Params(), sig.Results()} {
+		for i := 0; i < tuple.Len(); i++ {
+			if tuple.At(i).Name() == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+const implementInterfaceDoc = `
+  <h4>Purpose</h4>
+  <p>The Implement Interface refactoring adds stub methods to a named type so
+  that it implements an interface.  A method is added for each method of the
+  interface that the type does not already have.  The body of each stub
+  panics; it should be replaced with a real implementation.</p>
+  <p>If any of the type's existing methods have pointer receivers, the stubs
+  will have pointer receivers as well.  If the type has only value receivers,
+  the stubs will have value receivers.  A type with no methods is given
+  pointer receivers if it is a struct type and value receivers otherwise.  The
+  receiver is named the same as in the type's existing methods.  Any imports
+  needed by the method signatures are added to the file.</p>
+
+  <h4>Usage</h4>
+  <ol>
+    <li>Select the name of a type declared in the current file.</li>
+    <li>Activate the Implement Interface refactoring.</li>
+    <li>Enter the name of the interface to implement.  This may be the name
+    of an interface in the current package (e.g., <tt>Shape</tt>), a
+    predeclared interface (<tt>error</tt>), or an interface in another
+    package, qualified by the package name (e.g., <tt>io.Reader</tt>) or by
+    its import path (e.g., <tt>example.com/shapes.Shape</tt>).  The
+    interface must be declared in a package that is part of the loaded
+    program.</li>
+  </ol>
+
+  <p>An error will be reported if the type has a field or a method with the
+  same name as one of the interface's methods but a different signature, or
+  if the interface has unexported methods and is declared in a different
+  package.</p>
+
+  <h4>Example</h4>
+  <p>The example below demonstrates the effect of implementing
+  <tt>io.ReadCloser</tt> on the type <tt>File</tt>, which already has a
+  <tt>Close</tt> method.</p>
+  <table cellspacing="5" cellpadding="15" style="border: 0;">
+    <tr>
+      <th>Before</th><th>&nbsp;</th><th>After</th>
+    </tr>
+    <tr>
+      <td class="dotted">
+        <pre>package main
+
+type <span class="highlight">File</span> struct{ name string }
+
+func (f *File) Close() error {
+    return nil
+}</pre>
+      </td>
+      <td>&nbsp;&nbsp;&nbsp;&nbsp;&rArr;&nbsp;&nbsp;&nbsp;&nbsp;</td>
+      <td class="dotted">
+        <pre>package main
+
+type File struct{ name string }
+
+func (f *File) Close() error {
+    return nil
+}
+
+func (f *File) Read(p []byte) (n int, err error) {
+    panic("not implemented")
+}</pre>
+      </td>
+    </tr>
+  </table>
+`
@@ -0,0 +1,479 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file defines FileImports, which prints types relative to a file and
+// updates the file's imports so that the printed types can be used there.
+
+package refactoring
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/godoctor/godoctor/internal/golang.org/x/tools/go/loader"
+	"github.com/godoctor/godoctor/internal/golang.org/x/tools/go/types"
+	"github.com/godoctor/godoctor/text"
+)
+
+// FileImports prints types as they should appear in a particular file: types
+// from the file's own package are unqualified, and types from other packages
+// are qualified by the name under which that package is imported (including
+// any alias, or no qualifier at all for a dot import).  If a type refers to a
+// package that the file does not import, an import is recorded so that it
+// can be added to the file by Update; a new import is given an alias if its
+// package name would conflict with another name in the file.
+type FileImports struct {
+	pkg   *types.Package            // The package containing the file
+	info  *types.Info               // Type information for the package
+	file  *ast.File                 // The file, before it was refactored
+	names map[string]string         // Import path -> name used in the file
+	added map[string]string         // Import path -> name, for imports to add
+	pkgs  map[string]*types.Package // Import path -> package, for imports to add
+}
+
+// NewFileImports returns a FileImports for the given file, which is one of
+// the files in the given (type-checked) package.
+func NewFileImports(pkgInfo *loader.PackageInfo, file *ast.File) *FileImports {
+	fi := &FileImports{
+		pkg:   pkgInfo.Pkg,
+		info:  &pkgInfo.Info,
+		file:  file,
+		names: map[string]string{},
+		added: map[string]string{},
+		pkgs:  map[string]*types.Package{},
+	}
+	for _, spec := range file.Imports {
+		path := importSpecPath(spec)
+		if spec.Name == nil {
+			fi.names[path] = fi.packageName(path)
+		} else if spec.Name.Name != "_" {
+			fi.names[path] = spec.Name.Name
+		}
+	}
+	return fi
+}
+
+// importSpecPath returns the (unquoted) import path in an import spec.
+func importSpecPath(spec *ast.ImportSpec) string {
+	path, err := strconv.Unquote(spec.Path.Value)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// packageName returns the name of the package with the given import path,
+// if it is imported by the file's package, or the last element of the import
+// path otherwise.
+func (fi *FileImports) packageName(importPath string) string {
+	for _, imp := range fi.pkg.Imports() {
+		if imp.Path() == importPath {
+			return imp.Name()
+		}
+	}
+	return path.Base(importPath)
+}
+
+// Qualifier returns the name that should be used to qualify references to
+// the given package in the file, or the empty string if no qualifier is
+// needed (i.e., pkg is the file's package or is dot-imported).  If the file
+// does not import pkg, an import is recorded.
+func (fi *FileImports) Qualifier(pkg *types.Package) string {
+	if pkg == nil || pkg == fi.pkg || pkg.Path() == fi.pkg.Path() {
+		return ""
+	}
+	name, ok := fi.names[pkg.Path()]
+	if !ok {
+		name, ok = fi.added[pkg.Path()]
+	}
+	if !ok {
+		name = pkg.Name()
+		for i := 2; fi.isNameInUse(name); i++ {
+			name = fmt.Sprintf("%s%d", pkg.Name(), i)
+		}
+		fi.added[pkg.Path()] = name
+		fi.pkgs[pkg.Path()] = pkg
+	}
+	if name == "." {
+		return ""
+	}
+	return name
+}
+
+// isNameInUse returns true iff the given name is an import name or a
+// package-level name in the file's package.
+func (fi *FileImports) isNameInUse(name string) bool {
+	for _, names := range []map[string]string{fi.names, fi.added} {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return fi.pkg.Scope().Lookup(name) != nil
+}
+
+// TypeString returns the source code for the given type, as it should be
+// written in the file.
+func (fi *FileImports) TypeString(t types.Type) string {
+	var buf bytes.Buffer
+	fi.writeType(&buf, t)
+	return buf.String()
+}
+
+// SignatureString returns the source code for the parameters and results of
+// the given signature (i.e., the signature without the func keyword), as it
+// should be written in a function or method declaration in the file.
+func (fi *FileImports) SignatureString(sig *types.Signature) string {
+	var buf bytes.Buffer
+	fi.writeSignature(&buf, sig)
+	return buf.String()
+}
+
+func (fi *FileImports) writeType(buf *bytes.Buffer, typ types.Type) {
+	switch t := typ.(type) {
+	case *types.Basic:
+		if t.Kind() == types.UnsafePointer {
+			buf.WriteString(fi.Qualifier(types.Unsafe))
+			buf.WriteString(".Pointer")
+		} else {
+			buf.WriteString(t.Name())
+		}
+
+	case *types.Array:
+		fmt.Fprintf(buf, "[%d]", t.Len())
+		fi.writeType(buf, t.Elem())
+
+	case *types.Slice:
+		buf.WriteString("[]")
+		fi.writeType(buf, t.Elem())
+
+	case *types.Struct:
+		buf.WriteString("struct{")
+		for i := 0; i < t.NumFields(); i++ {
+			if i > 0 {
+				buf.WriteString("; ")
+			}
+			f := t.Field(i)
+			if !f.Anonymous() {
+				buf.WriteString(f.Name())
+				buf.WriteByte(' ')
+			}
+			fi.writeType(buf, f.Type())
+			if tag := t.Tag(i); tag != "" {
+				buf.WriteByte(' ')
+				buf.WriteString(strconv.Quote(tag))
+			}
+		}
+		buf.WriteByte('}')
+
+	case *types.Pointer:
+		buf.WriteByte('*')
+		fi.writeType(buf, t.Elem())
+
+	case *types.Tuple:
+		fi.writeTuple(buf, t, false)
+
+	case *types.Signature:
+		buf.WriteString("func")
+		fi.writeSignature(buf, t)
+
+	case *types.Interface:
+		buf.WriteString("interface{")
+		for i := 0; i < t.NumExplicitMethods(); i++ {
+			if i > 0 {
+				buf.WriteString("; ")
+			}
+			m := t.ExplicitMethod(i)
+			buf.WriteString(m.Name())
+			fi.writeSignature(buf, m.Type().(*types.Signature))
+		}
+		for i := 0; i < t.NumEmbeddeds(); i++ {
+			if i > 0 || t.NumExplicitMethods() > 0 {
+				buf.WriteString("; ")
+			}
+			fi.writeType(buf, t.Embedded(i))
+		}
+		buf.WriteByte('}')
+
+	case *types.Map:
+		buf.WriteString("map[")
+		fi.writeType(buf, t.Key())
+		buf.WriteByte(']')
+		fi.writeType(buf, t.Elem())
+
+	case *types.Chan:
+		parens := false
+		switch t.Dir() {
+		case types.SendRecv:
+			buf.WriteString("chan ")
+			// chan (<-chan T) requires parentheses
+			if c, ok := t.Elem().(*types.Chan); ok && c.Dir() == types.RecvOnly {
+				parens = true
+			}
+		case types.SendOnly:
+			buf.WriteString("chan<- ")
+		case types.RecvOnly:
+			buf.WriteString("<-chan ")
+		}
+		if parens {
+			buf.WriteByte('(')
+		}
+		fi.writeType(buf, t.Elem())
+		if parens {
+			buf.WriteByte(')')
+		}
+
+	case *types.Named:
+		if q := fi.Qualifier(t.Obj().Pkg()); q != "" {
+			buf.WriteString(q)
+			buf.WriteByte('.')
+		}
+		buf.WriteString(t.Obj().Name())
+
+	default:
+		buf.WriteString(t.String())
+	}
+}
+
+func (fi *FileImports) writeTuple(buf *bytes.Buffer, tup *types.Tuple, variadic bool) {
+	buf.WriteByte('(')
+	for i := 0; tup != nil && i < tup.Len(); i++ {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		v := tup.At(i)
+		if v.Name() != "" {
+			buf.WriteString(v.Name())
+			buf.WriteByte(' ')
+		}
+		typ := v.Type()
+		if variadic && i == tup.Len()-1 {
+			if s, ok := typ.(*types.Slice); ok {
+				buf.WriteString("...")
+				typ = s.Elem()
+			}
+		}
+		fi.writeType(buf, typ)
+	}
+	buf.WriteByte(')')
+}
+
+func (fi *FileImports) writeSignature(buf *bytes.Buffer, sig *types.Signature) {
+	fi.writeTuple(buf, sig.Params(), sig.Variadic())
+	results := sig.Results()
+	if results.Len() == 0 {
+		return
+	}
+	buf.WriteByte(' ')
+	if results.Len() == 1 && results.At(0).Name() == "" {
+		fi.writeType(buf, results.At(0).Type())
+		return
+	}
+	fi.writeTuple(buf, results, false)
+}
+
+// Update returns an EditSet that updates the imports in the given contents,
+// which are the contents of the file after it was refactored.  It adds the
+// imports recorded by Qualifier (unless the refactored file already contains
+// them), and it removes imports that were used in the original file but are
+// no longer used.  Blank and dot imports are never removed.
+//
+// Uses of imports are determined from type information: the original file's
+// is taken from its package, and the refactored file is type checked by
+// itself (ignoring errors, since it may refer to declarations in other files
+// of the package).
+func (fi *FileImports) Update(contents string) (*text.EditSet, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", contents, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	edits := text.NewEditSet()
+	rw := NewRewriter(fset, file, []byte(contents), edits)
+
+	// Remove unused imports
+	usedBefore := usedImports(fi.file, fi.info)
+	usedAfter := usedImports(file, fi.check(fset, file))
+	removed := map[*ast.ImportSpec]bool{}
+	for _, spec := range file.Imports {
+		if spec.Name != nil && (spec.Name.Name == "_" || spec.Name.Name == ".") {
+			continue
+		}
+		path := importSpecPath(spec)
+		if usedBefore[path] && !usedAfter[path] {
+			removed[spec] = true
+		}
+	}
+	var target *ast.GenDecl // Import declaration to add imports to
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ast.GenDecl)
+		if !ok || decl.Tok != token.IMPORT {
+			continue
+		}
+		remaining := 0
+		for _, spec := range decl.Specs {
+			if !removed[spec.(*ast.ImportSpec)] {
+				remaining++
+			}
+		}
+		if remaining == 0 {
+			err = rw.Delete(decl)
+		} else {
+			for _, spec := range decl.Specs {
+				if removed[spec.(*ast.ImportSpec)] && err == nil {
+					err = rw.Delete(spec)
+				}
+			}
+			if target == nil {
+				target = decl
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Add new imports
+	paths := []string{}
+	for path := range fi.added {
+		if !astImports(file, path) {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return edits, nil
+	}
+	sort.Strings(paths)
+	specs := []ast.Spec{}
+	for _, path := range paths {
+		spec := &ast.ImportSpec{Path: &ast.BasicLit{
+			Kind:  token.STRING,
+			Value: strconv.Quote(path),
+		}}
+		if name := fi.added[path]; name != fi.packageName(path) {
+			spec.Name = ast.NewIdent(name)
+		}
+		specs = append(specs, spec)
+	}
+	return edits, fi.addSpecs(rw, file, target, specs, removed)
+}
+
+// addSpecs adds the given import specs (sorted by path) to the target import
+// declaration, or to a new import declaration if target is nil.  Removed
+// specs are not used as anchors for the new specs.
+func (fi *FileImports) addSpecs(rw *Rewriter, file *ast.File, target *ast.GenDecl, specs []ast.Spec, removed map[*ast.ImportSpec]bool) error {
+	if target == nil {
+		return rw.InsertAfter(file.Name,
+			&ast.GenDecl{Tok: token.IMPORT, Specs: specs})
+	}
+	if !target.Lparen.IsValid() {
+		// Replace import "x" with import ( "x"; ... )
+		all := append([]ast.Spec{target.Specs[0]}, specs...)
+		sort.Sort(bySpecPath(all))
+		return rw.Replace(target, &ast.GenDecl{
+			Tok:    token.IMPORT,
+			Lparen: target.Pos(),
+			Specs:  all,
+		})
+	}
+	remaining := []*ast.ImportSpec{}
+	for _, spec := range target.Specs {
+		if !removed[spec.(*ast.ImportSpec)] {
+			remaining = append(remaining, spec.(*ast.ImportSpec))
+		}
+	}
+	// Insertions at the same offset are applied in the reverse of the
+	// order in which they are added, so add the last spec first
+	for i := len(specs) - 1; i >= 0; i-- {
+		path := importSpecPath(specs[i].(*ast.ImportSpec))
+		var err error
+		if j := sort.Search(len(remaining), func(j int) bool {
+			return importSpecPath(remaining[j]) > path
+		}); j < len(remaining) {
+			err = rw.InsertBefore(remaining[j], specs[i])
+		} else {
+			err = rw.InsertAfter(remaining[len(remaining)-1], specs[i])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type bySpecPath []ast.Spec
+
+func (s bySpecPath) Len() int      { return len(s) }
+func (s bySpecPath) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s bySpecPath) Less(i, j int) bool {
+	return importSpecPath(s[i].(*ast.ImportSpec)) <
+		importSpecPath(s[j].(*ast.ImportSpec))
+}
+
+// astImports returns true iff the given file has a (non-blank) import of the
+// given path.
+func astImports(file *ast.File, path string) bool {
+	for _, spec := range file.Imports {
+		if importSpecPath(spec) == path &&
+			(spec.Name == nil || spec.Name.Name != "_") {
+			return true
+		}
+	}
+	return false
+}
+
+// check type checks the given (refactored) file by itself and returns the
+// resulting type information.  Imported packages are resolved to the packages
+// imported by the file's package or recorded by Qualifier; any other import is
+// resolved to an empty package, which still allows uses of its name to be
+// identified.
+func (fi *FileImports) check(fset *token.FileSet, file *ast.File) *types.Info {
+	info := &types.Info{Uses: map[*ast.Ident]types.Object{}}
+	config := &types.Config{
+		Error: func(error) {},
+		Import: func(_ map[string]*types.Package, importPath string) (*types.Package, error) {
+			return fi.importedPackage(importPath), nil
+		},
+	}
+	config.Check(fi.pkg.Path(), fset, []*ast.File{file}, info)
+	return info
+}
+
+// importedPackage returns the package with the given import path, for use
+// when type checking a refactored file.
+func (fi *FileImports) importedPackage(importPath string) *types.Package {
+	if importPath == "unsafe" {
+		return types.Unsafe
+	}
+	for _, imp := range fi.pkg.Imports() {
+		if imp.Path() == importPath {
+			return imp
+		}
+	}
+	if pkg, ok := fi.pkgs[importPath]; ok {
+		return pkg
+	}
+	return types.NewPackage(importPath, path.Base(importPath))
+}
+
+// usedImports returns the set of import paths of the packages whose names
+// are used (as qualifiers) in the given file, according to the given type
+// information.
+func usedImports(file *ast.File, info *types.Info) map[string]bool {
+	result := map[string]bool{}
+	for id, obj := range info.Uses {
+		if pkgName, ok := obj.(*types.PkgName); ok &&
+			file.Pos() <= id.Pos() && id.Pos() < file.End() {
+			result[pkgName.Imported().Path()] = true
+		}
+	}
+	return result
+}
@@ -0,0 +1,125 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package refactoring_test
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/godoctor/godoctor/internal/golang.org/x/tools/go/loader"
+	"github.com/godoctor/godoctor/internal/golang.org/x/tools/go/types"
+	"github.com/godoctor/godoctor/refactoring"
+	"github.com/godoctor/godoctor/text"
+)
+
+func namedType(pkg *types.Package, name string) *types.Named {
+	obj := types.NewTypeName(token.NoPos, pkg, name, nil)
+	return types.NewNamed(obj, types.Typ[types.Int], nil)
+}
+
+func TestFileImports(t *testing.T) {
+	src := `package p
+
+import (
+	"fmt"
+	. "example.com/dot"
+	u "example.com/util"
+)
+
+var _ = fmt.Sprint
+var _ = u.X
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "p.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dot := types.NewPackage("example.com/dot", "dot")
+	util := types.NewPackage("example.com/util", "util")
+	geo := types.NewPackage("example.com/geo", "geo")
+	geo2 := types.NewPackage("example.com/other/geo", "geo")
+	fmtPkg := types.NewPackage("fmt", "fmt")
+	imports := []*types.Package{fmtPkg, dot, util}
+
+	// Type check the file against the (empty) imported packages; the
+	// qualifiers are identified despite the resulting errors
+	pkgInfo := &loader.PackageInfo{
+		Files: []*ast.File{file},
+		Info:  types.Info{Uses: map[*ast.Ident]types.Object{}},
+	}
+	config := &types.Config{
+		Error: func(error) {},
+		Import: func(_ map[string]*types.Package, path string) (*types.Package, error) {
+			for _, imp := range imports {
+				if imp.Path() == path {
+					return imp, nil
+				}
+			}
+			return nil, fmt.Errorf("no package %s", path)
+		},
+	}
+	pkg, _ := config.Check("example.com/p", fset, pkgInfo.Files, &pkgInfo.Info)
+	pkg.Scope().Insert(namedType(pkg, "geo").Obj())
+	pkgInfo.Pkg = pkg
+
+	fi := refactoring.NewFileImports(pkgInfo, file)
+	tests := []struct {
+		typ      types.Type
+		expected string
+	}{
+		{namedType(pkg, "Local"), "Local"},
+		{namedType(dot, "D"), "D"},
+		{types.NewPointer(namedType(util, "U")), "*u.U"},
+		{types.NewMap(types.Typ[types.String], namedType(geo, "Point")),
+			"map[string]geo2.Point"},
+		{types.NewSlice(namedType(geo2, "Point")), "[]geo3.Point"},
+		{types.NewChan(types.RecvOnly, namedType(geo, "Point")),
+			"<-chan geo2.Point"},
+		{types.Typ[types.UnsafePointer], "unsafe.Pointer"},
+	}
+	for _, test := range tests {
+		if actual := fi.TypeString(test.typ); actual != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, actual)
+		}
+	}
+
+	// Remove fmt, which is no longer used, and add the new imports
+	contents := `package p
+
+import (
+	"fmt"
+	. "example.com/dot"
+	u "example.com/util"
+)
+
+var _ = u.X
+`
+	edits, err := fi.Update(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := text.ApplyToString(edits, contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `package p
+
+import (
+	. "example.com/dot"
+	geo2 "example.com/geo"
+	geo3 "example.com/other/geo"
+	u "example.com/util"
+	"unsafe"
+)
+
+var _ = u.X
+`
+	if result != expected {
+		t.Fatalf("Expected:\n%s\nActual:\n%s", expected, result)
+	}
+}
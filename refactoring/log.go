@@ -26,6 +26,7 @@ import (
 	"go/token"
 
 	"github.com/godoctor/godoctor/filesystem"
+	"github.com/godoctor/godoctor/text"
 )
 
 // A Severity indicates whether a log entry describes an informational message,
@@ -40,16 +41,59 @@ const (
 
 // A Entry constitutes a single entry in a Log.  Every Entry has a
 // severity and a message.  If the filename is a nonempty string, the Entry
-// is associated with a particular position in the given file.  Some log
-// entries are marked as "initial."  These indicate semantic errors that were
-// present in the input file (e.g., unresolved identifiers, unnecessary
-// imports, etc.) before the refactoring was started.
+// is associated with a particular position in the given file.
+//
+// An Entry's position is stored in two forms.  Pos and End are positions in
+// the Log's FileSet, and they are valid only if that FileSet contains the
+// file.  Filename, Offset, and Length describe the same region independently
+// of any FileSet, so they remain meaningful when the log is remapped to a
+// different FileSet (see Remap), merged with another log, or serialized.
+// Some log entries are marked as "initial."  These indicate semantic errors
+// that were present in the input file (e.g., unresolved identifiers,
+// unnecessary imports, etc.) before the refactoring was started.
 type Entry struct {
 	isInitial bool
 	Severity  Severity
 	Message   string
 	Pos       token.Pos
 	End       token.Pos
+	// The file containing this entry's position ("" if it has none), and
+	// the byte offset and length of the region within that file
+	Filename string
+	Offset   int
+	Length   int
+	// A stable identifier for the condition that caused this entry, of
+	// the form refactoring/condition (e.g., "rename/conflict"), or "" if
+	// the entry has no code.  Unlike messages, codes do not change, so
+	// they may be used to filter entries or look up documentation.
+	Code string
+	// Secondary locations relevant to this entry (e.g., a declaration
+	// that conflicts with the entity being refactored)
+	Related []*RelatedLocation
+	// Changes that the user could make to resolve the problem described
+	// by this entry
+	Fixes []*SuggestedFix
+}
+
+// A RelatedLocation is a secondary location associated with an Entry, along
+// with a message describing its relevance (e.g., "conflicting declaration").
+type RelatedLocation struct {
+	Message string
+	Pos     token.Pos
+	End     token.Pos
+	// The same region as a filename, byte offset, and length (see Entry)
+	Filename string
+	Offset   int
+	Length   int
+}
+
+// A SuggestedFix is a change that the user could make to resolve the problem
+// described by an Entry.  The Edits map filenames to the text edits that
+// should be applied to those files; like a Result's Edits, they are relative
+// to the original (unrefactored) files.
+type SuggestedFix struct {
+	Description string
+	Edits       map[string]*text.EditSet
 }
 
 func (entry *Entry) String() string {
@@ -74,6 +118,9 @@ type Log struct {
 	// Informational messages, warnings, and errors, in the (temporal)
 	// order they were added to the log
 	Entries []*Entry
+	// The edits that were applied to the files the entries refer to (see
+	// Remap), or nil if the entries refer to the original files
+	edits map[string]*text.EditSet
 }
 
 // NewLog creates an empty Log.  The Log will be unable to associate errors
@@ -155,6 +202,20 @@ func (log *Log) AssociatePos(start, end token.Pos) {
 	entry := log.Entries[len(log.Entries)-1]
 	entry.Pos = start
 	entry.End = end
+	entry.Filename, entry.Offset, entry.Length = log.locate(start, end)
+}
+
+// AssociateOffset associates the most recently-logged entry with the region
+// of the given file starting at the given byte offset.  The entry's Pos and
+// End are set only if the file is in the log's FileSet, but the filename,
+// offset, and length are retained either way.
+func (log *Log) AssociateOffset(filename string, offset, length int) {
+	if len(log.Entries) == 0 {
+		return
+	}
+	entry := log.Entries[len(log.Entries)-1]
+	entry.Filename, entry.Offset, entry.Length = filename, offset, length
+	entry.Pos, entry.End = resolvePos(filename, offset, length, log.files())
 }
 
 // AssociateNode associates the most recently-logged entry with the region of
@@ -163,6 +224,183 @@ func (log *Log) AssociateNode(node ast.Node) {
 	log.AssociatePos(node.Pos(), node.End())
 }
 
+// AssociateCode sets the Code of the most recently-logged entry.
+func (log *Log) AssociateCode(code string) {
+	if len(log.Entries) == 0 {
+		return
+	}
+	log.Entries[len(log.Entries)-1].Code = code
+}
+
+// AddRelated adds a related location, with the given message, to the most
+// recently-logged entry.
+func (log *Log) AddRelated(start, end token.Pos, message string) {
+	if len(log.Entries) == 0 {
+		return
+	}
+	entry := log.Entries[len(log.Entries)-1]
+	related := &RelatedLocation{
+		Message: message,
+		Pos:     start,
+		End:     end}
+	related.Filename, related.Offset, related.Length = log.locate(start, end)
+	entry.Related = append(entry.Related, related)
+}
+
+// AddRelatedNode adds the region of source code corresponding to the given
+// AST node as a related location of the most recently-logged entry.
+func (log *Log) AddRelatedNode(node ast.Node, message string) {
+	log.AddRelated(node.Pos(), node.End(), message)
+}
+
+// AddFix adds a suggested fix to the most recently-logged entry.
+func (log *Log) AddFix(description string, edits map[string]*text.EditSet) {
+	if len(log.Entries) == 0 {
+		return
+	}
+	entry := log.Entries[len(log.Entries)-1]
+	entry.Fixes = append(entry.Fixes, &SuggestedFix{
+		Description: description,
+		Edits:       edits})
+}
+
+// Remap changes the FileSet of this log to fset.  The log's entries are
+// assumed to refer to files before the given edits were applied (or, if
+// reverse is true, after they were applied); each entry's offset and length
+// are translated to the corresponding region after the edits are applied (or
+// before, if reverse is true), and Pos and End are recomputed in the new
+// FileSet.  Entries in files that are not in the new FileSet retain their
+// filename, offset, and length, but their Pos and End become token.NoPos.
+func (log *Log) Remap(edits map[string]*text.EditSet, fset *token.FileSet, reverse bool) {
+	log.locateAll()
+	log.Fset = fset
+	if reverse {
+		log.edits = nil
+	} else {
+		// The caller may add edits later (e.g., when build configurations
+		// are merged), but the entries refer to these edits only
+		log.edits = copyEdits(edits)
+	}
+	files := log.files()
+	for _, entry := range log.Entries {
+		if entry.Filename != "" {
+			entry.Offset, entry.Length = mapOffsets(edits[entry.Filename], entry.Offset, entry.Length, reverse)
+			entry.Pos, entry.End = resolvePos(entry.Filename, entry.Offset, entry.Length, files)
+		}
+		for _, related := range entry.Related {
+			if related.Filename != "" {
+				related.Offset, related.Length = mapOffsets(edits[related.Filename], related.Offset, related.Length, reverse)
+				related.Pos, related.End = resolvePos(related.Filename, related.Offset, related.Length, files)
+			}
+		}
+	}
+}
+
+// copyEdits returns a copy of the given map, containing copies of its EditSets.
+func copyEdits(edits map[string]*text.EditSet) map[string]*text.EditSet {
+	result := make(map[string]*text.EditSet, len(edits))
+	for filename, es := range edits {
+		result[filename] = es.Clone()
+	}
+	return result
+}
+
+// OriginalOffset returns the offset and length of the region in the original
+// file (i.e., before the refactoring's edits were applied) that corresponds
+// to the given region of a file referred to by this log's entries.  Once a
+// log has been remapped to the refactored program (see Remap), its entries'
+// offsets refer to the refactored files, so they must be mapped back before
+// they are compared with the original files' contents.
+func (log *Log) OriginalOffset(filename string, offset, length int) (int, int) {
+	return mapOffsets(log.edits[filename], offset, length, true)
+}
+
+// EntryFileSystem returns a FileSystem containing the files that this log's
+// entries refer to, given a FileSystem containing the original files.  If the
+// log has been remapped to the refactored program (see Remap), this is the
+// given FileSystem with the refactoring's edits applied; otherwise, it is the
+// given FileSystem itself.
+func (log *Log) EntryFileSystem(fs filesystem.FileSystem) filesystem.FileSystem {
+	if log.edits == nil {
+		return fs
+	}
+	return filesystem.NewEditedFileSystem(fs, log.edits)
+}
+
+// mapOffsets translates the region with the given offset and length to the
+// corresponding region after the given edits are applied (or before they
+// were applied, if reverse is true).  The EditSet may be nil.
+func mapOffsets(es *text.EditSet, offset, length int, reverse bool) (int, int) {
+	if es == nil {
+		return offset, length
+	}
+	end := offset + length
+	if reverse {
+		offset, end = es.OldOffset(offset), es.OldOffset(end)
+	} else {
+		offset, end = es.NewOffset(offset), es.NewOffset(end)
+	}
+	if end < offset {
+		end = offset
+	}
+	return offset, end - offset
+}
+
+// locate returns the filename, offset, and length of the region between the
+// given positions in this log's FileSet, or "", 0, 0 if they are not valid.
+func (log *Log) locate(start, end token.Pos) (string, int, int) {
+	if log.Fset == nil || !start.IsValid() {
+		return "", 0, 0
+	}
+	file := log.Fset.File(start)
+	if file == nil {
+		return "", 0, 0
+	}
+	offset := file.Offset(start)
+	length := 0
+	if end.IsValid() && end > start && int(end) <= file.Base()+file.Size() {
+		length = file.Offset(end) - offset
+	}
+	return file.Name(), offset, length
+}
+
+// locateAll sets the filename, offset, and length of any entries whose
+// positions were associated before the log's FileSet was set.
+func (log *Log) locateAll() {
+	for _, entry := range log.Entries {
+		if entry.Filename == "" {
+			entry.Filename, entry.Offset, entry.Length = log.locate(entry.Pos, entry.End)
+		}
+		for _, related := range entry.Related {
+			if related.Filename == "" {
+				related.Filename, related.Offset, related.Length = log.locate(related.Pos, related.End)
+			}
+		}
+	}
+}
+
+// files returns the files in this log's FileSet, keyed by filename.
+func (log *Log) files() map[string]*token.File {
+	files := map[string]*token.File{}
+	if log.Fset != nil {
+		log.Fset.Iterate(func(f *token.File) bool {
+			files[f.Name()] = f
+			return true
+		})
+	}
+	return files
+}
+
+// resolvePos returns the positions of the given region in the given files, or
+// token.NoPos if the region is not in one of those files.
+func resolvePos(filename string, offset, length int, files map[string]*token.File) (token.Pos, token.Pos) {
+	file, ok := files[filename]
+	if !ok || offset < 0 || length < 0 || offset+length > file.Size() {
+		return token.NoPos, token.NoPos
+	}
+	return file.Pos(offset), file.Pos(offset + length)
+}
+
 // MarkInitial marks all entries that have been logged so far as initial
 // entries.  Subsequent entries will not be marked as initial unless this
 // method is called again at a later point in time.
@@ -180,17 +418,74 @@ func (log *Log) String() string {
 
 // Write outputs this log in a GNU-style 'file:line:col: message' format.
 // Filenames are displayed relative to the given directory, if possible.
+// Entries in files that are not in the log's FileSet are displayed in a
+// 'file:#offset: message' format instead.  An entry's code, if any, is
+// displayed in brackets after its message; related locations are displayed
+// as "note" lines following the entry, and suggested fixes are listed by
+// description.  Columns are counted in bytes.
 func (log *Log) Write(out io.Writer, cwd string) {
+	log.WriteColumns(out, cwd, text.ByteColumns, nil)
+}
+
+// WriteColumns is like Write, but column numbers are counted in the given
+// mode (e.g., in UTF-16 code units, as many editors expect).  The original
+// files' contents are read from the given FileSystem to determine column
+// numbers (see EntryFileSystem); if a file cannot be read, its columns are
+// counted in bytes.
+func (log *Log) WriteColumns(out io.Writer, cwd string, columns text.ColumnMode, fs filesystem.FileSystem) {
+	c := &columnCounter{mode: columns}
+	if columns != text.ByteColumns && fs != nil {
+		c.contents = filesystem.NewContentCache(log.EntryFileSystem(fs))
+	}
 	for _, entry := range log.Entries {
-		if log.Fset != nil && entry.Pos.IsValid() {
-			pos := log.Fset.Position(entry.Pos)
-			fmt.Fprintf(out, "%s:%d:%d: ",
-				displayablePath(pos.Filename, cwd),
-				pos.Line,
-				pos.Column)
+		log.writePos(out, entry.Pos, entry.Filename, entry.Offset, cwd, c)
+		if entry.Code != "" {
+			fmt.Fprintf(out, "%s [%s]\n", entry.String(), entry.Code)
+		} else {
+			fmt.Fprintf(out, "%s\n", entry.String())
+		}
+		for _, related := range entry.Related {
+			log.writePos(out, related.Pos, related.Filename, related.Offset, cwd, c)
+			fmt.Fprintf(out, "note: %s\n", related.Message)
 		}
-		fmt.Fprintf(out, "%s\n", entry.String())
+		for _, fix := range entry.Fixes {
+			fmt.Fprintf(out, "    Suggested fix: %s\n", fix.Description)
+		}
+	}
+}
+
+// writePos outputs the 'file:line:col: ' prefix for the given position, if
+// it is valid, or a 'file:#offset: ' prefix if only the filename and offset
+// are known.
+func (log *Log) writePos(out io.Writer, p token.Pos, filename string, offset int, cwd string, c *columnCounter) {
+	if log.Fset != nil && p.IsValid() {
+		pos := log.Fset.Position(p)
+		fmt.Fprintf(out, "%s:%d:%d: ",
+			displayablePath(pos.Filename, cwd),
+			pos.Line,
+			c.column(pos))
+	} else if filename != "" {
+		fmt.Fprintf(out, "%s:#%d: ", displayablePath(filename, cwd), offset)
+	}
+}
+
+// A columnCounter determines the column number of a position in a given
+// ColumnMode, reading files' contents from a ContentCache as needed.
+type columnCounter struct {
+	mode     text.ColumnMode
+	contents *filesystem.ContentCache // nil if columns are counted in bytes
+}
+
+// column returns the column number of the given position, or its byte column
+// if the column cannot be determined.
+func (c *columnCounter) column(pos token.Position) int {
+	if c.contents == nil {
+		return pos.Column
+	}
+	if _, col, ok := c.contents.Position(pos.Filename, pos.Offset, c.mode); ok {
+		return col
 	}
+	return pos.Column
 }
 
 // displayablePath returns a path for the given file relative to the given
@@ -223,7 +518,7 @@ func displayablePath(file, cwd string) string {
 // has position information associated with it.
 func (log *Log) ContainsPositions() bool {
 	return log.contains(func(entry *Entry) bool {
-		return entry.Pos.IsValid()
+		return entry.Pos.IsValid() || entry.Filename != ""
 	})
 }
 
@@ -5,17 +5,21 @@
 package refactoring
 
 import (
+	"bytes"
 	"testing"
 
 	"go/token"
+
+	"github.com/godoctor/godoctor/filesystem"
+	"github.com/godoctor/godoctor/text"
 )
 
 func TestEntry(t *testing.T) {
-	e := Entry{false, Info, "Message", token.NoPos, token.NoPos}
+	e := Entry{isInitial: false, Severity: Info, Message: "Message", Pos: token.NoPos, End: token.NoPos}
 	assertEquals("Message", e.String(), t)
-	e = Entry{false, Warning, "Message", token.NoPos, token.NoPos}
+	e = Entry{isInitial: false, Severity: Warning, Message: "Message", Pos: token.NoPos, End: token.NoPos}
 	assertEquals("Warning: Message", e.String(), t)
-	e = Entry{false, Error, "Message", token.NoPos, token.NoPos}
+	e = Entry{isInitial: false, Severity: Error, Message: "Message", Pos: token.NoPos, End: token.NoPos}
 	assertEquals("Error: Message", e.String(), t)
 }
 
@@ -57,6 +61,154 @@ file2:2:1: Error: Another error
 	assertEquals(expected, log.String(), t)
 }
 
+func TestLogDiagnostics(t *testing.T) {
+	fset := token.NewFileSet()
+	file1 := fset.AddFile("file1", fset.Base(), 10)
+	file1.AddLine(5)
+
+	var log *Log = NewLog()
+	log.Fset = fset
+	log.Error("Conflict")
+	log.AssociatePos(file1.Pos(6), file1.Pos(7))
+	log.AssociateCode("rename/conflict")
+	log.AddRelated(file1.Pos(1), file1.Pos(2), "x is declared here")
+	edits := map[string]*text.EditSet{"file1": text.NewEditSet()}
+	edits["file1"].Add(&text.Extent{Offset: 1, Length: 1}, "y2")
+	log.AddFix("Rename x to y2 instead", edits)
+	log.Warn("No code")
+	var expected string = `file1:2:2: Error: Conflict [rename/conflict]
+file1:1:2: note: x is declared here
+    Suggested fix: Rename x to y2 instead
+Warning: No code
+`
+	assertEquals(expected, log.String(), t)
+
+	entry := log.Entries[0]
+	if len(entry.Fixes) != 1 || entry.Fixes[0].Edits["file1"] != edits["file1"] {
+		t.Fatal("Suggested fix not recorded")
+	}
+}
+
+func TestLogRemap(t *testing.T) {
+	oldFset := token.NewFileSet()
+	file1 := oldFset.AddFile("file1", oldFset.Base(), 10)
+	file2 := oldFset.AddFile("file2", oldFset.Base(), 10)
+
+	var log *Log = NewLog()
+	log.Error("Before edit")
+	log.AssociatePos(file1.Pos(1), file1.Pos(2))
+	log.Fset = oldFset
+	log.Error("After edit")
+	log.AssociatePos(file1.Pos(6), file1.Pos(8))
+	log.AddRelated(file2.Pos(3), file2.Pos(3), "Related")
+	log.Error("Not in program")
+	log.AssociateOffset("file3", 4, 2)
+	if log.Entries[2].Pos.IsValid() || log.Entries[2].Filename != "file3" {
+		t.Fatal("AssociateOffset with unknown file should set only filename")
+	}
+
+	// Insert 5 bytes at offset 3 in file1; file2 is no longer loaded
+	edits := map[string]*text.EditSet{"file1": text.NewEditSet()}
+	edits["file1"].Add(&text.Extent{Offset: 3, Length: 0}, "abcde")
+	newFset := token.NewFileSet()
+	newFile1 := newFset.AddFile("file1", newFset.Base(), 15)
+	log.Remap(edits, newFset, false)
+
+	before, after, notInProgram := log.Entries[0], log.Entries[1], log.Entries[2]
+	if before.Offset != 1 || before.Length != 1 || before.Pos != newFile1.Pos(1) {
+		t.Fatalf("Incorrect remapping before edit: %d %d", before.Offset, before.Length)
+	}
+	if after.Offset != 11 || after.Length != 2 || after.Pos != newFile1.Pos(11) ||
+		after.End != newFile1.Pos(13) {
+		t.Fatalf("Incorrect remapping after edit: %d %d", after.Offset, after.Length)
+	}
+	related := after.Related[0]
+	if related.Pos.IsValid() || related.Filename != "file2" || related.Offset != 3 {
+		t.Fatal("Related location in file not in FileSet should be retained")
+	}
+	if notInProgram.Pos.IsValid() || notInProgram.Filename != "file3" ||
+		notInProgram.Offset != 4 || notInProgram.Length != 2 {
+		t.Fatal("Entry in file not in FileSet should be retained")
+	}
+	var expected string = `file1:1:2: Error: Before edit
+file1:1:12: Error: After edit
+file2:#3: note: Related
+file3:#4: Error: Not in program
+`
+	assertEquals(expected, log.String(), t)
+
+	log.Remap(edits, oldFset, true)
+	if after.Offset != 6 || after.Length != 2 || after.Pos != file1.Pos(6) {
+		t.Fatalf("Incorrect reverse remapping: %d %d", after.Offset, after.Length)
+	}
+}
+
+func TestLogColumns(t *testing.T) {
+	stdinPath, err := filesystem.FakeStdinPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := "é\U0001F600x\nabc"
+	fs, err := filesystem.NewSingleEditedFileSystem(stdinPath, contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	file := fset.AddFile(stdinPath, fset.Base(), len(contents))
+	file.SetLinesForContent([]byte(contents))
+
+	var log *Log = NewLog()
+	log.Fset = fset
+	log.Error("x")
+	log.AssociatePos(file.Pos(6), file.Pos(7))
+	log.AddRelated(file.Pos(9), file.Pos(10), "b")
+
+	tests := map[text.ColumnMode]string{
+		text.ByteColumns:  "<stdin>:1:7: Error: x\n<stdin>:2:2: note: b\n",
+		text.RuneColumns:  "<stdin>:1:3: Error: x\n<stdin>:2:2: note: b\n",
+		text.UTF16Columns: "<stdin>:1:4: Error: x\n<stdin>:2:2: note: b\n",
+	}
+	for mode, expected := range tests {
+		var b bytes.Buffer
+		log.WriteColumns(&b, "", mode, fs)
+		assertEquals(expected, b.String(), t)
+	}
+}
+
+func TestLogColumnsAfterRemap(t *testing.T) {
+	fs := filesystem.NewMemoryFileSystem()
+	if err := fs.WriteFile("/f.go", "ax\n"); err != nil {
+		t.Fatal(err)
+	}
+	oldFset := token.NewFileSet()
+	oldFile := oldFset.AddFile("/f.go", oldFset.Base(), 3)
+	oldFile.SetLinesForContent([]byte("ax\n"))
+
+	var log *Log = NewLog()
+	log.Fset = oldFset
+	log.Error("x")
+	log.AssociatePos(oldFile.Pos(1), oldFile.Pos(2))
+
+	// Replace "a" with 6 bytes (1 rune, then 2 UTF-16 code units)
+	edits := map[string]*text.EditSet{"/f.go": text.NewEditSet()}
+	edits["/f.go"].Add(&text.Extent{Offset: 0, Length: 1}, "é\U0001F600")
+	newContents := "é\U0001F600x\n"
+	newFset := token.NewFileSet()
+	newFile := newFset.AddFile("/f.go", newFset.Base(), len(newContents))
+	newFile.SetLinesForContent([]byte(newContents))
+	log.Remap(edits, newFset, false)
+
+	// Columns are counted in the refactored file, which the entry refers to
+	var b bytes.Buffer
+	log.WriteColumns(&b, "", text.UTF16Columns, fs)
+	assertEquals("/f.go:1:4: Error: x\n", b.String(), t)
+
+	entry := log.Entries[0]
+	if offset, length := log.OriginalOffset(entry.Filename, entry.Offset, entry.Length); offset != 1 || length != 1 {
+		t.Fatalf("Expected original offset 1, length 1; got %d, %d", offset, length)
+	}
+}
+
 // assertEquals is a utility method for unit tests that marks a function as
 // having failed if expected != actual
 // TODO(jeff): Copied from util_test.go
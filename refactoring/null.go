@@ -30,20 +30,32 @@ func (r *Null) Description() *Description {
 }
 
 func (r *Null) Run(config *Config) *Result {
-	r.base.Run(config)
-
-	if !ValidateArgs(config, r.Description(), r.base.Log) {
+	r.CheckInitialConditions(config)
+	if r.CheckFinalConditions(config, config.Args).ContainsErrors() {
 		return &r.base.Result
 	}
 
-	if config.Args[0].(bool) {
-		r.base.Log.ChangeInitialErrorsToWarnings()
-	}
+	r.base.UpdateLog(config, false)
+	return &r.base.Result
+}
 
-	if r.base.Log.ContainsErrors() {
-		return &r.base.Result
+// CheckInitialConditions loads the program.  Any errors in the program are
+// reported; whether they prevent the refactoring from proceeding depends on
+// the allow_errors argument, which is examined by CheckFinalConditions.
+func (r *Null) CheckInitialConditions(config *Config) *Log {
+	r.base.Run(config)
+	return r.base.Log
+}
+
+// CheckFinalConditions validates the arguments and, if allow_errors is true,
+// changes any errors in the initial program to warnings.
+func (r *Null) CheckFinalConditions(config *Config, args []interface{}) *Log {
+	if !validateArgs(config, args, r.Description(), r.base.Log) {
+		return r.base.Log
 	}
 
-	r.base.UpdateLog(config, false)
-	return &r.base.Result
+	if args[0].(bool) {
+		r.base.Log.ChangeInitialErrorsToWarnings()
+	}
+	return r.base.Log
 }
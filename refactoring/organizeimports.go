@@ -0,0 +1,582 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file defines a refactoring that removes unused imports from a file,
+// adds imports for packages that are referenced but not imported, and groups
+// the imports into standard library, third-party, and local packages.
+
+package refactoring
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/godoctor/godoctor/internal/golang.org/x/tools/go/types"
+	"github.com/godoctor/godoctor/text"
+)
+
+// The OrganizeImports refactoring removes unused imports from a file, adds
+// imports for packages that are referenced but not imported, and sorts the
+// imports into groups: standard library packages, third-party packages, and
+// local packages (those whose import paths begin with a given prefix).
+type OrganizeImports struct {
+	RefactoringBase
+	// Import path prefix of local packages (may be empty)
+	localPrefix string
+	// Used to locate packages in GOROOT and GOPATH
+	buildContext *build.Context
+	// True iff the program contained errors before it was refactored
+	// (e.g., references to packages that were not imported)
+	hadErrors bool
+	// Caches for the package search, which are valid for a single run:
+	// directory -> names of subdirectories that may contain packages
+	subdirs map[string][]string
+	// directory -> package in that directory (nil if there is none)
+	dirPkgs map[string]*dirPackage
+	// import path -> true iff it is a standard library package
+	standard map[string]bool
+}
+
+// A dirPackage describes a package found while searching for missing imports.
+type dirPackage struct {
+	name    string          // The package name
+	exports map[string]bool // Exported top-level names
+}
+
+// An importLine is an import spec, as it will appear in the organized import
+// declaration.
+type importLine struct {
+	path string // The import path
+	text string // The text of the spec, including its comments
+}
+
+// Import groups, in the order they appear in the organized declaration
+const (
+	stdlibImports = iota
+	thirdPartyImports
+	localImports
+	numImportGroups
+)
+
+func (r *OrganizeImports) Description() *Description {
+	return &Description{
+		Name:      "Organize Imports",
+		Synopsis:  "Removes unused imports, adds missing imports, and groups them",
+		Usage:     "<local_prefix>",
+		HTMLDoc:   organizeImportsDoc,
+		Multifile: false,
+		Params: []Parameter{Parameter{
+			Label:        "Local Prefix:",
+			Prompt:       "Import path prefix of local packages (may be empty).",
+			DefaultValue: "",
+		}},
+		Hidden: false,
+	}
+}
+
+func (r *OrganizeImports) Run(config *Config) *Result {
+	if r.CheckInitialConditions(config).ContainsErrors() {
+		return &r.Result
+	}
+	if r.CheckFinalConditions(config, config.Args).ContainsErrors() {
+		return &r.Result
+	}
+
+	r.buildContext = newBuildContext(config, "Searching for packages")
+	r.subdirs = map[string][]string{}
+	r.dirPkgs = map[string]*dirPackage{}
+	r.standard = map[string]bool{}
+	rw := r.Rewriter()
+	lines := append(r.usedImports(rw), r.missingImports()...)
+	if err := r.replaceImports(rw, r.group(lines)); err != nil {
+		r.Log.Error(err)
+		return &r.Result
+	}
+	// If the program already contained errors, they would be reported
+	// again as errors introduced by the refactoring
+	r.UpdateLog(config, !r.hadErrors)
+	return &r.Result
+}
+
+// CheckInitialConditions loads the program.  Since this refactoring applies
+// to an entire file, any selection within a loaded file is acceptable.
+// Errors in the loaded program (e.g., references to packages that have not
+// been imported) are reported as warnings, since this refactoring may fix
+// them.
+func (r *OrganizeImports) CheckInitialConditions(config *Config) *Log {
+	r.RefactoringBase.Run(config)
+	r.hadErrors = r.Log.ContainsInitialErrors()
+	r.Log.ChangeInitialErrorsToWarnings()
+	return r.Log
+}
+
+// CheckFinalConditions verifies that the local prefix was supplied.
+func (r *OrganizeImports) CheckFinalConditions(config *Config, args []interface{}) *Log {
+	if !validateArgs(config, args, r.Description(), r.Log) {
+		return r.Log
+	}
+	r.localPrefix = args[0].(string)
+	return r.Log
+}
+
+// importDecls returns the import declarations in the file, excluding any
+// declaration that imports "C" (since its doc comment is the cgo preamble,
+// it must be left exactly where it is).
+func (r *OrganizeImports) importDecls() []*ast.GenDecl {
+	result := []*ast.GenDecl{}
+	for _, decl := range r.File.Decls {
+		decl, ok := decl.(*ast.GenDecl)
+		if !ok || decl.Tok != token.IMPORT {
+			continue
+		}
+		isCgo := false
+		for _, spec := range decl.Specs {
+			if importSpecPath(spec.(*ast.ImportSpec)) == "C" {
+				isCgo = true
+			}
+		}
+		if !isCgo {
+			result = append(result, decl)
+		}
+	}
+	return result
+}
+
+// usedImports returns the import specs in the file whose package names are
+// referenced, as determined by the type checker.  Blank and dot imports are
+// always considered used, as are imports that the type checker could not
+// resolve.
+func (r *OrganizeImports) usedImports(rw *Rewriter) []*importLine {
+	info := r.SelectedNodePkg
+	used := map[types.Object]bool{}
+	for _, obj := range info.Uses {
+		if pkgName, ok := obj.(*types.PkgName); ok {
+			used[pkgName] = true
+		}
+	}
+
+	result := []*importLine{}
+	for _, decl := range r.importDecls() {
+		for _, spec := range decl.Specs {
+			spec := spec.(*ast.ImportSpec)
+			var obj types.Object
+			if spec.Name == nil {
+				obj = info.Implicits[spec]
+			} else if spec.Name.Name != "_" && spec.Name.Name != "." {
+				obj = info.Defs[spec.Name]
+			}
+			if obj != nil && !used[obj] {
+				continue
+			}
+			start := rw.offset(docStart(spec))
+			end := rw.lineCommentEnd(rw.offset(spec.End()))
+			result = append(result, &importLine{
+				path: importSpecPath(spec),
+				text: rw.contents[start:end],
+			})
+		}
+	}
+	return result
+}
+
+// missingImports finds selector expressions x.Sel in the file where x is not
+// declared, searches for a package named x that exports every such Sel, and
+// returns an import spec for each package found.  A warning is logged for
+// each undeclared x for which no package could be found.
+func (r *OrganizeImports) missingImports() []*importLine {
+	info := r.SelectedNodePkg
+	selectors := map[string]map[string]bool{}
+	firstUse := map[string]*ast.Ident{}
+	ast.Inspect(r.File, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if id, ok := sel.X.(*ast.Ident); ok && id.Name != "_" &&
+			info.ObjectOf(id) == nil {
+			if selectors[id.Name] == nil {
+				selectors[id.Name] = map[string]bool{}
+				firstUse[id.Name] = id
+			}
+			selectors[id.Name][sel.Sel.Name] = true
+		}
+		return true
+	})
+
+	names := []string{}
+	for name := range selectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := []*importLine{}
+	for _, name := range names {
+		importPath := r.findPackage(name, selectors[name])
+		if importPath == "" {
+			r.Log.Warnf("No package named %s was found that "+
+				"exports all of the referenced identifiers", name)
+			r.Log.AssociateNode(firstUse[name])
+			continue
+		}
+		spec := strconv.Quote(importPath)
+		if path.Base(importPath) != name {
+			spec = name + " " + spec
+		}
+		result = append(result, &importLine{path: importPath, text: spec})
+	}
+	return result
+}
+
+// findPackage returns the import path of a package with the given name that
+// exports all of the given identifiers, or "" if there is no such package.
+// Packages that have already been loaded are preferred; if there are several
+// candidates, standard library packages are preferred, then shorter import
+// paths.  Otherwise, GOROOT and then each GOPATH entry is searched for a
+// directory with the given name, and the first package found is used.
+func (r *OrganizeImports) findPackage(name string, idents map[string]bool) string {
+	candidates := []string{}
+	for pkg := range r.Program.AllPackages {
+		if pkg.Name() == name && pkg != r.SelectedNodePkg.Pkg &&
+			exportsAll(pkg.Scope(), idents) {
+			candidates = append(candidates, pkg.Path())
+		}
+	}
+	if len(candidates) > 0 {
+		sort.Sort(byPreference{candidates, r})
+		return candidates[0]
+	}
+	for _, srcDir := range r.buildContext.SrcDirs() {
+		if importPath := r.searchSrcDir(srcDir, name, idents); importPath != "" {
+			return importPath
+		}
+	}
+	return ""
+}
+
+// exportsAll returns true iff the given package scope contains an exported
+// object for each of the given identifiers.
+func exportsAll(scope *types.Scope, idents map[string]bool) bool {
+	for ident := range idents {
+		if !ast.IsExported(ident) || scope.Lookup(ident) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// searchSrcDir searches the given source directory breadth first for a
+// package with the given name that exports all of the given identifiers.  It
+// returns the import path of the first such package found (i.e., one with
+// the fewest path elements), or "" if there is none.
+func (r *OrganizeImports) searchSrcDir(srcDir, name string, idents map[string]bool) string {
+	dirs := []string{srcDir}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+		for _, base := range r.subdirNames(srcDir, dir) {
+			subdir := filepath.Join(dir, base)
+			if base == name && r.dirExportsAll(subdir, name, idents) {
+				if rel, err := filepath.Rel(srcDir, subdir); err == nil {
+					return filepath.ToSlash(rel)
+				}
+			}
+			dirs = append(dirs, subdir)
+		}
+	}
+	return ""
+}
+
+// subdirNames returns the names of the subdirectories of dir (which is in the
+// source directory srcDir) that may contain importable packages, in sorted
+// order.
+func (r *OrganizeImports) subdirNames(srcDir, dir string) []string {
+	if names, ok := r.subdirs[dir]; ok {
+		return names
+	}
+	names := []string{}
+	infos, err := r.buildContext.ReadDir(dir)
+	if err == nil {
+		for _, fi := range infos {
+			base := fi.Name()
+			if !fi.IsDir() || base == "testdata" || base == "internal" ||
+				base == "vendor" || strings.HasPrefix(base, ".") ||
+				strings.HasPrefix(base, "_") ||
+				(dir == srcDir && base == "cmd" &&
+					srcDir == filepath.Join(r.buildContext.GOROOT, "src")) {
+				continue
+			}
+			names = append(names, base)
+		}
+		sort.Strings(names)
+	}
+	r.subdirs[dir] = names
+	return names
+}
+
+// dirExportsAll returns true iff the given directory contains a package with
+// the given name that declares each of the given identifiers as exported
+// top-level names.
+func (r *OrganizeImports) dirExportsAll(dir, name string, idents map[string]bool) bool {
+	pkg := r.dirPackage(dir)
+	if pkg == nil || pkg.name != name {
+		return false
+	}
+	for ident := range idents {
+		if !pkg.exports[ident] {
+			return false
+		}
+	}
+	return true
+}
+
+// dirPackage returns the package in the given directory, or nil if there is
+// none.  The package's files are parsed but not type checked.
+func (r *OrganizeImports) dirPackage(dir string) *dirPackage {
+	if pkg, ok := r.dirPkgs[dir]; ok {
+		return pkg
+	}
+	var pkg *dirPackage
+	if bp, err := r.buildContext.ImportDir(dir, 0); err == nil {
+		pkg = &dirPackage{name: bp.Name, exports: map[string]bool{}}
+		fset := token.NewFileSet()
+		for _, filename := range bp.GoFiles {
+			filename = filepath.Join(dir, filename)
+			reader, err := r.buildContext.OpenFile(filename)
+			if err != nil {
+				continue
+			}
+			file, err := parser.ParseFile(fset, filename, reader, 0)
+			reader.Close()
+			if err != nil {
+				continue
+			}
+			for ident := range file.Scope.Objects {
+				if ast.IsExported(ident) {
+					pkg.exports[ident] = true
+				}
+			}
+		}
+	}
+	r.dirPkgs[dir] = pkg
+	return pkg
+}
+
+// byPreference sorts candidate import paths so that the most preferred path
+// is first.
+type byPreference struct {
+	paths []string
+	r     *OrganizeImports
+}
+
+func (s byPreference) Len() int      { return len(s.paths) }
+func (s byPreference) Swap(i, j int) { s.paths[i], s.paths[j] = s.paths[j], s.paths[i] }
+func (s byPreference) Less(i, j int) bool {
+	a, b := s.paths[i], s.paths[j]
+	if aStd, bStd := s.r.isStandard(a), s.r.isStandard(b); aStd != bStd {
+		return aStd
+	}
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// isStandard returns true iff the given import path refers to a package in
+// GOROOT.  If the package cannot be found, it is assumed to be a standard
+// library package iff the first element of its path does not contain a dot.
+func (r *OrganizeImports) isStandard(importPath string) bool {
+	if std, ok := r.standard[importPath]; ok {
+		return std
+	}
+	var std bool
+	bp, err := r.buildContext.Import(importPath, "", build.FindOnly)
+	if err == nil {
+		std = bp.Goroot
+	} else {
+		std = !strings.Contains(strings.SplitN(importPath, "/", 2)[0], ".")
+	}
+	r.standard[importPath] = std
+	return std
+}
+
+// groupOf returns the import group for the given import path.
+func (r *OrganizeImports) groupOf(importPath string) int {
+	switch {
+	case r.localPrefix != "" && strings.HasPrefix(importPath, r.localPrefix):
+		return localImports
+	case r.isStandard(importPath):
+		return stdlibImports
+	default:
+		return thirdPartyImports
+	}
+}
+
+// group sorts the given import lines into groups, returning only the
+// non-empty groups.  The lines in each group are sorted by import path.
+func (r *OrganizeImports) group(lines []*importLine) [][]*importLine {
+	groups := make([][]*importLine, numImportGroups)
+	for _, line := range lines {
+		g := r.groupOf(line.path)
+		groups[g] = append(groups[g], line)
+	}
+	result := [][]*importLine{}
+	for _, group := range groups {
+		if len(group) > 0 {
+			sort.Sort(byImportPath(group))
+			result = append(result, group)
+		}
+	}
+	return result
+}
+
+type byImportPath []*importLine
+
+func (s byImportPath) Len() int      { return len(s) }
+func (s byImportPath) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byImportPath) Less(i, j int) bool {
+	if s[i].path == s[j].path {
+		return s[i].text < s[j].text
+	}
+	return s[i].path < s[j].path
+}
+
+// importDeclText returns the text of an import declaration containing the
+// given groups of imports, separated by blank lines.  A single import without
+// comments is written without parentheses.
+func importDeclText(groups [][]*importLine) string {
+	if len(groups) == 1 && len(groups[0]) == 1 &&
+		!strings.Contains(groups[0][0].text, "//") &&
+		!strings.Contains(groups[0][0].text, "/*") {
+		return "import " + groups[0][0].text
+	}
+	result := "import ("
+	for i, group := range groups {
+		if i > 0 {
+			result += "\n"
+		}
+		for _, line := range group {
+			result += "\n\t" + line.text
+		}
+	}
+	return result + "\n)"
+}
+
+// replaceImports replaces the first import declaration in the file with a
+// declaration containing the given groups of imports and removes the other
+// import declarations.  If the file does not contain any import declarations,
+// one is added after the package clause.
+func (r *OrganizeImports) replaceImports(rw *Rewriter, groups [][]*importLine) error {
+	decls := r.importDecls()
+	if len(groups) == 0 {
+		for _, decl := range decls {
+			if err := rw.Delete(decl); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	newText := text.FileFormat{CRLF: r.FileFormat.CRLF}.Apply(
+		importDeclText(groups))
+	if len(decls) == 0 {
+		offset := rw.lineCommentEnd(rw.offset(r.File.Name.End()))
+		return rw.edits.Add(&text.Extent{offset, 0},
+			text.FileFormat{CRLF: r.FileFormat.CRLF}.Apply("\n\n")+newText)
+	}
+
+	start := rw.offset(decls[0].Pos())
+	end := rw.offset(decls[0].End())
+	if !decls[0].Lparen.IsValid() {
+		end = rw.lineCommentEnd(end)
+	}
+	err := addLineDiff(rw.edits, start, rw.contents[start:end], newText)
+	if err != nil {
+		return err
+	}
+	for _, decl := range decls[1:] {
+		if err := rw.Delete(decl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const organizeImportsDoc = `
+  <h4>Purpose</h4>
+  <p>The Organize Imports refactoring cleans up the import declarations in a
+  file.  It removes imports that are not used, adds imports for packages that
+  are referenced but not imported, and groups the remaining imports.</p>
+  <p>Imports are placed in a single import declaration, in up to three
+  groups separated by blank lines: standard library packages, third-party
+  packages, and local packages.  Local packages are those whose import paths
+  begin with a given prefix (e.g., <tt>github.com/myorg/myproject</tt>).
+  Within each group, imports are sorted by import path.</p>
+  <p>To find a missing import, the refactoring looks for a reference
+  <tt>x.Name</tt> where <tt>x</tt> is not declared.  It then searches the
+  loaded packages, GOROOT, and GOPATH for a package named <tt>x</tt> that
+  exports every such <tt>Name</tt>.  Loaded packages are preferred; if
+  several qualify, a standard library package is preferred, then the package
+  with the shortest import path.  Otherwise, GOROOT is searched before
+  GOPATH, and the package whose import path has the fewest elements is
+  used.  If no package qualifies, a warning is displayed.</p>
+
+  <h4>Usage</h4>
+  <p>This refactoring is applied to an entire file.  It does not require any
+  particular text to be selected.  The user is prompted for the import path
+  prefix of local packages; if it is empty, imports are placed in only two
+  groups.</p>
+
+  <h4>Example</h4>
+  <p>In the following example, <tt>os</tt> is not used, and
+  <tt>strings</tt> is used but not imported.  Organizing imports with the
+  local prefix <tt>example.com/shapes</tt> produces the following
+  result.</p>
+  <table cellspacing="5" cellpadding="15" style="border: 0;">
+    <tr>
+      <th>Before</th><th>&nbsp;</th><th>After</th>
+    </tr>
+    <tr>
+      <td class="dotted">
+        <pre>package main
+
+import (
+    "example.com/shapes/geo"
+    "fmt"
+    "github.com/pkg/errors"
+    "os"
+)
+
+func main() {
+    fmt.Println(strings.ToUpper(geo.Name))
+    fmt.Println(errors.New("oops"))
+}</pre>
+      </td>
+      <td>&nbsp;&nbsp;&nbsp;&nbsp;&rArr;&nbsp;&nbsp;&nbsp;&nbsp;</td>
+      <td class="dotted">
+        <pre>package main
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/pkg/errors"
+
+    "example.com/shapes/geo"
+)
+
+func main() {
+    fmt.Println(strings.ToUpper(geo.Name))
+    fmt.Println(errors.New("oops"))
+}</pre>
+      </td>
+    </tr>
+  </table>
+`
@@ -0,0 +1,331 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file defines the Plugin refactoring, which delegates to an external
+// executable.  This allows refactorings to be added to the Go Doctor without
+// recompiling it.
+//
+// The engine communicates with a plugin by starting the executable, writing
+// a single JSON request (terminated by a newline) to its standard input, and
+// reading a single JSON response from its standard output.  Anything the
+// plugin writes to standard error is included in error messages if the
+// plugin exits with a nonzero status.  There are two requests:
+//
+//     {"version":1, "command":"describe"}
+//
+// The plugin must respond with a Description, e.g.,
+//
+//     {"description":{"Name":"Extract Function", "Synopsis":"...",
+//         "Usage":"<name>", "Multifile":false,
+//         "Params":[{"Label":"Name:", "Prompt":"...", "DefaultValue":""}]}}
+//
+// To perform the refactoring, the engine sends
+//
+//     {"version":1, "command":"run",
+//      "selection":{"filename":"/path/to/main.go", "offset":10, "length":5},
+//      "args":["newName"],
+//      "files":{"/path/to/main.go":"package main...", ...}}
+//
+// where files contains the contents of every file in the packages being
+// refactored.  The plugin must respond with
+//
+//     {"edits":{"/path/to/main.go":[{"offset":10, "length":5,
+//          "replacement":"..."}]},
+//      "log":[{"severity":"warning", "message":"...",
+//          "filename":"/path/to/main.go", "offset":10, "length":5}]}
+//
+// Edits may only be made to files that were sent to the plugin.  Severity
+// is one of "info", "warning", or "error"; the code (see Entry.Code),
+// filename, offset, and length of a log entry are optional.  After the edits
+// are applied, the refactored program is type checked, just as for any
+// built-in refactoring.
+
+package refactoring
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/godoctor/godoctor/text"
+)
+
+// pluginProtocolVersion is sent with every request to a plugin.
+const pluginProtocolVersion = 1
+
+// A Plugin is a refactoring implemented by an external executable, which
+// communicates with the engine using the protocol described above.
+type Plugin struct {
+	base RefactoringBase
+	// The executable and any command line arguments to pass to it
+	command string
+	args    []string
+	// The description returned by the plugin the first time it was needed,
+	// or the error that occurred when the plugin was asked to describe
+	// itself
+	once        sync.Once
+	description *Description
+	err         error
+}
+
+type pluginRequest struct {
+	Version   int               `json:"version"`
+	Command   string            `json:"command"`
+	Selection *pluginExtent     `json:"selection,omitempty"`
+	Args      []interface{}     `json:"args,omitempty"`
+	Files     map[string]string `json:"files,omitempty"`
+}
+
+type pluginExtent struct {
+	Filename string `json:"filename"`
+	Offset   int    `json:"offset"`
+	Length   int    `json:"length"`
+}
+
+type pluginEdit struct {
+	Offset      int    `json:"offset"`
+	Length      int    `json:"length"`
+	Replacement string `json:"replacement"`
+}
+
+type pluginLogEntry struct {
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Code     string `json:"code"`
+	pluginExtent
+}
+
+type pluginResponse struct {
+	Description *Description            `json:"description"`
+	Edits       map[string][]pluginEdit `json:"edits"`
+	Log         []pluginLogEntry        `json:"log"`
+}
+
+// NewPlugin returns a Refactoring that delegates to the given executable,
+// which is started with the given arguments.  The executable is not started
+// until the plugin's Description is needed (see Describe).
+func NewPlugin(command string, args ...string) *Plugin {
+	return &Plugin{command: command, args: args}
+}
+
+// Describe starts the plugin executable, asking it to describe itself, the
+// first time it is called; subsequent calls return the same result.  It
+// returns an error if the executable cannot be run or does not return a valid
+// Description.
+func (r *Plugin) Describe() error {
+	r.once.Do(func() {
+		resp, err := r.send(nil, &pluginRequest{
+			Version: pluginProtocolVersion,
+			Command: "describe",
+		})
+		if err != nil {
+			r.err = err
+		} else if resp.Description == nil || resp.Description.Name == "" {
+			r.err = fmt.Errorf("Plugin %s did not describe itself",
+				r.command)
+		} else {
+			r.description = resp.Description
+		}
+	})
+	return r.err
+}
+
+// Description returns the description provided by the plugin.  If the plugin
+// could not describe itself, the result is a hidden placeholder, and Run will
+// report the error.
+func (r *Plugin) Description() *Description {
+	if err := r.Describe(); err != nil {
+		return &Description{
+			Name:     filepath.Base(r.command),
+			Synopsis: err.Error(),
+			Hidden:   true,
+		}
+	}
+	return r.description
+}
+
+func (r *Plugin) Run(config *Config) *Result {
+	// Check the arguments before loading the program, which may be slow
+	log := NewLog()
+	if err := r.Describe(); err != nil {
+		log.Error(err)
+	} else {
+		ValidateArgs(config, r.description, log)
+	}
+	if log.ContainsErrors() {
+		return &Result{Log: log, Edits: map[string]*text.EditSet{}}
+	}
+
+	r.base.Run(config)
+	r.base.Log.ChangeInitialErrorsToWarnings()
+	if r.base.Log.ContainsErrors() {
+		return &r.base.Result
+	}
+
+	files, err := r.fileContents(config)
+	if err != nil {
+		r.base.Log.Error(err)
+		return &r.base.Result
+	}
+
+	offset, length := r.base.OffsetOfPos(r.base.SelectionStart),
+		int(r.base.SelectionEnd-r.base.SelectionStart)
+	resp, err := r.send(config.Cancel, &pluginRequest{
+		Version:   pluginProtocolVersion,
+		Command:   "run",
+		Selection: &pluginExtent{r.base.Filename, offset, length},
+		Args:      config.Args,
+		Files:     files,
+	})
+	if err != nil {
+		r.base.Log.Error(err)
+		return &r.base.Result
+	}
+
+	r.addLogEntries(resp.Log)
+	r.addEdits(resp.Edits, files)
+	if r.base.Log.ContainsErrors() {
+		return &r.base.Result
+	}
+	r.base.UpdateLog(config, true)
+	return &r.base.Result
+}
+
+// fileContents returns the contents of every file in the initial packages
+// (i.e., the packages in the refactoring's scope), keyed by filename.
+func (r *Plugin) fileContents(config *Config) (map[string]string, error) {
+	result := map[string]string{}
+	for _, pkgInfo := range r.base.Program.InitialPackages() {
+		for _, file := range pkgInfo.Files {
+			filename := r.base.Program.Fset.Position(file.Pos()).Filename
+			reader, err := config.FileSystem.OpenFile(filename)
+			if err != nil {
+				return nil, err
+			}
+			bytes, err := ioutil.ReadAll(reader)
+			reader.Close()
+			if err != nil {
+				return nil, err
+			}
+			result[filename] = string(bytes)
+		}
+	}
+	return result, nil
+}
+
+// addLogEntries adds the log entries returned by the plugin to the log.
+func (r *Plugin) addLogEntries(entries []pluginLogEntry) {
+	for _, entry := range entries {
+		switch strings.ToLower(entry.Severity) {
+		case "error":
+			r.base.Log.Error(entry.Message)
+		case "warning":
+			r.base.Log.Warn(entry.Message)
+		default:
+			r.base.Log.Info(entry.Message)
+		}
+		r.base.Log.AssociateCode(entry.Code)
+		if entry.Filename != "" && entry.Offset >= 0 && entry.Length >= 0 {
+			r.base.Log.AssociateOffset(entry.Filename, entry.Offset,
+				entry.Length)
+		}
+	}
+}
+
+// addEdits adds the edits returned by the plugin to the refactoring's
+// EditSets, logging an error if the plugin attempted to modify a file that
+// was not sent to it or if its edits overlap.
+func (r *Plugin) addEdits(edits map[string][]pluginEdit, files map[string]string) {
+	filenames := []string{}
+	for filename := range edits {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	for _, filename := range filenames {
+		contents, ok := files[filename]
+		if !ok {
+			r.base.Log.Errorf("Plugin %s attempted to modify %s, "+
+				"which is not in the refactoring's scope",
+				r.command, filename)
+			r.base.Log.AssociateCode("plugin/out-of-scope")
+			continue
+		}
+		if r.base.Edits[filename] == nil {
+			r.base.Edits[filename] = text.NewEditSet()
+		}
+		for _, edit := range edits[filename] {
+			if edit.Offset < 0 || edit.Length < 0 {
+				r.base.Log.Errorf("Plugin %s produced an edit "+
+					"with a negative offset or length in %s",
+					r.command, filename)
+				r.base.Log.AssociateCode("plugin/invalid-edit")
+				break
+			}
+			if edit.Offset+edit.Length > len(contents) {
+				r.base.Log.Errorf("Plugin %s produced an edit "+
+					"beyond the end of %s", r.command, filename)
+				r.base.Log.AssociateCode("plugin/invalid-edit")
+				break
+			}
+			extent := &text.Extent{Offset: edit.Offset, Length: edit.Length}
+			if err := r.base.Edits[filename].Add(extent, edit.Replacement); err != nil {
+				r.base.Log.Errorf("Plugin %s produced an invalid "+
+					"edit to %s: %s", r.command, filename, err)
+				r.base.Log.AssociateCode("plugin/invalid-edit")
+				break
+			}
+		}
+	}
+}
+
+// send starts the plugin executable, sends it the given request, and returns
+// its response.  If the cancel channel is closed before the plugin exits, the
+// plugin is killed, and send returns ErrCanceled.
+func (r *Plugin) send(cancel <-chan struct{}, req *pluginRequest) (*pluginResponse, error) {
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	input = append(input, '\n')
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(r.command, r.args...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Plugin %s failed: %s", r.command, err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err = <-done:
+	case <-cancel:
+		cmd.Process.Kill()
+		<-done
+		return nil, ErrCanceled
+	}
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return nil, fmt.Errorf("Plugin %s failed: %s", r.command, err)
+		}
+		return nil, fmt.Errorf("Plugin %s failed: %s: %s", r.command, err, msg)
+	}
+
+	var resp pluginResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("Plugin %s returned an invalid response: %s",
+			r.command, err)
+	}
+	return &resp, nil
+}
@@ -13,16 +13,20 @@ package refactoring
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/build"
 	"go/parser"
 	"go/printer"
+	"go/scanner"
 	"go/token"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -51,22 +55,108 @@ type Parameter struct {
 	// A longer (typically one sentence) description of the input
 	// requested, suitable for display in a tooltip/hover tip.
 	Prompt string
-	// The default value for this parameter.  The type of the parameter
-	// (string or boolean) can be determined from the type of its default
-	// value.
+	// The default value for this parameter.  Unless Kind is set, the type
+	// of the parameter (string, boolean, or integer) is determined from
+	// the type of its default value.
 	DefaultValue interface{}
+	// The kind of value this parameter accepts.  If this is InferredParam
+	// (the zero value), the kind is determined from DefaultValue.
+	Kind ParamKind
+	// For an EnumParam, the permissible values
+	Choices []string
+	// For an IntParam, the minimum and maximum permissible values
+	// (inclusive).  These are ignored unless Min < Max.
+	Min, Max int
+	// For a FileParam, whether the file or directory must already exist
+	MustExist bool
+	// Whether the argument may be omitted, in which case the refactoring
+	// uses DefaultValue.  Optional parameters must follow all required
+	// parameters.
+	Optional bool
 }
 
-// IsBoolean returns true iff this Parameter must be either true or false.
-func (p *Parameter) IsBoolean() bool {
-	switch p.DefaultValue.(type) {
+// A ParamKind describes what kind of value a Parameter accepts.  This
+// determines both the Go type of the corresponding argument and how that
+// argument is validated (see ValidateArgs).
+type ParamKind int
+
+const (
+	InferredParam   ParamKind = iota // determined from DefaultValue
+	StringParam                      // any string
+	BoolParam                        // a bool
+	IntParam                         // an int, between Min and Max if Min < Max
+	EnumParam                        // a string, which must be one of Choices
+	IdentifierParam                  // a string, which must be a Go identifier
+	TypeParam                        // a string, which must be a Go type expression
+	FileParam                        // a string, which must be a file path
+)
+
+var paramKindNames = []string{
+	InferredParam:   "inferred",
+	StringParam:     "string",
+	BoolParam:       "bool",
+	IntParam:        "int",
+	EnumParam:       "enum",
+	IdentifierParam: "identifier",
+	TypeParam:       "type",
+	FileParam:       "file",
+}
+
+// String returns a one-word, lowercase name for this ParamKind, which is
+// suitable for use in the protocol (e.g., "int" or "identifier").
+func (k ParamKind) String() string {
+	if k < 0 || int(k) >= len(paramKindNames) {
+		return fmt.Sprintf("ParamKind(%d)", int(k))
+	}
+	return paramKindNames[k]
+}
+
+// MarshalText encodes this ParamKind as its String.
+func (k ParamKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText decodes a ParamKind from its String.  This allows, e.g.,
+// plugins to describe a parameter's kind as "int" in JSON.
+func (k *ParamKind) UnmarshalText(text []byte) error {
+	for i, name := range paramKindNames {
+		if string(text) == name {
+			*k = ParamKind(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown parameter kind %q", string(text))
+}
+
+// EffectiveKind returns this Parameter's Kind or, if it is InferredParam, the
+// kind corresponding to the type of its DefaultValue: BoolParam for a bool,
+// IntParam for an int (or an integral float64, since that is how a
+// Description decoded from JSON represents an int), and StringParam
+// otherwise.
+func (p *Parameter) EffectiveKind() ParamKind {
+	if p.Kind != InferredParam {
+		return p.Kind
+	}
+	switch value := p.DefaultValue.(type) {
 	case bool:
-		return true
+		return BoolParam
+	case int:
+		return IntParam
+	case float64:
+		if value == float64(int(value)) {
+			return IntParam
+		}
+		return StringParam
 	default:
-		return false
+		return StringParam
 	}
 }
 
+// IsBoolean returns true iff this Parameter must be either true or false.
+func (p *Parameter) IsBoolean() bool {
+	return p.EffectiveKind() == BoolParam
+}
+
 // Description provides information about a refactoring suitable for display in
 // a user interface.
 type Description struct {
@@ -134,6 +224,100 @@ type Config struct {
 	// The GOPATH.  If this is set to the empty string, the GOPATH is
 	// determined from the environment.
 	GoPath string
+	// If ExcludeTests is true, _test.go files are not loaded.
+	ExcludeTests bool
+	// Directories whose contents are neither loaded nor modified by the
+	// refactoring (e.g., directories containing generated code).  These
+	// should be absolute paths; subdirectories are excluded as well.
+	ExcludeDirs []string
+	// Additional build tags to satisfy when loading the program.
+	BuildTags []string
+	// Build configurations (GOOS, GOARCH, and build tags) under which the
+	// program should be refactored.  If this is empty, the program is
+	// loaded only under the host configuration.  This is used by
+	// RunInConfigurations; see that function for details.
+	BuildConfigs []BuildConfig
+	// The build configuration currently being refactored, set by
+	// RunInConfigurations
+	build *BuildConfig
+	// If Verify is true, the tests in the scope are run (in the SSA
+	// interpreter) before and after the refactoring, and an error is
+	// logged for each test whose outcome changed.
+	Verify bool
+	// If Cancel is non-nil, closing it requests that the refactoring stop
+	// as soon as possible.  A canceled refactoring logs ErrCanceled as an
+	// error, and its Edits should be discarded.
+	Cancel <-chan struct{}
+	// If Progress is non-nil, it is invoked periodically while the
+	// refactoring loads the program, searches for occurrences, and checks
+	// the refactored program.  See ProgressFunc.
+	Progress ProgressFunc
+	// If Loaded is non-nil, it is invoked with the name and contents of
+	// each file read while loading the original program.  It may be
+	// invoked concurrently, and more than once for the same file.  Clients
+	// that write the refactoring's changes to disk can use these contents
+	// to ensure that the files were not modified in the meantime.
+	Loaded func(filename string, contents []byte)
+	// If shared is non-nil, the original program is loaded only once, and
+	// refactorings run with this Config share it; set by AreApplicable
+	shared *sharedProgram
+}
+
+// A sharedProgram is a loaded program, along with the errors reported while
+// loading it, that is shared by several refactorings (see AreApplicable).
+type sharedProgram struct {
+	loaded bool
+	prog   *loader.Program
+	errs   []error
+	err    error
+}
+
+// A ProgressFunc receives progress reports from a long-running refactoring.
+// The task is a brief, human-readable description of the current activity
+// (e.g., "Loading"); done is the number of units of work completed on that
+// task so far, and total is the number of units of work the task requires,
+// or 0 if the total is not known in advance.
+type ProgressFunc func(task string, done, total int)
+
+// ErrCanceled is logged when a refactoring stops because Config.Cancel was
+// closed.
+var ErrCanceled = errors.New("The refactoring was canceled")
+
+// isCanceled returns true iff this Config's Cancel channel has been closed.
+func (config *Config) isCanceled() bool {
+	select {
+	case <-config.Cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// isExcluded returns true iff the given path is in one of this Config's
+// ExcludeDirs (or is one of those directories).
+func (config *Config) isExcluded(path string) bool {
+	if len(config.ExcludeDirs) == 0 {
+		return false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		absPath = path
+	}
+	for _, dir := range config.ExcludeDirs {
+		rel, err := filepath.Rel(dir, absPath)
+		if err == nil && rel != ".." &&
+			!strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// progress invokes this Config's Progress function, if it is non-nil.
+func (config *Config) progress(task string, done, total int) {
+	if config.Progress != nil {
+		config.Progress(task, done, total)
+	}
 }
 
 // The Refactoring interface identifies methods common to all refactorings.
@@ -154,6 +338,57 @@ type Refactoring interface {
 	Run(*Config) *Result
 }
 
+// A PreconditionChecker is a Refactoring whose preconditions can be checked
+// separately from the transformation itself.  This allows a text editor to
+// determine whether a refactoring is applicable to the current selection
+// (e.g., to populate a menu) without performing the transformation.
+//
+// Precondition checking proceeds in two phases, similar to the Eclipse
+// Language Toolkit (LTK):
+//
+//     1. CheckInitialConditions loads the program and determines whether the
+//        refactoring can be applied to Config.Selection.  Config.Args are
+//        not examined, so this can be invoked before the user is prompted
+//        for any arguments.
+//     2. CheckFinalConditions determines whether the refactoring can be
+//        completed with the given arguments (e.g., whether a new name will
+//        conflict with an existing declaration).  It must be invoked after
+//        CheckInitialConditions, with the same Config.
+//
+// Each method returns a Log; if Log.ContainsErrors() is true, the refactoring
+// cannot be performed.  Run checks both sets of conditions itself, so it is
+// not necessary to invoke these methods before invoking Run.
+type PreconditionChecker interface {
+	Refactoring
+	CheckInitialConditions(*Config) *Log
+	CheckFinalConditions(*Config, []interface{}) *Log
+}
+
+// IsApplicable returns true unless the given refactoring is a
+// PreconditionChecker whose initial conditions fail for the given Config.
+// Refactorings that cannot check their preconditions separately are assumed
+// to be applicable.
+func IsApplicable(r Refactoring, config *Config) bool {
+	if checker, ok := r.(PreconditionChecker); ok {
+		return !checker.CheckInitialConditions(config).ContainsErrors()
+	}
+	return true
+}
+
+// AreApplicable returns, for each of the given refactorings, whether it
+// IsApplicable to the given Config.  The program is loaded only once, and the
+// same program is shared by every refactoring's initial conditions, since
+// those do not modify it.
+func AreApplicable(refactorings []Refactoring, config *Config) []bool {
+	sharedConfig := *config
+	sharedConfig.shared = &sharedProgram{}
+	result := make([]bool, len(refactorings))
+	for i, r := range refactorings {
+		result[i] = IsApplicable(r, &sharedConfig)
+	}
+	return result
+}
+
 type Result struct {
 	// A list of informational messages, errors, and warnings to display to
 	// the user.  If the Log.ContainsErrors() is true, the Edits may be
@@ -163,11 +398,15 @@ type Result struct {
 	// Maps filenames to the text edits that should be applied to those
 	// files.
 	Edits map[string]*text.EditSet
+	// Maps the names of files that should be created to their contents.
+	// May be nil if the refactoring does not create any files.
+	NewFiles map[string]string
+	// Maps the names of files that should be moved (renamed) to their new
+	// names.  Edits to a moved file are keyed by its original name.  May
+	// be nil if the refactoring does not move any files.
+	Moves map[string]string
 }
 
-const cgoError1 = "could not import C (cannot"
-const cgoError2 = "undeclared name: C"
-
 type RefactoringBase struct {
 	// The Program to be refactored, including all dependent files
 	Program *loader.Program
@@ -177,6 +416,9 @@ type RefactoringBase struct {
 	Filename string
 	// The complete contents of the File containing the user's selection
 	FileContents []byte
+	// The line endings and byte order mark of the File, which are
+	// preserved when the File is reformatted
+	FileFormat text.FileFormat
 	// The position of the first character of the user's selection
 	SelectionStart token.Pos
 	// The position immediately following the user's selection
@@ -192,6 +434,8 @@ type RefactoringBase struct {
 	SelectedNodePkg *loader.PackageInfo
 	// The Result of this refactoring, returned to the client invoking it
 	Result
+	// Prints types in File and records imports to add (see Imports)
+	imports *FileImports
 }
 
 // Base implementation of a Run method.  Most refactorings should invoke this
@@ -200,7 +444,10 @@ type RefactoringBase struct {
 // configures all of the fields in the RefactoringBase struct.
 func (r *RefactoringBase) Run(config *Config) *Result {
 	r.Log = NewLog()
+	r.imports = nil
 	r.Edits = map[string]*text.EditSet{}
+	r.NewFiles = nil
+	r.Moves = nil
 
 	if config.FileSystem == nil {
 		r.Log.Error("INTERNAL ERROR: null Config.FileSystem")
@@ -219,12 +466,9 @@ func (r *RefactoringBase) Run(config *Config) *Result {
 
 	var err error
 	mutex := &sync.Mutex{}
-	r.Program, err = createLoader(config, func(err error) {
+	logError := func(err error) {
 		message := strings.Replace(err.Error(), stdin+":", "<stdin>:", -1)
-		// TODO: This is temporary until go/loader handles cgo
-		if !strings.Contains(message, cgoError1) &&
-			!strings.HasSuffix(message, cgoError2) &&
-			len(r.Log.Entries) < maxInitialErrors {
+		if len(r.Log.Entries) < maxInitialErrors {
 			mutex.Lock()
 			if err, ok := err.(types.Error); ok {
 				r.Log.Error(err.Msg)
@@ -234,7 +478,25 @@ func (r *RefactoringBase) Run(config *Config) *Result {
 			}
 			mutex.Unlock()
 		}
-	})
+	}
+	if shared := config.shared; shared != nil && shared.loaded {
+		r.Program, err = shared.prog, shared.err
+		for _, e := range shared.errs {
+			logError(e)
+		}
+	} else {
+		errs := []error{}
+		r.Program, err = createLoader(config, loadingTask, func(err error) {
+			mutex.Lock()
+			errs = append(errs, err)
+			mutex.Unlock()
+			logError(err)
+		})
+		if shared != nil {
+			shared.loaded = true
+			shared.prog, shared.errs, shared.err = r.Program, errs, err
+		}
+	}
 
 	r.Log.MarkInitial()
 	if err != nil {
@@ -247,9 +509,30 @@ func (r *RefactoringBase) Run(config *Config) *Result {
 
 	r.Log.Fset = r.Program.Fset
 
+	if sym, ok := config.Selection.(*text.SymbolSelection); ok {
+		err = sym.Resolve(r.Program.Fset, symbolPackages(r.Program))
+		if err != nil {
+			r.Log.Error(err)
+			r.Log.AssociateCode("selection/invalid")
+			return &r.Result
+		}
+	}
+
+	if lc, ok := config.Selection.(*text.LineColSelection); ok && lc.Columns != text.ByteColumns {
+		// Columns are not counted in bytes, so they can only be
+		// converted using the file's contents
+		err = resolveColumns(lc, config.FileSystem)
+		if err != nil {
+			r.Log.Error(err)
+			r.Log.AssociateCode("selection/invalid")
+			return &r.Result
+		}
+	}
+
 	r.SelectionStart, r.SelectionEnd, err = config.Selection.Convert(r.Program.Fset)
 	if err != nil {
 		r.Log.Error(err)
+		r.Log.AssociateCode("selection/invalid")
 		return &r.Result
 	}
 
@@ -260,6 +543,7 @@ func (r *RefactoringBase) Run(config *Config) *Result {
 			"provided scope: %s",
 			config.Selection.GetFilename(),
 			config.Scope)
+		r.Log.AssociateCode("selection/not-in-scope")
 		// This can happen on files containing +build
 		return &r.Result
 	}
@@ -278,6 +562,7 @@ func (r *RefactoringBase) Run(config *Config) *Result {
 		r.Log.Errorf("Unable to read %s", r.Filename)
 		return &r.Result
 	}
+	r.FileFormat = text.DetectFormat(string(r.FileContents))
 
 	r.Edits = map[string]*text.EditSet{
 		r.Filename: text.NewEditSet(),
@@ -286,7 +571,45 @@ func (r *RefactoringBase) Run(config *Config) *Result {
 	return &r.Result
 }
 
-func createLoader(config *Config, errorHandler func(error)) (*loader.Program, error) {
+// loadingTask is the progress task for loading the original program.
+const loadingTask = "Loading"
+
+// createLoader loads the program described by the given Config, reporting
+// the number of files read as progress on the given task.  It returns
+// ErrCanceled if the Config's Cancel channel is closed while loading.
+func createLoader(config *Config, task string, errorHandler func(error)) (*loader.Program, error) {
+	var lconfig loader.Config
+	lconfig.Build = newBuildContext(config, task)
+	lconfig.ParserMode = parser.ParseComments | parser.DeclarationErrors
+	lconfig.AllowErrors = true
+	lconfig.SourceImports = true
+	// Files that import "C" are parsed as-is, rather than preprocessed by
+	// cgo, so that their offsets are exact (and so refactorings can edit
+	// them).  Package C is treated as a package with no declarations, and
+	// references to it are not reported as errors.
+	lconfig.ParseCgoFiles = true
+	lconfig.TypeChecker.FakeImportC = true
+	lconfig.TypeChecker.Error = errorHandler
+
+	rest, err := lconfig.FromArgs(config.Scope, !config.ExcludeTests)
+	if len(rest) > 0 {
+		errorHandler(fmt.Errorf("Unrecognized argument %s",
+			strings.Join(rest, " ")))
+	}
+	if err != nil {
+		errorHandler(err)
+	}
+	prog, err := lconfig.Load()
+	if config.isCanceled() {
+		return nil, ErrCanceled
+	}
+	return prog, err
+}
+
+// newBuildContext returns a build.Context that reads files from the given
+// Config's FileSystem and matches files against its build configuration.
+// Files read through the context are reported as progress on the given task.
+func newBuildContext(config *Config, task string) *build.Context {
 	buildContext := build.Default
 	if os.Getenv("GOPATH") != "" {
 		// The test runner may change the GOPATH environment variable
@@ -298,26 +621,106 @@ func createLoader(config *Config, errorHandler func(error)) (*loader.Program, er
 	if config.GoPath != "" {
 		buildContext.GOPATH = config.GoPath
 	}
-	buildContext.ReadDir = config.FileSystem.ReadDir
-	buildContext.OpenFile = config.FileSystem.OpenFile
-	buildContext.CgoEnabled = false
-
-	var lconfig loader.Config
-	lconfig.Build = &buildContext
-	lconfig.ParserMode = parser.ParseComments | parser.DeclarationErrors
-	lconfig.AllowErrors = true
-	lconfig.SourceImports = true
-	lconfig.TypeChecker.Error = errorHandler
+	fs := config.FileSystem
+	buildContext.ReadDir = func(dir string) ([]os.FileInfo, error) {
+		if config.isCanceled() {
+			return nil, ErrCanceled
+		}
+		if config.isExcluded(dir) {
+			return []os.FileInfo{}, nil
+		}
+		return fs.ReadDir(dir)
+	}
+	// Otherwise, go/build would check for directories on the local disk.
+	// A LocalFileSystem is the local disk, so go/build's default (a stat,
+	// rather than reading the entire directory) suffices.
+	if _, ok := fs.(*filesystem.LocalFileSystem); !ok {
+		buildContext.IsDir = func(path string) bool {
+			_, err := fs.ReadDir(path)
+			return err == nil
+		}
+	}
+	// go/loader may open files concurrently; the mutex ensures that
+	// the Progress function is not invoked concurrently
+	var mutex sync.Mutex
+	filesRead := 0
+	buildContext.OpenFile = func(path string) (io.ReadCloser, error) {
+		if config.isCanceled() {
+			return nil, ErrCanceled
+		}
+		mutex.Lock()
+		filesRead++
+		config.progress(task, filesRead, 0)
+		mutex.Unlock()
+		// Only files read while loading the original program are
+		// reported; other tasks may read refactored files.
+		if config.Loaded == nil || task != loadingTask {
+			return fs.OpenFile(path)
+		}
+		reader, err := fs.OpenFile(path)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		contents, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		config.Loaded(path, contents)
+		return ioutil.NopCloser(bytes.NewReader(contents)), nil
+	}
+	// CgoEnabled is left as in build.Default (i.e., as determined by the
+	// host and CGO_ENABLED), so files that import "C" are included exactly
+	// when they would be by the go tool.  Since those files are not
+	// preprocessed, no C toolchain is required.
+	buildContext.BuildTags = append(
+		append([]string{}, buildContext.BuildTags...),
+		config.BuildTags...)
+	if config.build != nil {
+		if config.build.GOOS != "" {
+			buildContext.GOOS = config.build.GOOS
+		}
+		if config.build.GOARCH != "" {
+			buildContext.GOARCH = config.build.GOARCH
+		}
+		buildContext.BuildTags = append(buildContext.BuildTags,
+			config.build.Tags...)
+	}
+	return &buildContext
+}
 
-	rest, err := lconfig.FromArgs(config.Scope, true)
-	if len(rest) > 0 {
-		errorHandler(fmt.Errorf("Unrecognized argument %s",
-			strings.Join(rest, " ")))
+// resolveColumns converts the columns of the given selection into byte
+// columns using the contents of the selected file.
+func resolveColumns(lc *text.LineColSelection, fs filesystem.FileSystem) error {
+	reader, err := fs.OpenFile(lc.Filename)
+	if err != nil {
+		return fmt.Errorf("Unable to open %s", lc.Filename)
 	}
+	defer reader.Close()
+	contents, err := ioutil.ReadAll(reader)
 	if err != nil {
-		errorHandler(err)
+		return fmt.Errorf("Unable to read %s", lc.Filename)
+	}
+	return lc.Resolve(contents)
+}
+
+// symbolPackages describes the packages in a program, so that a
+// SymbolSelection can be resolved against them.
+func symbolPackages(prog *loader.Program) []*text.SymbolPackage {
+	initial := map[*loader.PackageInfo]bool{}
+	for _, info := range prog.InitialPackages() {
+		initial[info] = true
+	}
+	result := []*text.SymbolPackage{}
+	for pkg, info := range prog.AllPackages {
+		result = append(result, &text.SymbolPackage{
+			Path:    pkg.Path(),
+			Name:    pkg.Name(),
+			Initial: initial[info],
+			Files:   info.Files,
+		})
 	}
-	return lconfig.Load()
+	return result
 }
 
 // guessScope makes a reasonable guess at the refactoring scope if the user
@@ -380,29 +783,146 @@ func (r *RefactoringBase) guessScope(config *Config) ([]string, string) {
 
 // ValidateArgs determines whether the arguments supplied in the given Config
 // match the parameters required by the given Description.  If they mismatch in
-// either type or number, a fatal error is logged to the given Log, and the
-// function returns false; otherwise, no error is logged, and the function
-// returns true.
+// type or number, or if an argument is not a permissible value for its
+// parameter (e.g., an IdentifierParam whose argument is not a valid Go
+// identifier), a fatal error is logged to the given Log, and the function
+// returns false; otherwise, no error is logged, and the function returns true.
+//
+// Arguments decoded from JSON represent numbers as float64 values; for an
+// IntParam, ValidateArgs accepts an integral float64 and replaces it with the
+// equivalent int in the argument list.
 func ValidateArgs(config *Config, desc *Description, log *Log) bool {
+	return validateArgs(config, config.Args, desc, log)
+}
+
+func validateArgs(config *Config, args []interface{}, desc *Description, log *Log) bool {
 	numArgsExpected := len(desc.Params)
-	numArgsSupplied := len(config.Args)
-	if numArgsSupplied != numArgsExpected {
-		log.Errorf("This refactoring requires %d arguments, "+
-			"but %d were supplied.", numArgsExpected,
-			numArgsSupplied)
+	numArgsRequired := numArgsExpected
+	for numArgsRequired > 0 && desc.Params[numArgsRequired-1].Optional {
+		numArgsRequired--
+	}
+	numArgsSupplied := len(args)
+	if numArgsSupplied < numArgsRequired || numArgsSupplied > numArgsExpected {
+		if numArgsRequired == numArgsExpected {
+			log.Errorf("This refactoring requires %d arguments, "+
+				"but %d were supplied.", numArgsExpected,
+				numArgsSupplied)
+		} else {
+			log.Errorf("This refactoring requires %d to %d "+
+				"arguments, but %d were supplied.",
+				numArgsRequired, numArgsExpected,
+				numArgsSupplied)
+		}
+		log.AssociateCode("args/count")
 		return false
 	}
-	for i, arg := range config.Args {
-		expected := reflect.TypeOf(desc.Params[i].DefaultValue)
-		if reflect.TypeOf(arg) != expected {
-			paramName := desc.Params[i].Label
-			log.Errorf("%s must be a %s", paramName, expected)
+	for i := range args {
+		if err := desc.Params[i].validate(config, &args[i]); err != nil {
+			log.Errorf("%s %s", desc.Params[i].Label, err)
+			log.AssociateCode("args/invalid")
 			return false
 		}
 	}
 	return true
 }
 
+// validate determines whether the given argument is a permissible value for
+// this parameter, returning an error (to be displayed after the parameter's
+// label) if it is not.
+func (p *Parameter) validate(config *Config, arg *interface{}) error {
+	kind := p.EffectiveKind()
+	switch kind {
+	case BoolParam:
+		if _, ok := (*arg).(bool); !ok {
+			return fmt.Errorf("must be a bool")
+		}
+		return nil
+	case IntParam:
+		if f, ok := (*arg).(float64); ok && f == float64(int(f)) {
+			*arg = int(f)
+		}
+		n, ok := (*arg).(int)
+		if !ok {
+			return fmt.Errorf("must be an int")
+		}
+		if p.Min < p.Max && (n < p.Min || n > p.Max) {
+			return fmt.Errorf("must be between %d and %d", p.Min, p.Max)
+		}
+		return nil
+	}
+
+	s, ok := (*arg).(string)
+	if !ok {
+		return fmt.Errorf("must be a string")
+	}
+	switch kind {
+	case EnumParam:
+		for _, choice := range p.Choices {
+			if s == choice {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of: %s", strings.Join(p.Choices, ", "))
+	case IdentifierParam:
+		if !isIdentifierValid(s) || token.Lookup(s).IsKeyword() {
+			return fmt.Errorf("must be a valid Go identifier")
+		}
+	case TypeParam:
+		if !isTypeExpr(s) {
+			return fmt.Errorf("must be a valid Go type")
+		}
+	case FileParam:
+		if s == "" {
+			return fmt.Errorf("must be a file path")
+		}
+		if p.MustExist && !exists(config.FileSystem, s) {
+			return fmt.Errorf("must be an existing file (%s not found)", s)
+		}
+	}
+	return nil
+}
+
+// isTypeExpr returns true iff the given string can be parsed as a Go type
+// expression, e.g., "int", "*pkg.T", or "map[string][]byte".
+func isTypeExpr(s string) bool {
+	expr, err := parser.ParseExpr(s)
+	if err != nil {
+		return false
+	}
+	for {
+		switch e := expr.(type) {
+		case *ast.Ident:
+			return true
+		case *ast.SelectorExpr:
+			_, ok := e.X.(*ast.Ident)
+			return ok
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.FuncType,
+			*ast.InterfaceType, *ast.StructType:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// exists returns true iff the given path denotes a file or directory in the
+// given file system.
+func exists(fs filesystem.FileSystem, path string) bool {
+	if fs == nil {
+		return false
+	}
+	if file, err := fs.OpenFile(path); err == nil {
+		file.Close()
+		return true
+	}
+	_, err := fs.ReadDir(path)
+	return err == nil
+}
+
 // lineColToPos converts a line/column position (where the first character in a
 // File is at // line 1, column 1) into a token.Pos
 func (r *RefactoringBase) lineColToPos(file *ast.File, line int, column int) token.Pos {
@@ -428,6 +948,11 @@ func (r *RefactoringBase) lineColToPos(file *ast.File, line int, column int) tok
 	return file.Pos()
 }
 
+// FormatFileInEditor reformats the entire current file (after applying the
+// edits to it) using go/printer, replacing r.Edits[r.Filename] with edits that
+// transform the original file into the formatted file.  Since this reformats
+// code that the refactoring did not change, most refactorings should use
+// FormatEditedRegions instead.
 func (r *RefactoringBase) FormatFileInEditor() {
 	oldFileContents := string(r.FileContents)
 	string, err := text.ApplyToString(r.Edits[r.Filename], oldFileContents)
@@ -453,7 +978,8 @@ func (r *RefactoringBase) FormatFileInEditor() {
 		r.Log.Error(err)
 		return
 	}
-	newFileContents := b.String()
+	// go/printer always uses \n line endings and omits any byte order mark
+	newFileContents := r.FileFormat.Apply(b.String())
 
 	editSet := text.Diff(
 		strings.SplitAfter(oldFileContents, "\n"),
@@ -461,16 +987,100 @@ func (r *RefactoringBase) FormatFileInEditor() {
 	r.Edits[r.Filename] = editSet
 }
 
+// Imports returns a FileImports that prints types as they should appear in
+// the File containing the user's selection.  Any imports that it records are
+// added to the File by UpdateImports.
+func (r *RefactoringBase) Imports() *FileImports {
+	if r.imports == nil {
+		r.imports = NewFileImports(r.SelectedNodePkg, r.File)
+	}
+	return r.imports
+}
+
+// UpdateImports adds edits to the File containing the user's selection,
+// adding the imports needed by types printed using Imports and removing
+// imports that the refactoring left unused.
+func (r *RefactoringBase) UpdateImports() {
+	edits := r.Edits[r.Filename]
+	contents, err := text.ApplyToString(edits, string(r.FileContents))
+	if err != nil {
+		r.Log.Errorf("Transformation produced invalid EditSet: %v",
+			err.Error())
+		return
+	}
+	importEdits, err := r.Imports().Update(contents)
+	if _, ok := err.(scanner.ErrorList); ok {
+		r.Log.Errorf("Transformation will introduce syntax errors: %v", err)
+		r.Log.AssociatePos(r.File.Pos(), r.File.End())
+		return
+	} else if err != nil {
+		r.Log.Errorf("Unable to update imports: %v", err)
+		r.Log.AssociatePos(r.File.Pos(), r.File.End())
+		return
+	}
+	r.Edits[r.Filename] = text.Compose(edits, importEdits)
+}
+
+// normalizeEdits converts the line endings in the replacement text of r.Edits
+// to match the line endings of the files being edited, and ensures that edits
+// do not remove byte order marks.
+func (r *RefactoringBase) normalizeEdits(config *Config) {
+	for filename, es := range r.Edits {
+		var contents string
+		if filename == r.Filename {
+			contents = string(r.FileContents)
+		} else {
+			reader, err := config.FileSystem.OpenFile(filename)
+			if err != nil {
+				continue
+			}
+			data, err := ioutil.ReadAll(reader)
+			reader.Close()
+			if err != nil {
+				continue
+			}
+			contents = string(data)
+		}
+		normalized, err := text.DetectFormat(contents).NormalizeEdits(es, contents)
+		if err != nil {
+			r.Log.Errorf("Transformation produced invalid EditSet: %v",
+				err.Error())
+			continue
+		}
+		r.Edits[filename] = normalized
+	}
+}
+
 // UpdateLog applies the edits in r.Edits and updates existing error messages
 // in r.Log to reflect their locations in the resulting Program.  If
 // checkForErrors is true, and if the log does not contain any initial errors,
 // the resulting Program will be type checked, and any new errors introduced by
-// the refactoring will be logged.
+// the refactoring will be logged.  If config.Verify is true, the program's
+// tests are also run before and after the refactoring (see verify.go).
 func (r *RefactoringBase) UpdateLog(config *Config, checkForErrors bool) {
 	if r.Edits == nil || len(r.Edits) == 0 {
 		return
 	}
 
+	r.normalizeEdits(config)
+
+	if config.Verify {
+		defer r.verify(config)
+	}
+
+	excluded := []string{}
+	for filename := range r.Edits {
+		if config.isExcluded(filename) {
+			excluded = append(excluded, filename)
+		}
+	}
+	sort.Strings(excluded)
+	for _, filename := range excluded {
+		r.Log.Errorf("The refactoring would modify %s, which is in "+
+			"an excluded directory", filename)
+		r.Log.AssociateCode("config/excluded")
+	}
+
 	// Avoid loading the refactored Program into a new go/loader if at all
 	// possible.  If we won't update the positions of any log entries and
 	// won't report any new errors, then we can avoid loading the
@@ -485,24 +1095,16 @@ func (r *RefactoringBase) UpdateLog(config *Config, checkForErrors bool) {
 		checkForErrors = false
 	}
 
-	programFiles := map[string]*token.File{}
-	r.Program.Fset.Iterate(func(f *token.File) bool {
-		programFiles[f.Name()] = f
-		return true
-	})
-
 	fileCount := len(r.Edits)
 	if fileCount >= 2 && config.Verbosity >= 1 {
 		fileNum := 1
 		for filename, edits := range r.Edits {
 			edits.Iterate(func(extent *text.Extent, _ string) bool {
-				file := programFiles[filename]
-				oldPos := file.Pos(extent.Offset)
 				r.Log.Infof("File %d of %d: %s",
 					fileNum,
 					fileCount,
 					filepath.Base(filename))
-				r.Log.AssociatePos(oldPos, oldPos)
+				r.Log.AssociateOffset(filename, extent.Offset, 0)
 				fileNum++
 				return false
 			})
@@ -525,58 +1127,51 @@ func (r *RefactoringBase) UpdateLog(config *Config, checkForErrors bool) {
 
 	mutex := &sync.Mutex{}
 	errors := 0
-	newProg, err := createLoader(config, func(err error) {
+	newProg, err := createLoader(config, "Checking", func(err error) {
 		if !checkForErrors {
 			return
 		}
 		message := strings.Replace(err.Error(), stdin+":", "<stdin>:", -1)
-		// TODO: This is temporary until go/loader handles cgo
-		if !strings.Contains(message, cgoError1) &&
-			!strings.HasSuffix(message, cgoError2) &&
-			errors < maxInitialErrors {
+		if errors < maxInitialErrors {
 			mutex.Lock()
 			errors++
 			msg := fmt.Sprintf("Completing the transformation will introduce the following error: %s", message)
 			if err, ok := err.(types.Error); ok {
 				newLogOldPos.Error(err.Msg)
 				newLogNewPos.Error(err.Msg)
-				oldPos := mapPos(err.Fset, err.Pos, r.Edits, programFiles, true)
-				newLogOldPos.AssociatePos(oldPos, oldPos)
+				if err.Pos.IsValid() {
+					pos := err.Fset.Position(err.Pos)
+					offset, _ := mapOffsets(r.Edits[pos.Filename], pos.Offset, 0, true)
+					newLogOldPos.AssociateOffset(pos.Filename, offset, 0)
+				}
 				newLogNewPos.Fset = err.Fset
 				newLogNewPos.AssociatePos(err.Pos, err.Pos)
 			} else {
 				newLogOldPos.Error(msg)
 				newLogNewPos.Error(msg)
 			}
+			newLogOldPos.AssociateCode("check/new-error")
+			newLogNewPos.AssociateCode("check/new-error")
 			mutex.Unlock()
 		}
 	})
-	if newProg == nil || err != nil {
+	if err == ErrCanceled {
+		r.Log.Error(err)
+		return
+	} else if newProg == nil || err != nil {
 		r.Log.Append(newLogOldPos.Entries)
 		return
 	}
 
-	newProgFiles := map[string]*token.File{}
-	newProg.Fset.Iterate(func(f *token.File) bool {
-		newProgFiles[f.Name()] = f
-		return true
-	})
-
-	r.Log.Fset = newProg.Fset
-	for _, entry := range r.Log.Entries {
-		entry.Pos = mapPos(r.Program.Fset, entry.Pos, r.Edits, newProgFiles, false)
-	}
+	r.Log.Remap(r.Edits, newProg.Fset, false)
 	r.Log.Append(newLogNewPos.Entries)
 
 	if config.Verbosity >= 2 {
 		for filename, edits := range r.Edits {
 			edits.Iterate(func(extent *text.Extent, replace string) bool {
-				oldFile := programFiles[filename]
-				oldPos := oldFile.Pos(extent.Offset)
-				newPos := mapPos(r.Program.Fset, oldPos,
-					r.Edits, newProgFiles, false)
 				r.Log.Infof(describeEdit(extent, replace))
-				r.Log.AssociatePos(newPos, newPos)
+				r.Log.AssociateOffset(filename,
+					edits.NewOffset(extent.Offset), 0)
 				return true
 			})
 		}
@@ -602,34 +1197,13 @@ func shorten(s string) string {
 	return s[:23] + "..."
 }
 
-// mapPos takes a Pos in one FileSet and returns the corresponding Pos in
-// another FileSet, applying or undoing the given edits (if reverse is false or
-// true, respectively) to determine the corresponding offset and comparing
-// filenames (as strings) to find the corresponding File.
-func mapPos(from *token.FileSet, pos token.Pos, edits map[string]*text.EditSet, toFiles map[string]*token.File, reverse bool) token.Pos {
-	if !pos.IsValid() {
-		return pos
-	}
-
-	filename := from.Position(pos).Filename
-	offset := from.Position(pos).Offset
-	if es, ok := edits[filename]; ok {
-		if reverse {
-			offset = es.OldOffset(offset)
-		} else {
-			offset = es.NewOffset(offset)
-		}
-	}
-
-	result := token.NoPos
-	if file, ok := toFiles[filename]; ok {
-		result = file.Pos(offset)
-	}
-	return result
-}
-
 /* -=-=- Utility Methods -=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=- */
 
+// InterpretArgs converts command line arguments to the types expected by the
+// given refactoring's parameters: "true" and "false" are converted to bools for
+// a BoolParam, and integers are converted to ints for an IntParam.  Arguments
+// that cannot be converted are left as strings, so ValidateArgs will report
+// them.
 func InterpretArgs(args []string, r Refactoring) []interface{} {
 	params := r.Description().Params
 	result := []interface{}{}
@@ -643,6 +1217,12 @@ func InterpretArgs(args []string, r Refactoring) []interface{} {
 			default:
 				result = append(result, opt)
 			}
+		} else if i < len(params) && params[i].EffectiveKind() == IntParam {
+			if n, err := strconv.Atoi(opt); err == nil {
+				result = append(result, n)
+			} else {
+				result = append(result, opt)
+			}
 		} else {
 			result = append(result, opt)
 		}
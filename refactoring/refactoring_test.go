@@ -1,10 +1,337 @@
 package refactoring_test
 
-import "github.com/godoctor/godoctor/refactoring/testutil"
-import "testing"
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/godoctor/godoctor/filesystem"
+	"github.com/godoctor/godoctor/refactoring"
+	"github.com/godoctor/godoctor/refactoring/testutil"
+	"github.com/godoctor/godoctor/text"
+)
 
 const directory = "testdata/"
 
 func TestRefactorings(t *testing.T) {
 	testutil.TestRefactorings(directory, t)
 }
+
+func TestValidateArgs(t *testing.T) {
+	desc := &refactoring.Description{
+		Params: []refactoring.Parameter{
+			{Label: "Count:", DefaultValue: 1, Min: 1, Max: 10},
+			{Label: "Mode:", DefaultValue: "a",
+				Kind: refactoring.EnumParam, Choices: []string{"a", "b"}},
+			{Label: "Name:", DefaultValue: "",
+				Kind: refactoring.IdentifierParam},
+			{Label: "Type:", DefaultValue: "",
+				Kind: refactoring.TypeParam},
+		},
+	}
+	tests := []struct {
+		args  []interface{}
+		valid bool
+	}{
+		{[]interface{}{5, "b", "x", "map[string][]*pkg.T"}, true},
+		{[]interface{}{5.0, "a", "_x1", "chan<- int"}, true},
+		{[]interface{}{5.5, "a", "x", "int"}, false},
+		{[]interface{}{11, "a", "x", "int"}, false},
+		{[]interface{}{"5", "a", "x", "int"}, false},
+		{[]interface{}{5, "c", "x", "int"}, false},
+		{[]interface{}{5, "a", "func", "int"}, false},
+		{[]interface{}{5, "a", "1x", "int"}, false},
+		{[]interface{}{5, "a", "x", "3+4"}, false},
+		{[]interface{}{5, "a", "x"}, false},
+	}
+	for _, test := range tests {
+		log := refactoring.NewLog()
+		config := &refactoring.Config{Args: test.args}
+		if refactoring.ValidateArgs(config, desc, log) != test.valid {
+			t.Errorf("ValidateArgs(%v) should return %t\n%s",
+				test.args, test.valid, log)
+		}
+		if test.valid {
+			if _, ok := config.Args[0].(int); !ok {
+				t.Errorf("%v was not converted to an int",
+					test.args[0])
+			}
+		}
+	}
+}
+
+func TestInterpretArgs(t *testing.T) {
+	r := new(refactoring.Null)
+	args := refactoring.InterpretArgs([]string{"false"}, r)
+	if len(args) != 1 || args[0] != false {
+		t.Fatalf("Expected [false], got %v", args)
+	}
+	if !r.Description().Params[0].IsBoolean() {
+		t.Fatalf("Parameter should be inferred to be boolean")
+	}
+	if kind := r.Description().Params[0].EffectiveKind(); kind.String() != "bool" {
+		t.Fatalf("Expected bool, got %s", kind)
+	}
+}
+
+func TestEffectiveKindFromJSON(t *testing.T) {
+	var desc refactoring.Description
+	err := json.Unmarshal([]byte(`{"Params":[{"DefaultValue":3},`+
+		`{"DefaultValue":2.5},{"DefaultValue":true},{"DefaultValue":""}]}`),
+		&desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"int", "string", "bool", "string"}
+	for i, param := range desc.Params {
+		if kind := param.EffectiveKind(); kind.String() != expected[i] {
+			t.Errorf("Parameter %d: expected %s, got %s",
+				i, expected[i], kind)
+		}
+	}
+}
+
+func TestRunInMemory(t *testing.T) {
+	fs := filesystem.NewMemoryFileSystem()
+	err := fs.LoadTxtar("/gopath/src", `-- p/p.go --
+package p
+
+var x int
+
+func f() { x = x + 1 }
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &refactoring.Config{
+		FileSystem: fs,
+		Scope:      []string{"p"},
+		Selection: &text.OffsetLengthSelection{
+			Filename: "/gopath/src/p/p.go",
+			Offset:   15,
+			Length:   1,
+		},
+		Args:   []interface{}{"y"},
+		GoPath: "/gopath",
+	}
+	result := new(refactoring.Rename).Run(config)
+	if result.Log.ContainsErrors() {
+		t.Fatal(result.Log)
+	}
+	contents, err := filesystem.ApplyEdits(result.Edits["/gopath/src/p/p.go"], fs, "/gopath/src/p/p.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "package p\n\nvar y int\n\nfunc f() { y = y + 1 }\n"
+	if string(contents) != expected {
+		t.Fatalf("Expected:\n%s\nActual:\n%s", expected, contents)
+	}
+}
+
+func TestAreApplicable(t *testing.T) {
+	fs := filesystem.NewMemoryFileSystem()
+	const src = "package p\n\nvar x int\n\nfunc f() { x = x + 1 }\n"
+	if err := fs.LoadTxtar("/gopath/src", "-- p/p.go --\n"+src); err != nil {
+		t.Fatal(err)
+	}
+	loads := 0
+	config := &refactoring.Config{
+		FileSystem: fs,
+		Scope:      []string{"p"},
+		Selection: &text.OffsetLengthSelection{
+			Filename: "/gopath/src/p/p.go",
+			Offset:   15,
+			Length:   1,
+		},
+		GoPath: "/gopath",
+		Loaded: func(filename string, contents []byte) {
+			if filename == "/gopath/src/p/p.go" {
+				loads++
+			}
+		},
+	}
+	refactorings := []refactoring.Refactoring{
+		new(refactoring.Rename),
+		new(refactoring.ToggleVar),
+		new(refactoring.Null),
+	}
+	// Loaded may be invoked more than once for a file while the program
+	// is loaded, so compare with the number of calls for one refactoring
+	refactoring.IsApplicable(new(refactoring.Rename), config)
+	loadsForOne := loads
+	loads = 0
+	applicable := refactoring.AreApplicable(refactorings, config)
+	if len(applicable) != 3 || !applicable[0] || applicable[1] || !applicable[2] {
+		t.Fatalf("Expected [true false true], got %v", applicable)
+	}
+	if loads != loadsForOne {
+		t.Fatalf("The program was loaded more than once (%d reads of "+
+			"p.go, not %d)", loads, loadsForOne)
+	}
+}
+
+func TestLoadedContents(t *testing.T) {
+	fs := filesystem.NewMemoryFileSystem()
+	const src = "package p\n\nvar x int\n\nfunc f() { x = x + 1 }\n"
+	if err := fs.LoadTxtar("/gopath/src", "-- p/p.go --\n"+src); err != nil {
+		t.Fatal(err)
+	}
+	loaded := map[string]string{}
+	config := &refactoring.Config{
+		FileSystem: fs,
+		Scope:      []string{"p"},
+		Selection: &text.OffsetLengthSelection{
+			Filename: "/gopath/src/p/p.go",
+			Offset:   15,
+			Length:   1,
+		},
+		Args:   []interface{}{"y"},
+		GoPath: "/gopath",
+		Loaded: func(filename string, contents []byte) {
+			if _, ok := loaded[filename]; !ok {
+				loaded[filename] = string(contents)
+			}
+		},
+	}
+	result := new(refactoring.Rename).Run(config)
+	if result.Log.ContainsErrors() {
+		t.Fatal(result.Log)
+	}
+	if got := loaded["/gopath/src/p/p.go"]; got != src {
+		t.Fatalf("Expected loaded contents:\n%s\nActual:\n%s", src, got)
+	}
+}
+
+func TestFormatEditedRegions(t *testing.T) {
+	fs := filesystem.NewMemoryFileSystem()
+	err := fs.LoadTxtar("/gopath/src", `-- p/p.go --
+package p
+
+func f()  {
+  x := 1 ; _ = x
+	switch x {
+	case 1:
+		y, z := x+1, 2
+		_ = y+z
+	}
+}
+
+func Exported() { }
+type T struct { a int };type u struct { }
+
+func g() { }
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &refactoring.Config{
+		FileSystem: fs,
+		Scope:      []string{"p"},
+		Selection: &text.OffsetLengthSelection{
+			Filename: "/gopath/src/p/p.go",
+			Offset:   0,
+			Length:   0,
+		},
+		GoPath: "/gopath",
+	}
+	result := new(refactoring.AddGoDoc).Run(config)
+	if result.Log.ContainsErrors() {
+		t.Fatal(result.Log)
+	}
+	contents, err := filesystem.ApplyEdits(result.Edits["/gopath/src/p/p.go"], fs, "/gopath/src/p/p.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `package p
+
+func f()  {
+  x := 1 ; _ = x
+	switch x {
+	case 1:
+		y, z := x+1, 2
+		_ = y+z
+	}
+}
+
+// Exported TODO: NEEDS COMMENT INFO
+func Exported() {}
+// T TODO: NEEDS COMMENT INFO
+type T struct{ a int }
+
+type u struct{}
+
+func g() { }
+`
+	if string(contents) != expected {
+		t.Fatalf("Expected:\n%s\nActual:\n%s", expected, contents)
+	}
+
+	// Format a statement nested in a case clause
+	fs.WriteFile("/gopath/src/p/p.go", string(contents))
+	config.Selection = &text.LineColSelection{
+		Filename:  "/gopath/src/p/p.go",
+		StartLine: 7, StartCol: 3, EndLine: 7, EndCol: 16,
+	}
+	result = new(refactoring.ToggleVar).Run(config)
+	if result.Log.ContainsErrors() {
+		t.Fatal(result.Log)
+	}
+	contents, err = filesystem.ApplyEdits(result.Edits["/gopath/src/p/p.go"], fs, "/gopath/src/p/p.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = strings.Replace(expected, "y, z := x+1, 2",
+		"var y int = x + 1\n\t\tvar z int = 2", 1)
+	if string(contents) != expected {
+		t.Fatalf("Expected:\n%s\nActual:\n%s", expected, contents)
+	}
+}
+
+func TestApplyPatchVerbose(t *testing.T) {
+	fs := filesystem.NewMemoryFileSystem()
+	err := fs.LoadTxtar("/gopath/src", `-- calc/calc.go --
+package calc
+
+func Double(x int) int { return x * 2 }
+-- other/notes.txt --
+Double doubles
+-- change.diff --
+--- /gopath/src/calc/calc.go
++++ /gopath/src/calc/calc.go
+@@ -3 +3 @@
+-func Double(x int) int { return x * 2 }
++func Double(x int) int { return x + x }
+--- /gopath/src/other/notes.txt
++++ /gopath/src/other/notes.txt
+@@ -1 +1 @@
+-Double doubles
++Double adds x to itself
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &refactoring.Config{
+		FileSystem: fs,
+		Scope:      []string{"calc"},
+		Selection: &text.OffsetLengthSelection{
+			Filename: "/gopath/src/calc/calc.go",
+			Offset:   0,
+			Length:   0,
+		},
+		Args:      []interface{}{"/gopath/src/change.diff", false},
+		GoPath:    "/gopath",
+		Verbosity: 1,
+	}
+	result := new(refactoring.ApplyPatch).Run(config)
+	if result.Log.ContainsErrors() {
+		t.Fatal(result.Log)
+	}
+	if len(result.Edits) != 2 {
+		t.Fatalf("Expected edits to 2 files, got %d", len(result.Edits))
+	}
+	for _, name := range []string{"calc.go", "notes.txt"} {
+		if !strings.Contains(result.Log.String(), "of 2: "+name) {
+			t.Errorf("%s was not listed:\n%s", name, result.Log)
+		}
+	}
+}
@@ -8,6 +8,7 @@
 package refactoring
 
 import (
+	"fmt"
 	"go/ast"
 	"go/token"
 	"path/filepath"
@@ -17,6 +18,7 @@ import (
 	"strings"
 
 	"github.com/godoctor/godoctor/internal/golang.org/x/tools/go/loader"
+	"github.com/godoctor/godoctor/internal/golang.org/x/tools/go/types"
 
 	"github.com/godoctor/godoctor/analysis/names"
 	"github.com/godoctor/godoctor/text"
@@ -48,65 +50,140 @@ func (r *Rename) Description() *Description {
 }
 
 func (r *Rename) Run(config *Config) *Result {
-	r.base.Run(config)
-	if !ValidateArgs(config, r.Description(), r.base.Log) {
+	if r.CheckInitialConditions(config).ContainsErrors() {
 		return &r.base.Result
 	}
-	r.base.Log.ChangeInitialErrorsToWarnings()
+	if r.CheckFinalConditions(config, config.Args).ContainsErrors() {
+		return &r.base.Result
+	}
+
+	r.rename(config, r.base.SelectedNode.(*ast.Ident), r.base.SelectedNodePkg)
 	if r.base.Log.ContainsErrors() {
 		return &r.base.Result
 	}
+	r.base.UpdateLog(config, false)
+	return &r.base.Result
+}
+
+// CheckInitialConditions loads the program and determines whether the
+// selected node is an identifier that can be renamed.
+func (r *Rename) CheckInitialConditions(config *Config) *Log {
+	r.base.Run(config)
+	r.base.Log.ChangeInitialErrorsToWarnings()
+	if r.base.Log.ContainsErrors() {
+		return r.base.Log
+	}
 
 	if r.base.SelectedNode == nil {
 		r.base.Log.Error("Please select an identifier to rename.")
 		r.base.Log.AssociatePos(r.base.SelectionStart, r.base.SelectionEnd)
-		return &r.base.Result
+		r.base.Log.AssociateCode("rename/not-identifier")
+		return r.base.Log
+	}
+
+	ident, ok := r.base.SelectedNode.(*ast.Ident)
+	if !ok {
+		r.base.Log.Errorf("Please select an identifier to rename. "+
+			"(Selected node: %s)", reflect.TypeOf(r.base.SelectedNode))
+		r.base.Log.AssociatePos(r.base.SelectionStart, r.base.SelectionEnd)
+		r.base.Log.AssociateCode("rename/not-identifier")
+		return r.base.Log
+	}
+
+	// FIXME: Check if main function (not type/var/etc.) -JO
+	if ident.Name == "main" && r.base.SelectedNodePkg.Pkg.Name() == "main" {
+		r.base.Log.Error("The \"main\" function in the \"main\" package cannot be renamed: it will eliminate the program entrypoint")
+		r.base.Log.AssociateNode(ident)
+		r.base.Log.AssociateCode("rename/main")
+		return r.base.Log
 	}
 
-	r.newName = config.Args[0].(string)
+	if isPredeclaredIdentifier(ident.Name) {
+		r.base.Log.Errorf("selected predeclared  identifier \"%s\" , it cannot be renamed", ident.Name)
+		r.base.Log.AssociateNode(ident)
+		r.base.Log.AssociateCode("rename/predeclared")
+		return r.base.Log
+	}
+
+	obj := r.base.SelectedNodePkg.ObjectOf(ident)
+	if obj == nil && r.selectedTypeSwitchVar() == nil {
+		r.base.Log.Errorf("Package renaming is not supported")
+		r.base.Log.AssociateNode(ident)
+		r.base.Log.AssociateCode("rename/package")
+		return r.base.Log
+	}
+
+	if obj != nil && isInGoRoot(r.base.Program.Fset.Position(obj.Pos()).Filename) {
+		r.base.Log.Errorf("%s is defined in $GOROOT and cannot be renamed",
+			ident.Name)
+		r.base.Log.AssociateNode(ident)
+		r.base.Log.AssociateCode("rename/goroot")
+		return r.base.Log
+	}
+
+	return r.base.Log
+}
+
+// CheckFinalConditions determines whether the selected identifier can be
+// renamed to the name given in args.  CheckInitialConditions must have been
+// invoked first.
+func (r *Rename) CheckFinalConditions(config *Config, args []interface{}) *Log {
+	if !validateArgs(config, args, r.Description(), r.base.Log) {
+		return r.base.Log
+	}
+
+	r.newName = args[0].(string)
 	if r.newName == "" {
 		r.base.Log.Error("newName cannot be empty")
-		return &r.base.Result
+		r.base.Log.AssociateCode("rename/invalid-name")
+		return r.base.Log
 	}
 	if !isIdentifierValid(r.newName) {
 		r.base.Log.Errorf("The new name \"%s\" is not a valid Go identifier", r.newName)
-		return &r.base.Result
+		r.base.Log.AssociateCode("rename/invalid-name")
+		return r.base.Log
 	}
 	if isReservedWord(r.newName) {
 		r.base.Log.Errorf("The new name \"%s\" is a reserved word", r.newName)
-		return &r.base.Result
+		r.base.Log.AssociateCode("rename/invalid-name")
+		return r.base.Log
 	}
 
-	switch ident := r.base.SelectedNode.(type) {
-	case *ast.Ident:
-
-		// FIXME: Check if main function (not type/var/etc.) -JO
-		if ident.Name == "main" && r.base.SelectedNodePkg.Pkg.Name() == "main" {
-			r.base.Log.Error("The \"main\" function in the \"main\" package cannot be renamed: it will eliminate the program entrypoint")
-			r.base.Log.AssociateNode(ident)
-			return &r.base.Result
-		}
+	ident := r.base.SelectedNode.(*ast.Ident)
+	if ast.IsExported(ident.Name) && !ast.IsExported(r.newName) {
+		r.base.Log.Warn("Renaming an exported name to an unexported name will introduce errors outside the package in which it is declared.")
+		r.base.Log.AssociateCode("rename/unexport")
+	}
 
-		if isPredeclaredIdentifier(ident.Name) {
-			r.base.Log.Errorf("selected predeclared  identifier \"%s\" , it cannot be renamed", ident.Name)
-			r.base.Log.AssociateNode(ident)
-			return &r.base.Result
+	obj := r.base.SelectedNodePkg.ObjectOf(ident)
+	if conflict := names.FindConflict(obj, r.newName); conflict != nil {
+		r.base.Log.Errorf("Renaming %s to %s may cause conflicts with an existing declaration", ident.Name, r.newName)
+		r.base.Log.AssociatePos(conflict.Pos(), conflict.Pos())
+		r.base.Log.AssociateCode("rename/conflict")
+		r.base.Log.AddRelated(obj.Pos(), obj.Pos(),
+			fmt.Sprintf("%s is declared here", ident.Name))
+		if alt := alternativeName(obj, r.newName); alt != "" {
+			edits := map[string]*text.EditSet{}
+			if occs, ok := r.occurrences(config, ident, r.base.SelectedNodePkg); ok {
+				r.addOccurrences(edits, ident.Name, alt, occs)
+				r.base.Log.AddFix(fmt.Sprintf("Rename %s to %s instead",
+					ident.Name, alt), edits)
+			}
 		}
+	}
+	return r.base.Log
+}
 
-		if ast.IsExported(ident.Name) && !ast.IsExported(r.newName) {
-			r.base.Log.Warn("Renaming an exported name to an unexported name will introduce errors outside the package in which it is declared.")
+// alternativeName returns a name similar to newName (newName2, newName3, etc.)
+// that does not conflict with any declaration, or "" if no such name is found.
+func alternativeName(obj types.Object, newName string) string {
+	for i := 2; i < 100; i++ {
+		alt := fmt.Sprintf("%s%d", newName, i)
+		if names.FindConflict(obj, alt) == nil {
+			return alt
 		}
-
-		r.rename(ident, r.base.SelectedNodePkg)
-		r.base.UpdateLog(config, false)
-		return &r.base.Result
-
-	default:
-		r.base.Log.Errorf("Please select an identifier to rename. "+
-			"(Selected node: %s)", reflect.TypeOf(ident))
-		r.base.Log.AssociatePos(r.base.SelectionStart, r.base.SelectionEnd)
-		return &r.base.Result
 	}
+	return ""
 }
 
 func isIdentifierValid(newName string) bool {
@@ -124,33 +201,35 @@ func isReservedWord(newName string) bool {
 	return b
 }
 
-func (r *Rename) rename(ident *ast.Ident, pkgInfo *loader.PackageInfo) {
-	obj := pkgInfo.ObjectOf(ident)
-
-	if obj == nil && r.selectedTypeSwitchVar() == nil {
-		r.base.Log.Errorf("Package renaming is not supported")
-		r.base.Log.AssociateNode(ident)
-		return
-	}
-
-	if obj != nil && isInGoRoot(r.base.Program.Fset.Position(obj.Pos()).Filename) {
-		r.base.Log.Errorf("%s is defined in $GOROOT and cannot be renamed",
-			ident.Name)
-		r.base.Log.AssociateNode(ident)
+func (r *Rename) rename(config *Config, ident *ast.Ident, pkgInfo *loader.PackageInfo) {
+	occurrences, ok := r.occurrences(config, ident, pkgInfo)
+	if !ok {
+		r.base.Log.Error(ErrCanceled)
 		return
 	}
-	if conflict := names.FindConflict(obj, r.newName); conflict != nil {
-		r.base.Log.Errorf("Renaming %s to %s may cause conflicts with an existing declaration", ident.Name, r.newName)
-		r.base.Log.AssociatePos(conflict.Pos(), conflict.Pos())
+	if r.addOccurrences(r.base.Edits, ident.Name, r.newName, occurrences) {
+		r.base.Log.Warnf("Occurrences were found in files under $GOROOT, but these will not be renamed")
 	}
+}
+
+// occurrences returns the extents of all occurrences of the given identifier,
+// keyed by filename, or false if the search was canceled.
+func (r *Rename) occurrences(config *Config, ident *ast.Ident, pkgInfo *loader.PackageInfo) (map[string][]*text.Extent, bool) {
 	var idents map[*ast.Ident]bool
 	if ts := r.selectedTypeSwitchVar(); ts != nil {
-		idents = names.FindTypeSwitchVarOccurrences(ts, r.base.SelectedNodePkg, r.base.Program)
+		idents = names.FindTypeSwitchVarOccurrences(ts, pkgInfo, r.base.Program)
 	} else {
-		idents = names.FindOccurrences(obj, r.base.Program)
+		var ok bool
+		idents, ok = names.FindOccurrencesWithProgress(
+			pkgInfo.ObjectOf(ident), r.base.Program, config.Cancel,
+			func(done, total int) {
+				config.progress("Searching", done, total)
+			})
+		if !ok {
+			return nil, false
+		}
 	}
-
-	r.addOccurrences(ident.Name, r.extents(idents, r.base.Program.Fset))
+	return r.extents(idents, r.base.Program.Fset), true
 }
 
 func (r *Rename) selectedTypeSwitchVar() *ast.TypeSwitchStmt {
@@ -194,29 +273,30 @@ func (r *Rename) extents(ids map[*ast.Ident]bool, fset *token.FileSet) map[strin
 	return sorted
 }
 
-func (r *Rename) addOccurrences(name string, allOccurrences map[string][]*text.Extent) {
+// addOccurrences adds edits replacing the given occurrences of name (and
+// occurrences of name in comments) with newName.  It returns true if any
+// occurrences were found in files under $GOROOT, which are not renamed.
+func (r *Rename) addOccurrences(edits map[string]*text.EditSet, name, newName string, allOccurrences map[string][]*text.Extent) bool {
 	hasOccsInGoRoot := false
 	for filename, occurrences := range allOccurrences {
 		if isInGoRoot(filename) {
 			hasOccsInGoRoot = true
 		} else {
-			if r.base.Edits[filename] == nil {
-				r.base.Edits[filename] = text.NewEditSet()
+			if edits[filename] == nil {
+				edits[filename] = text.NewEditSet()
 			}
 			for _, occurrence := range occurrences {
-				r.base.Edits[filename].Add(occurrence, r.newName)
+				edits[filename].Add(occurrence, newName)
 			}
 			_, file := r.fileNamed(filename)
 			commentOccurrences := names.FindInComments(
 				name, file, r.base.Program.Fset)
 			for _, occurrence := range commentOccurrences {
-				r.base.Edits[filename].Add(occurrence, r.newName)
+				edits[filename].Add(occurrence, newName)
 			}
 		}
 	}
-	if hasOccsInGoRoot {
-		r.base.Log.Warnf("Occurrences were found in files under $GOROOT, but these will not be renamed")
-	}
+	return hasOccsInGoRoot
 }
 
 func isInGoRoot(absPath string) bool {
@@ -0,0 +1,422 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file defines Rewriter, which allows a refactoring to describe changes
+// to a file as replacements, insertions, and deletions of AST nodes.
+
+package refactoring
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"reflect"
+	"strings"
+
+	"github.com/godoctor/godoctor/text"
+)
+
+// A Rewriter changes a file by replacing, inserting, and deleting AST nodes,
+// adding the corresponding changes to an EditSet.  This allows a refactoring
+// to construct new code as ASTs, rather than computing offsets and building
+// replacement strings by hand.
+//
+// New nodes are printed using go/printer.  However, a new node may contain
+// nodes from the original file (e.g., an expression moved from elsewhere in
+// the file); the source text of these nodes is copied verbatim, so their
+// formatting and any comments inside them are preserved.  Doc comments and
+// line comments attached to a replaced node are left in place, and when a
+// node is replaced, only the words that differ are changed, so the resulting
+// EditSet is as small as possible.
+//
+// A Rewriter does not format the changed code beyond what go/printer does;
+// refactorings can call FormatEditedRegions after rewriting.
+type Rewriter struct {
+	fset     *token.FileSet
+	file     *ast.File
+	tfile    *token.File
+	contents string
+	edits    *text.EditSet
+	original map[ast.Node]bool
+}
+
+// NewRewriter returns a Rewriter for the given file, which was parsed from
+// the given contents.  Edits are added to the given EditSet.
+func NewRewriter(fset *token.FileSet, file *ast.File, contents []byte, edits *text.EditSet) *Rewriter {
+	original := map[ast.Node]bool{}
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n != nil {
+			original[n] = true
+		}
+		return true
+	})
+	return &Rewriter{
+		fset:     fset,
+		file:     file,
+		tfile:    fset.File(file.Package),
+		contents: string(contents),
+		edits:    edits,
+		original: original,
+	}
+}
+
+// Rewriter returns a Rewriter that adds edits for the file containing the
+// selection to r.Edits.
+func (r *RefactoringBase) Rewriter() *Rewriter {
+	return NewRewriter(r.Program.Fset, r.File, r.FileContents,
+		r.Edits[r.Filename])
+}
+
+// Replace replaces a node in the original file with a new node.  If the new
+// node has a doc comment, it replaces the original node's doc comment;
+// otherwise, the original doc comment is kept.
+func (rw *Rewriter) Replace(old, new ast.Node) error {
+	if err := rw.checkOriginal(old); err != nil {
+		return err
+	}
+	start, end := rw.offset(old.Pos()), rw.offset(old.End())
+	if docOf(new) != nil {
+		start = rw.offset(docStart(old))
+	}
+	src, err := rw.render(new, indentOfLine(rw.contents, rw.tfile, old.Pos()))
+	if err != nil {
+		return err
+	}
+	var result error
+	text.WordDiff(rw.contents[start:end], src).Iterate(
+		func(extent *text.Extent, replacement string) bool {
+			result = rw.edits.Add(
+				&text.Extent{start + extent.Offset, extent.Length},
+				replacement)
+			return result == nil
+		})
+	return result
+}
+
+// InsertBefore inserts a new node immediately before a node in the original
+// file (and its doc comment).  If the original node begins a line, the new
+// node is inserted on a line of its own, with the same indentation (followed
+// by a blank line, for top-level declarations).  Otherwise, it is inserted on
+// the same line, followed by a comma (for expressions) or a semicolon.
+func (rw *Rewriter) InsertBefore(anchor, node ast.Node) error {
+	if err := rw.checkOriginal(anchor); err != nil {
+		return err
+	}
+	start := rw.offset(docStart(anchor))
+	lineStart := lineStart(rw.contents, start)
+	indentation := rw.contents[lineStart:start]
+	src, err := rw.render(node, indentOfLine(rw.contents, rw.tfile, anchor.Pos()))
+	if err != nil {
+		return err
+	}
+	if _, ok := node.(ast.Expr); ok || strings.TrimSpace(indentation) != "" {
+		src += inlineSeparator(node) + " "
+	} else {
+		src += lineSeparator(node) + indentation
+	}
+	return rw.edits.Add(&text.Extent{start, 0}, src)
+}
+
+// InsertAfter inserts a new node immediately after a node in the original
+// file.  If the original node ends a line (possibly followed by a comment),
+// the new node is inserted on a new line after it, with the same indentation
+// (preceded by a blank line, for top-level declarations).  Otherwise, it is
+// inserted on the same line, preceded by a comma (for expressions) or a
+// semicolon.
+func (rw *Rewriter) InsertAfter(anchor, node ast.Node) error {
+	if err := rw.checkOriginal(anchor); err != nil {
+		return err
+	}
+	end := rw.offset(anchor.End())
+	lineEnd := rw.lineCommentEnd(end)
+	src, err := rw.render(node, indentOfLine(rw.contents, rw.tfile, anchor.Pos()))
+	if err != nil {
+		return err
+	}
+	_, isExpr := node.(ast.Expr)
+	if isExpr || !rw.endsLine(lineEnd) {
+		return rw.edits.Add(&text.Extent{end, 0},
+			inlineSeparator(node)+" "+src)
+	}
+	lineStart := lineStart(rw.contents, rw.offset(anchor.Pos()))
+	indentation := rw.contents[lineStart:rw.offset(anchor.Pos())]
+	indentation = indentation[:len(indentation)-len(strings.TrimLeft(indentation, " \t"))]
+	return rw.edits.Add(&text.Extent{lineEnd, 0},
+		lineSeparator(node)+indentation+src)
+}
+
+// Delete removes a node from the original file, along with its doc comment
+// and any comment following it on the same line.  If the node is on a line
+// by itself, the entire line is removed; if it is followed (or, failing that,
+// preceded) by a comma or semicolon, the separator is removed as well.
+func (rw *Rewriter) Delete(node ast.Node) error {
+	if err := rw.checkOriginal(node); err != nil {
+		return err
+	}
+	start := rw.offset(docStart(node))
+	end := rw.lineCommentEnd(rw.offset(node.End()))
+	lineStart := lineStart(rw.contents, start)
+	before := strings.TrimRight(rw.contents[:start], " \t")
+	after := strings.TrimLeft(rw.contents[end:], " \t")
+	switch {
+	case len(before) == lineStart && rw.endsLine(end):
+		// Remove the entire line (and a blank line after it, if it
+		// would otherwise leave two consecutive blank lines)
+		start = lineStart
+		end = len(rw.contents) - len(after)
+		if strings.HasPrefix(after, "\r\n") {
+			end += 2
+		} else if strings.HasPrefix(after, "\n") {
+			end++
+		}
+		rest := strings.TrimLeft(rw.contents[end:], " \t\r")
+		prev := strings.TrimRight(rw.contents[:start], " \t\r\n")
+		if strings.HasPrefix(rest, "\n") && (prev == "" ||
+			strings.Count(rw.contents[len(prev):start], "\n") > 1) {
+			end = len(rw.contents) - len(rest) + 1
+		}
+	case strings.HasPrefix(after, ",") || strings.HasPrefix(after, ";"):
+		after = strings.TrimLeft(after[1:], " \t")
+		end = len(rw.contents) - len(after)
+	case strings.HasSuffix(before, ",") || strings.HasSuffix(before, ";"):
+		start = len(before) - 1
+	}
+	return rw.edits.Add(&text.Extent{start, end - start}, "")
+}
+
+// Source returns the source code for the given node: its text in the original
+// file, if it is an original node; otherwise, the output of go/printer (with
+// the text of any original nodes it contains copied from the original file).
+func (rw *Rewriter) Source(node ast.Node) (string, error) {
+	return rw.render(node, 0)
+}
+
+func (rw *Rewriter) checkOriginal(node ast.Node) error {
+	if !rw.original[node] {
+		return fmt.Errorf("%T is not a node in %s", node,
+			rw.tfile.Name())
+	}
+	return nil
+}
+
+func (rw *Rewriter) offset(pos token.Pos) int {
+	return rw.tfile.Offset(pos)
+}
+
+// endsLine returns true iff the given offset is followed by only whitespace
+// on the same line.
+func (rw *Rewriter) endsLine(offset int) bool {
+	rest := strings.TrimLeft(rw.contents[offset:], " \t\r")
+	return rest == "" || rest[0] == '\n'
+}
+
+// lineCommentEnd returns the offset of the end of the comment following the
+// given offset on the same line, or the given offset if there is no such
+// comment.
+func (rw *Rewriter) lineCommentEnd(offset int) int {
+	for _, cg := range rw.file.Comments {
+		start := rw.offset(cg.Pos())
+		if start >= offset &&
+			strings.TrimLeft(rw.contents[offset:start], " \t") == "" {
+			return rw.offset(cg.End())
+		}
+	}
+	return offset
+}
+
+// inlineSeparator returns the text that separates the given node from an
+// adjacent node on the same line.
+func inlineSeparator(node ast.Node) string {
+	switch node.(type) {
+	case ast.Expr, *ast.Field:
+		return ","
+	default:
+		return ";"
+	}
+}
+
+// lineSeparator returns the text that separates the given node from an
+// adjacent node on a different line.
+func lineSeparator(node ast.Node) string {
+	if _, ok := node.(ast.Decl); ok {
+		return "\n\n"
+	}
+	return "\n"
+}
+
+// docStart returns the position of the doc comment for the given node, or the
+// position of the node itself if it does not have a doc comment.
+func docStart(node ast.Node) token.Pos {
+	if doc := docOf(node); doc != nil && doc.Pos().IsValid() {
+		return doc.Pos()
+	}
+	return node.Pos()
+}
+
+// docOf returns the doc comment for the given node, or nil if the node does
+// not have a doc comment.
+func docOf(node ast.Node) *ast.CommentGroup {
+	switch n := node.(type) {
+	case *ast.FuncDecl:
+		return n.Doc
+	case *ast.GenDecl:
+		return n.Doc
+	case *ast.TypeSpec:
+		return n.Doc
+	case *ast.ValueSpec:
+		return n.Doc
+	case *ast.ImportSpec:
+		return n.Doc
+	case *ast.Field:
+		return n.Doc
+	}
+	return nil
+}
+
+// setDoc sets the doc comment for the given node, if it can have one.
+func setDoc(node ast.Node, doc *ast.CommentGroup) {
+	switch n := node.(type) {
+	case *ast.FuncDecl:
+		n.Doc = doc
+	case *ast.GenDecl:
+		n.Doc = doc
+	case *ast.TypeSpec:
+		n.Doc = doc
+	case *ast.ValueSpec:
+		n.Doc = doc
+	case *ast.ImportSpec:
+		n.Doc = doc
+	case *ast.Field:
+		n.Doc = doc
+	}
+}
+
+/* -=-=- Printing -=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=- */
+
+var (
+	posType    = reflect.TypeOf(token.NoPos)
+	objectType = reflect.TypeOf((*ast.Object)(nil))
+	scopeType  = reflect.TypeOf((*ast.Scope)(nil))
+)
+
+// render returns the source code for the given node, indented by the given
+// number of tabs (except on the first line).
+//
+// To print a new node containing original nodes, render copies the new node,
+// replacing each original expression or statement with a placeholder
+// identifier.  The copy is printed, and then the placeholders are replaced by
+// the original source text.  Positions are cleared in the copy, so go/printer
+// does not try to preserve line breaks from unrelated parts of the file.
+func (rw *Rewriter) render(node ast.Node, indent int) (string, error) {
+	if rw.original[node] {
+		start, end := rw.offset(node.Pos()), rw.offset(node.End())
+		return rw.contents[start:end], nil
+	}
+
+	originals := []ast.Node{}
+	root := rw.copyValue(reflect.ValueOf(node), reflect.TypeOf(node),
+		&originals).Interface().(ast.Node)
+
+	var b bytes.Buffer
+	if doc := docOf(root); doc != nil {
+		// Print the doc comment separately, since its position is
+		// not available to go/printer
+		for _, c := range doc.List {
+			b.WriteString(c.Text)
+			b.WriteString("\n" + strings.Repeat("\t", indent))
+		}
+		setDoc(root, nil)
+	}
+	printConfig := &printer.Config{
+		Mode:     printer.UseSpaces | printer.TabIndent,
+		Tabwidth: 8,
+		Indent:   indent,
+	}
+	var nb bytes.Buffer
+	if err := printConfig.Fprint(&nb, rw.fset, root); err != nil {
+		return "", err
+	}
+	b.WriteString(strings.TrimLeft(nb.String(), "\t"))
+
+	replacements := []string{}
+	for i, n := range originals {
+		src, _ := rw.render(n, indent)
+		replacements = append(replacements, placeholderName(i), src)
+	}
+	return strings.NewReplacer(replacements...).Replace(b.String()), nil
+}
+
+// copyValue returns a deep copy of a value in an AST, where t is the static
+// type of the field or slice element containing the value.  Positions are
+// cleared, and original nodes that can be replaced by a placeholder are
+// replaced and appended to originals.
+func (rw *Rewriter) copyValue(v reflect.Value, t reflect.Type, originals *[]ast.Node) reflect.Value {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return reflect.Zero(t)
+		}
+		return rw.copyValue(v.Elem(), t, originals)
+
+	case reflect.Ptr:
+		if v.IsNil() || v.Type() == objectType || v.Type() == scopeType {
+			return reflect.Zero(t)
+		}
+		if n, ok := v.Interface().(ast.Node); ok && rw.original[n] {
+			if p := placeholder(len(*originals), t); p.IsValid() {
+				*originals = append(*originals, n)
+				return p
+			}
+		}
+		if v.Elem().Kind() != reflect.Struct {
+			return v
+		}
+		result := reflect.New(v.Elem().Type())
+		for i := 0; i < v.Elem().NumField(); i++ {
+			field := v.Elem().Type().Field(i)
+			result.Elem().Field(i).Set(
+				rw.copyValue(v.Elem().Field(i), field.Type, originals))
+		}
+		return result
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		result := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			result.Index(i).Set(
+				rw.copyValue(v.Index(i), v.Type().Elem(), originals))
+		}
+		return result
+
+	default:
+		if v.Type() == posType {
+			return reflect.Zero(posType)
+		}
+		return v
+	}
+}
+
+// placeholder returns an identifier (or, if t is a statement type, an
+// expression statement containing an identifier) that stands for the i-th
+// original node in a copied AST.  It returns an invalid Value if an
+// identifier cannot be stored in a field of type t.
+func placeholder(i int, t reflect.Type) reflect.Value {
+	id := &ast.Ident{Name: placeholderName(i)}
+	if reflect.TypeOf(id).AssignableTo(t) {
+		return reflect.ValueOf(id)
+	}
+	stmt := &ast.ExprStmt{X: id}
+	if reflect.TypeOf(stmt).AssignableTo(t) {
+		return reflect.ValueOf(stmt)
+	}
+	return reflect.Value{}
+}
+
+func placeholderName(i int) string {
+	return fmt.Sprintf("_godoctor_%d_", i)
+}
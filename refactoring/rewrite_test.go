@@ -0,0 +1,205 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package refactoring_test
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/godoctor/godoctor/refactoring"
+	"github.com/godoctor/godoctor/text"
+)
+
+const rewriteSrc = `package p
+
+// F does something.
+func F(n int) int {
+	x := n*2 + 1 // comment
+	y, z := 1, 2
+	return x+  y + z
+}
+
+// G is deleted.
+func G() {}
+
+var a = []int{1, 2, 3}
+`
+
+// rewrite parses rewriteSrc, calls the given function to make changes, and
+// returns the rewritten source code.
+func rewrite(t *testing.T, change func(*ast.File, *refactoring.Rewriter) error) string {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "p.go", rewriteSrc, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edits := text.NewEditSet()
+	rw := refactoring.NewRewriter(fset, file, []byte(rewriteSrc), edits)
+	if err := change(file, rw); err != nil {
+		t.Fatal(err)
+	}
+	result, err := text.ApplyToString(edits, rewriteSrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func body(file *ast.File) []ast.Stmt {
+	return file.Decls[0].(*ast.FuncDecl).Body.List
+}
+
+func TestRewriterReplace(t *testing.T) {
+	var edits int
+	result := rewrite(t, func(file *ast.File, rw *refactoring.Rewriter) error {
+		// Replace x := ... with var x int = ..., reusing the original
+		// expression (which keeps its formatting)
+		assign := body(file)[0].(*ast.AssignStmt)
+		decl := &ast.DeclStmt{Decl: &ast.GenDecl{
+			Tok: token.VAR,
+			Specs: []ast.Spec{&ast.ValueSpec{
+				Names:  []*ast.Ident{assign.Lhs[0].(*ast.Ident)},
+				Type:   ast.NewIdent("int"),
+				Values: assign.Rhs,
+			}},
+		}}
+		if err := rw.Replace(assign, decl); err != nil {
+			return err
+		}
+		// Rename the function, keeping its doc comment
+		fn := file.Decls[0].(*ast.FuncDecl)
+		if err := rw.Replace(fn.Name, ast.NewIdent("H")); err != nil {
+			return err
+		}
+		return nil
+	})
+	expected := `package p
+
+// F does something.
+func H(n int) int {
+	var x int = n*2 + 1 // comment
+	y, z := 1, 2
+	return x+  y + z
+}
+
+// G is deleted.
+func G() {}
+
+var a = []int{1, 2, 3}
+`
+	assertRewrite(t, expected, result)
+
+	// Only the changed words are replaced
+	fset := token.NewFileSet()
+	file, _ := parser.ParseFile(fset, "p.go", rewriteSrc, parser.ParseComments)
+	es := text.NewEditSet()
+	rw := refactoring.NewRewriter(fset, file, []byte(rewriteSrc), es)
+	ret := body(file)[2].(*ast.ReturnStmt)
+	sum := ret.Results[0].(*ast.BinaryExpr)
+	newRet := &ast.ReturnStmt{Results: []ast.Expr{&ast.BinaryExpr{
+		X: sum.X, Op: token.SUB, Y: sum.Y}}}
+	if err := rw.Replace(ret, newRet); err != nil {
+		t.Fatal(err)
+	}
+	es.Iterate(func(*text.Extent, string) bool { edits++; return true })
+	if edits != 1 || es.SizeChange() != 0 {
+		t.Fatalf("Expected a single one-character edit: %s", es)
+	}
+
+	if err := rw.Replace(newRet, ret); err == nil {
+		t.Fatal("Replace should fail for a node not in the file")
+	}
+}
+
+func TestRewriterInsert(t *testing.T) {
+	result := rewrite(t, func(file *ast.File, rw *refactoring.Rewriter) error {
+		stmts := body(file)
+		incr := &ast.IncDecStmt{X: ast.NewIdent("n"), Tok: token.INC}
+		if err := rw.InsertBefore(stmts[0], incr); err != nil {
+			return err
+		}
+		// Insert after a statement with a line comment
+		print := &ast.ExprStmt{X: &ast.CallExpr{
+			Fun:  ast.NewIdent("println"),
+			Args: []ast.Expr{stmts[0].(*ast.AssignStmt).Lhs[0]},
+		}}
+		if err := rw.InsertAfter(stmts[0], print); err != nil {
+			return err
+		}
+		// Insert a function (with a doc comment) before G's doc comment
+		fn := &ast.FuncDecl{
+			Doc: &ast.CommentGroup{List: []*ast.Comment{
+				{Text: "// New is new."}}},
+			Name: ast.NewIdent("New"),
+			Type: &ast.FuncType{Params: &ast.FieldList{}},
+			Body: &ast.BlockStmt{List: []ast.Stmt{
+				&ast.ReturnStmt{}}},
+		}
+		if err := rw.InsertBefore(file.Decls[1], fn); err != nil {
+			return err
+		}
+		// Insert an expression into a list
+		lit := file.Decls[2].(*ast.GenDecl).Specs[0].(*ast.ValueSpec).Values[0].(*ast.CompositeLit)
+		return rw.InsertAfter(lit.Elts[2], &ast.BasicLit{Kind: token.INT, Value: "4"})
+	})
+	expected := `package p
+
+// F does something.
+func F(n int) int {
+	n++
+	x := n*2 + 1 // comment
+	println(x)
+	y, z := 1, 2
+	return x+  y + z
+}
+
+// New is new.
+func New() {
+	return
+}
+
+// G is deleted.
+func G() {}
+
+var a = []int{1, 2, 3, 4}
+`
+	assertRewrite(t, expected, result)
+}
+
+func TestRewriterDelete(t *testing.T) {
+	result := rewrite(t, func(file *ast.File, rw *refactoring.Rewriter) error {
+		stmts := body(file)
+		if err := rw.Delete(stmts[0]); err != nil {
+			return err
+		}
+		if err := rw.Delete(file.Decls[1]); err != nil {
+			return err
+		}
+		lit := file.Decls[2].(*ast.GenDecl).Specs[0].(*ast.ValueSpec).Values[0].(*ast.CompositeLit)
+		if err := rw.Delete(lit.Elts[0]); err != nil {
+			return err
+		}
+		return rw.Delete(lit.Elts[2])
+	})
+	expected := `package p
+
+// F does something.
+func F(n int) int {
+	y, z := 1, 2
+	return x+  y + z
+}
+
+var a = []int{2}
+`
+	assertRewrite(t, expected, result)
+}
+
+func assertRewrite(t *testing.T, expected, actual string) {
+	if expected != actual {
+		t.Fatalf("Expected:\n%s\nActual:\n%s", expected, actual)
+	}
+}
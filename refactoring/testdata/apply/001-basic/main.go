@@ -0,0 +1,8 @@
+package main
+
+var msg string // <<<<< apply,1,1,1,1,testdata/apply/001-basic/change.diff,false,pass
+
+func main() {
+	msg = "Hello"
+	println(msg)
+}
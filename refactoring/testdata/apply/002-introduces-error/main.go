@@ -0,0 +1,8 @@
+package main
+
+var msg string // <<<<< apply,1,1,1,1,testdata/apply/002-introduces-error/change.diff,false,fail
+
+func main() {
+	msg = "Hello"
+	println(msg)
+}
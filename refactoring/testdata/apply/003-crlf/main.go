@@ -0,0 +1,8 @@
+package main
+
+var msg string // <<<<< apply,1,1,1,1,testdata/apply/003-crlf/change.diff,pass
+
+func main() {
+	msg = "Hello"
+	println(msg)
+}
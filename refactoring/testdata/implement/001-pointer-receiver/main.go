@@ -0,0 +1,12 @@
+// <<<<< implement,8,6,8,6,shapes.Shape,pass
+package main
+
+import "shapes"
+
+var _ shapes.Shape = &Square{}
+
+type Square struct{ side float64 }
+
+func (sq *Square) Area() float64 { return sq.side * sq.side } // area
+
+func main() {}
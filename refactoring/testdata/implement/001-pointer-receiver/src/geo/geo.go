@@ -0,0 +1,3 @@
+package geo
+
+type Canvas struct{}
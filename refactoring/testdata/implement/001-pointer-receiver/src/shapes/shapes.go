@@ -0,0 +1,9 @@
+package shapes
+
+import "geo"
+
+type Shape interface {
+	Area() float64
+	Scale(factor float64) Shape
+	Draw(c *geo.Canvas) error
+}
@@ -0,0 +1,8 @@
+// <<<<< implement,4,6,4,6,error,pass
+package main
+
+type Celsius float64
+
+var _ error = Celsius(0)
+
+func main() {}
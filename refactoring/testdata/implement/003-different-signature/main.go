@@ -0,0 +1,12 @@
+// <<<<< implement,8,6,8,6,shapes.Shape,fail
+package main
+
+import "shapes"
+
+var _ shapes.Shape
+
+type T struct{}
+
+func (t T) Area() int { return 0 }
+
+func main() {}
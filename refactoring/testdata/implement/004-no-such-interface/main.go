@@ -0,0 +1,6 @@
+// <<<<< implement,4,6,4,6,main.Shape,fail
+package main
+
+type T struct{ Area float64 }
+
+func main() {}
@@ -0,0 +1,8 @@
+// <<<<< implement,4,6,4,6,T,fail
+package main
+
+type T struct{}
+
+type Shape interface{ Area() float64 }
+
+func main() {}
@@ -0,0 +1,6 @@
+// <<<<< implement,4,5,4,5,error,fail
+package main
+
+var x int
+
+func main() {}
@@ -0,0 +1,8 @@
+// <<<<< implement,4,6,4,6,error,pass
+package main
+
+type T int
+
+func (t T) Error() string { return "" }
+
+func main() {}
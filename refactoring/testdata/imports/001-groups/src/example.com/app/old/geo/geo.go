@@ -0,0 +1,3 @@
+package geo
+
+func Center() int { return 0 }
@@ -0,0 +1,13 @@
+// <<<<< imports,1,1,1,1,example.com/app,pass
+package main
+
+import (
+	"example.com/app/util" // helpers
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println(strings.ToUpper(util.Name))
+	fmt.Println(widget.New("x"), geo.Origin())
+}
@@ -0,0 +1,10 @@
+// <<<<< imports,1,1,1,1,,pass
+package main
+
+import "fmt"
+
+import "example.com/app/util"
+
+func main() {
+	fmt.Println(util.Name, geo.Center())
+}
@@ -0,0 +1,6 @@
+// <<<<< imports,1,1,1,1,,pass
+package main
+
+import "example.com/app/util"
+
+func main() {}
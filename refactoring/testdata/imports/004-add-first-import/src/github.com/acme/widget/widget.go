@@ -0,0 +1,3 @@
+package widget
+
+func New(name string) int { return 0 }
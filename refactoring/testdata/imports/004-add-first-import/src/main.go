@@ -0,0 +1,6 @@
+// <<<<< imports,1,1,1,1,,pass
+package main
+
+var w = widget.New("w")
+
+func main() {}
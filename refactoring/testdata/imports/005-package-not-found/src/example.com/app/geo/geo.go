@@ -0,0 +1,3 @@
+package geo
+
+func Origin() int { return 0 }
@@ -0,0 +1,8 @@
+// <<<<< imports,1,1,1,1,,pass
+package main
+
+import "example.com/app/geo"
+
+func main() {
+	println(geo.Origin(), geo2.Nowhere())
+}
@@ -0,0 +1,9 @@
+package cgopkg
+
+// int twice(int n) { return 2 * n; }
+import "C"
+
+// Double returns twice n, computed in C.
+func Double(n int) int {
+	return int(C.twice(C.int(n)))
+}
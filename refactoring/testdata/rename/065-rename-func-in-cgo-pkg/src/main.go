@@ -0,0 +1,7 @@
+package main
+
+import "cgopkg"
+
+func main() {
+	_ = cgopkg.Double(2) // <<<<< rename,6,13,6,13,Twice,pass
+}
@@ -0,0 +1,8 @@
+//go:build integration
+// +build integration
+
+package greet
+
+func init() {
+	Greet("integration")
+}
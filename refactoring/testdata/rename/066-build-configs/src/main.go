@@ -0,0 +1,10 @@
+package main
+
+import "greet"
+
+// Test for renaming a function that is defined differently on each operating
+// system and called from a file that is only built with the integration tag
+
+func main() {
+	greet.Greet("main") // <<<<< rename,9,8,9,8,Hello,pass
+}
@@ -0,0 +1,4 @@
+package greet
+
+func Hello() {
+}
@@ -0,0 +1,10 @@
+package main
+
+import "greet"
+
+// Test for renaming a function to a name that is already declared in a file
+// that only the Windows build includes
+
+func main() {
+	greet.Greet("main") // <<<<< rename,9,8,9,8,Hello,fail
+}
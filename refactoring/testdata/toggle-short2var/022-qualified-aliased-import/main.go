@@ -0,0 +1,10 @@
+// <<<<< toggle,7,2,7,17,pass
+package main
+
+import g "geo"
+
+func main() {
+	pt := g.Origin()
+	s := g.NewShape()
+	_, _ = pt, s
+}
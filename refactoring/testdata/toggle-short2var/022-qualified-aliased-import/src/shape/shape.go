@@ -0,0 +1,3 @@
+package shape
+
+type Shape struct{ Sides int }
@@ -0,0 +1,10 @@
+// <<<<< toggle,8,2,8,18,pass
+package main
+
+import g "geo"
+
+func main() {
+	var pt g.Point = g.Origin()
+	s := g.NewShape()
+	_, _ = pt, s
+}
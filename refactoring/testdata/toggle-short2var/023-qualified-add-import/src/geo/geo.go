@@ -0,0 +1,11 @@
+package geo
+
+import "shape"
+
+type Point struct{ X, Y int }
+
+func Origin() Point { return Point{} }
+
+func NewShape() *shape.Shape { return &shape.Shape{} }
+
+func Square() shape.Shape { return shape.Shape{4} }
@@ -0,0 +1,11 @@
+// <<<<< toggle,7,3,7,8,fail
+package main
+
+func main() {
+	x := 0
+	var f = func() {
+		x = 1
+	}
+	f()
+	println(x)
+}
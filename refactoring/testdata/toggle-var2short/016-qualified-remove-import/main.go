@@ -0,0 +1,12 @@
+// <<<<< toggle,10,2,10,34,pass
+package main
+
+import (
+	"geo"
+	"shape"
+)
+
+func main() {
+	var s shape.Shape = geo.Square()
+	_ = s
+}
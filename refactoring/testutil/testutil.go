@@ -50,6 +50,13 @@
 // output will contain absolute paths to files in the test folder, include a
 // file named filename.go.stripPaths, and the refactoring's output will be
 // stripped of all occurrences of the absolute path to the .go file.
+//
+// To run the refactorings in a test directory under several build
+// configurations (see refactoring.RunInConfigurations), include a file named
+// buildconfigs in the test directory containing a comma-separated list of
+// configurations, e.g.,
+//     linux/amd64,windows/amd64,linux/amd64+integration
+// The .golden files then reflect the merged result of every configuration.
 
 package testutil
 
@@ -76,6 +83,7 @@ const PASS = "pass"
 const FAIL = "fail"
 
 const MAIN_DOT_GO = "main.go"
+const BUILD_CONFIGS = "buildconfigs"
 
 var filterFlag = flag.String("filter", "",
 	"Only tests from directories containing this substring will be run")
@@ -206,13 +214,14 @@ func runRefactoring(directory string, filename string, marker string, t *testing
 
 	fileSystem := &filesystem.LocalFileSystem{}
 	config := &refactoring.Config{
-		FileSystem: fileSystem,
-		Scope:      []string{mainFile},
-		Selection:  selection,
-		Args:       args,
-		GoPath:     "", // FIXME(jeff): GOPATH
+		FileSystem:   fileSystem,
+		Scope:        []string{mainFile},
+		Selection:    selection,
+		Args:         args,
+		GoPath:       "", // FIXME(jeff): GOPATH
+		BuildConfigs: readBuildConfigs(directory, t),
 	}
-	result := r.Run(config)
+	result := refactoring.RunInConfigurations(r, config)
 	if shouldPass && result.Log.ContainsErrors() {
 		t.Log(result.Log)
 		t.Fatalf("Refactoring produced unexpected errors")
@@ -249,6 +258,24 @@ func runRefactoring(directory string, filename string, marker string, t *testing
 	}
 }
 
+// readBuildConfigs returns the build configurations listed in the test
+// directory's buildconfigs file, or nil if there is no such file.
+func readBuildConfigs(directory string, t *testing.T) []refactoring.BuildConfig {
+	filename := filepath.Join(directory, BUILD_CONFIGS)
+	if !exists(filename, t) {
+		return nil
+	}
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs, err := refactoring.ParseBuildConfigs(strings.TrimSpace(string(bytes)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return configs
+}
+
 func exists(filename string, t *testing.T) bool {
 	if _, err := os.Stat(filename); err == nil {
 		return true
@@ -349,8 +376,13 @@ func splitMarker(filename string, marker string, t *testing.T) (refac string, se
 	startCol := parseInt(fields[2], t)
 	endLine := parseInt(fields[3], t)
 	endCol := parseInt(fields[4], t)
-	selection = &text.LineColSelection{filename,
-		startLine, startCol, endLine, endCol}
+	selection = &text.LineColSelection{
+		Filename:  filename,
+		StartLine: startLine,
+		StartCol:  startCol,
+		EndLine:   endLine,
+		EndCol:    endCol,
+	}
 	remainder = fields[5 : len(fields)-1]
 	result = fields[len(fields)-1]
 	if result != PASS && result != FAIL {
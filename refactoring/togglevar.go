@@ -16,7 +16,6 @@ import (
 	"reflect"
 	"strings"
 
-	"github.com/godoctor/godoctor/internal/golang.org/x/tools/astutil"
 	"github.com/godoctor/godoctor/internal/golang.org/x/tools/go/types"
 	"github.com/godoctor/godoctor/text"
 )
@@ -25,6 +24,9 @@ import (
 // declarations (var n int = 5) and short assignment statements (n := 5).
 type ToggleVar struct {
 	RefactoringBase
+	// The short assignment statement (*ast.AssignStmt) or var declaration
+	// (*ast.GenDecl) to be toggled, found by CheckInitialConditions
+	target ast.Node
 }
 
 func (r *ToggleVar) Description() *Description {
@@ -40,51 +42,82 @@ func (r *ToggleVar) Description() *Description {
 }
 
 func (r *ToggleVar) Run(config *Config) *Result {
-	if r.RefactoringBase.Run(config); r.Log.ContainsErrors() {
+	if r.CheckInitialConditions(config).ContainsErrors() {
 		return &r.Result
 	}
-
-	if !ValidateArgs(config, r.Description(), r.Log) {
+	if r.CheckFinalConditions(config, config.Args).ContainsErrors() {
 		return &r.Result
 	}
 
+	switch target := r.target.(type) {
+	case *ast.AssignStmt:
+		r.short2var(target)
+	case *ast.GenDecl:
+		r.var2short(target)
+	}
+	r.UpdateImports()
+	r.UpdateLog(config, true)
+	return &r.Result
+}
+
+// CheckInitialConditions loads the program and determines whether the
+// selection is inside a short assignment statement or a local var
+// declaration.
+func (r *ToggleVar) CheckInitialConditions(config *Config) *Log {
+	r.target = nil
+	if r.RefactoringBase.Run(config); r.Log.ContainsErrors() {
+		return r.Log
+	}
+
 	if r.SelectedNode == nil {
 		r.Log.Error("selection cannot be null")
 		r.Log.AssociatePos(r.SelectionStart, r.SelectionEnd)
-		return &r.Result
+		r.Log.AssociateCode("toggle/no-selection")
+		return r.Log
 	}
 	_, nodes, _ := r.Program.PathEnclosingInterval(r.SelectionStart, r.SelectionEnd)
 	for i, node := range nodes {
 		switch selectedNode := node.(type) {
 		case *ast.AssignStmt:
 			if selectedNode.Tok == token.DEFINE {
-				r.short2var(selectedNode)
-				r.UpdateLog(config, true)
+				r.target = selectedNode
+				return r.Log
 			}
-			return &r.Result
 		case *ast.GenDecl:
 			if selectedNode.Tok == token.VAR {
 				if _, ok := nodes[i+1].(*ast.File); ok {
 					r.Log.Errorf("A Global variable cannot be defined using short assign operator")
+					r.Log.AssociateNode(selectedNode)
+					r.Log.AssociateCode("toggle/global")
 				} else {
-					r.var2short(selectedNode)
-					r.UpdateLog(config, true)
+					r.target = selectedNode
 				}
+				return r.Log
 			}
-			return &r.Result
+		default:
+			continue
 		}
+		// Only the innermost assignment or declaration can be toggled
+		break
 	}
 
 	r.Log.Errorf("Please select a short assignment (:=) statement or var declaration.\n\nSelected node: %s", reflect.TypeOf(r.SelectedNode))
 	r.Log.AssociatePos(r.SelectionStart, r.SelectionEnd)
-	return &r.Result
+	r.Log.AssociateCode("toggle/not-declaration")
+	return r.Log
+}
+
+// CheckFinalConditions verifies that no arguments were supplied.
+func (r *ToggleVar) CheckFinalConditions(config *Config, args []interface{}) *Log {
+	validateArgs(config, args, r.Description(), r.Log)
+	return r.Log
 }
 
 func (r *ToggleVar) short2var(assign *ast.AssignStmt) {
 	replacement := r.varDeclString(assign)
 	r.Edits[r.Filename].Add(r.Extent(assign), replacement)
 	if strings.Contains(replacement, "\n") {
-		r.FormatFileInEditor()
+		r.FormatEditedRegions()
 	}
 }
 
@@ -100,36 +133,23 @@ func (r *ToggleVar) rhsExprs(assign *ast.AssignStmt) []string {
 func (r *ToggleVar) varDeclString(assign *ast.AssignStmt) string {
 	var buf bytes.Buffer
 	replacement := make([]string, len(assign.Rhs))
-	path, _ := astutil.PathEnclosingInterval(r.File, assign.Pos(), assign.End())
 	for i, rhs := range assign.Rhs {
 		switch T := r.SelectedNodePkg.TypeOf(rhs).(type) {
 		case *types.Tuple: // function type
-			if typeOfFunctionType(T) == "" {
+			if typ := r.typeOfFunctionType(T); typ == "" {
 				replacement[i] = fmt.Sprintf("var %s = %s\n",
 					r.lhsNames(assign)[i].String(),
 					r.rhsExprs(assign)[i])
 			} else {
 				replacement[i] = fmt.Sprintf("var %s %s = %s\n",
 					r.lhsNames(assign)[i].String(),
-					typeOfFunctionType(T),
+					typ,
 					r.rhsExprs(assign)[i])
 			}
-		case *types.Named: // package and struct types
-			if path[len(path)-1].(*ast.File).Name.Name == T.Obj().Pkg().Name() {
-				replacement[i] = fmt.Sprintf("var %s %s = %s\n",
-					r.lhsNames(assign)[i].String(),
-					T.Obj().Name(),
-					r.rhsExprs(assign)[i])
-			} else {
-				replacement[i] = fmt.Sprintf("var %s %s = %s\n",
-					r.lhsNames(assign)[i].String(),
-					T,
-					r.rhsExprs(assign)[i])
-			}
-		default:
+		default: // types from other packages are qualified as in the file
 			replacement[i] = fmt.Sprintf("var %s %s = %s\n",
 				r.lhsNames(assign)[i].String(),
-				T,
+				r.Imports().TypeString(T),
 				r.rhsExprs(assign)[i])
 
 		}
@@ -141,12 +161,12 @@ func (r *ToggleVar) varDeclString(assign *ast.AssignStmt) string {
 // typeOfFunctionType receives a type of function's return type, which must be a
 // tuple type; if each component has the same type (T, T, T), then it returns
 // the type T as a string; otherwise, it returns the empty string.
-func typeOfFunctionType(returnType types.Type) string {
+func (r *ToggleVar) typeOfFunctionType(returnType types.Type) string {
 	typeArray := make([]string, returnType.(*types.Tuple).Len())
-	initialType := returnType.(*types.Tuple).At(0).Type().String()
+	initialType := r.Imports().TypeString(returnType.(*types.Tuple).At(0).Type())
 	finalType := initialType
 	for i := 1; i < returnType.(*types.Tuple).Len(); i++ {
-		typeArray[i] = returnType.(*types.Tuple).At(i).Type().String()
+		typeArray[i] = r.Imports().TypeString(returnType.(*types.Tuple).At(i).Type())
 		if initialType != typeArray[i] {
 			finalType = ""
 		}
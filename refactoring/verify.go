@@ -0,0 +1,444 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file implements behavior-equivalence verification.  When Config.Verify
+// is set, the tests in the refactoring's scope are run (using the SSA
+// interpreter) both before and after the refactoring, and any test whose
+// outcome changed is reported as an error.  This is a semantic safety net
+// that supplements the type checking performed by UpdateLog; of course, it is
+// only as good as the program's tests.
+//
+// The tests are interpreted in a child process (the current executable,
+// restarted with verifyEnv set in its environment), so the interpreted
+// program's output can be captured without redirecting this process's
+// standard output and error, and so a canceled refactoring can stop the
+// tests by killing the child.  The executable's main function must call
+// RunTestsIfChild for this to work.
+
+package refactoring
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/godoctor/godoctor/filesystem"
+	"github.com/godoctor/godoctor/internal/golang.org/x/tools/go/ssa"
+	"github.com/godoctor/godoctor/internal/golang.org/x/tools/go/ssa/interp"
+	"github.com/godoctor/godoctor/internal/golang.org/x/tools/go/types"
+	"github.com/godoctor/godoctor/text"
+)
+
+// errVerifyUnsupported is returned by runTests on platforms where the SSA
+// interpreter cannot run tests.
+var errVerifyUnsupported = errors.New("Behavior verification is not supported on this platform")
+
+// errNoVerifyChild is returned by runTests if the current executable cannot
+// be started as a child process to run tests.
+var errNoVerifyChild = errors.New("Behavior verification is not supported by this executable (its main function does not call refactoring.RunTestsIfChild)")
+
+// canRunChild is set by RunTestsIfChild, indicating that the current
+// executable will run tests if it is started by runTests.
+var canRunChild bool
+
+// verifyEnv is the environment variable that identifies the child process
+// started by runTests.
+const verifyEnv = "GODOCTOR_VERIFY_CHILD"
+
+// The child process writes a line beginning with verifyTests, followed by the
+// names of the tests it found, before it interprets the tests; if it cannot
+// run the tests, it writes a line beginning with verifyError instead.
+const (
+	verifyTests = "godoctor-verify-tests:"
+	verifyError = "godoctor-verify-error:"
+)
+
+// A verifyRequest is sent to the child process on its standard input.  It
+// contains the contents of every file read when the program was loaded that
+// the child cannot read from the local disk (e.g., files edited by the
+// refactoring), so the child can load exactly the same program.  If Local is
+// false, the program was not loaded from the local disk, so Files contains
+// every file that was read.
+type verifyRequest struct {
+	Files     map[string]string
+	Local     bool
+	Scope     []string
+	GoPath    string
+	BuildTags []string
+	Build     *BuildConfig
+}
+
+// RunTestsIfChild must be called at the start of main (before standard input
+// is read) by any program that refactors with Config.Verify set.  If the
+// process was started by a refactoring to run tests, this runs them and exits;
+// otherwise, it returns immediately.
+func RunTestsIfChild() {
+	if os.Getenv(verifyEnv) != "" {
+		os.Unsetenv(verifyEnv)
+		os.Exit(runTestsInChild(os.Stdin, os.Stdout))
+	}
+	canRunChild = true
+}
+
+// A testOutcome records whether a test passed or failed.
+type testOutcome int
+
+const (
+	testDidNotRun testOutcome = iota // no result was reported for the test
+	testPassed
+	testFailed
+)
+
+func (o testOutcome) String() string {
+	switch o {
+	case testPassed:
+		return "passes"
+	case testFailed:
+		return "fails"
+	default:
+		return "does not complete"
+	}
+}
+
+// verify runs the tests in the refactoring's scope before and after the
+// refactoring and logs an error for every test whose outcome changed.  It does
+// nothing if the log already contains errors, since the refactored program
+// may not compile.
+func (r *RefactoringBase) verify(config *Config) {
+	if r.Log.ContainsErrors() || len(r.Edits) == 0 {
+		return
+	}
+
+	before, err := runTests(config, "Testing original program")
+	if err == ErrCanceled {
+		r.Log.Error(err)
+		return
+	} else if err != nil {
+		r.Log.Warnf("Unable to verify behavior: %s", err)
+		return
+	}
+	if len(before) == 0 {
+		r.Log.Warn("Unable to verify behavior: no tests were found")
+		return
+	}
+
+	oldFS := config.FileSystem
+	config.FileSystem = filesystem.NewEditedFileSystem(oldFS, r.Edits)
+	after, err := runTests(config, "Testing refactored program")
+	config.FileSystem = oldFS
+	if err == ErrCanceled {
+		r.Log.Error(err)
+		return
+	} else if err != nil {
+		r.Log.Warnf("Unable to verify behavior: %s", err)
+		return
+	}
+
+	names := []string{}
+	for name := range before {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	changed := false
+	for _, name := range names {
+		if before[name] != after[name] {
+			r.Log.Errorf("%s %s before the refactoring but %s "+
+				"after it", name, before[name], after[name])
+			r.Log.AssociateCode("verify/changed")
+			changed = true
+		}
+	}
+	if !changed {
+		r.Log.Infof("Verified that the outcomes of %d tests are "+
+			"unchanged", len(names))
+	}
+}
+
+// runTests loads the program in the given Config and runs the tests in its
+// initial packages in a child process, using the SSA interpreter.  It returns
+// the outcome of each test, keyed by test name.
+func runTests(config *Config, task string) (map[string]testOutcome, error) {
+	if runtime.GOOS == "windows" {
+		return nil, errVerifyUnsupported
+	} else if !canRunChild {
+		return nil, errNoVerifyChild
+	}
+
+	testConfig := *config
+	testConfig.ExcludeTests = false
+	recorder := &recordingFileSystem{
+		FileSystem: config.FileSystem,
+		files:      map[string]string{},
+	}
+	testConfig.FileSystem = recorder
+
+	var mutex sync.Mutex
+	var loadErr error
+	_, err := createLoader(&testConfig, task, func(err error) {
+		mutex.Lock()
+		if loadErr == nil {
+			loadErr = err
+		}
+		mutex.Unlock()
+	})
+	if err != nil {
+		return nil, err
+	} else if loadErr != nil {
+		return nil, fmt.Errorf("the program could not be loaded (%s)",
+			loadErr)
+	}
+
+	request, err := json.Marshal(&verifyRequest{
+		Files:     recorder.files,
+		Local:     isLocal(config.FileSystem),
+		Scope:     config.Scope,
+		GoPath:    newBuildContext(config, task).GOPATH,
+		BuildTags: config.BuildTags,
+		Build:     config.build,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	config.progress(task, 0, 1)
+	output, err := runChild(config, request)
+	config.progress(task, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return parseTestOutput(output)
+}
+
+// runChild starts a child process to run tests, sends it the given request,
+// and returns its combined standard output and standard error.  If the
+// Config's Cancel channel is closed, the child is killed.
+func runChild(config *Config, request []byte) (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	var output bytes.Buffer
+	cmd := exec.Command(executable)
+	cmd.Env = append(os.Environ(), verifyEnv+"=1")
+	cmd.Stdin = bytes.NewReader(request)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err = <-done:
+	case <-config.Cancel:
+		cmd.Process.Kill()
+		<-done
+		return "", ErrCanceled
+	}
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		return "", err
+	}
+	// A non-zero exit status is expected when a test fails
+	return output.String(), nil
+}
+
+// parseTestOutput returns the outcome of each test, given the output of the
+// child process started by runChild.
+func parseTestOutput(output string) (map[string]testOutcome, error) {
+	var result map[string]testOutcome
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		var outcome testOutcome
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, verifyError):
+			return nil, errors.New(
+				strings.TrimSpace(line[len(verifyError):]))
+		case strings.HasPrefix(line, verifyTests) && result == nil:
+			result = map[string]testOutcome{}
+			for _, name := range strings.Fields(line[len(verifyTests):]) {
+				result[name] = testDidNotRun
+			}
+			continue
+		case strings.HasPrefix(line, "--- PASS: "):
+			outcome = testPassed
+		case strings.HasPrefix(line, "--- FAIL: "):
+			outcome = testFailed
+		default:
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) >= 3 {
+			if _, ok := result[fields[2]]; ok {
+				result[fields[2]] = outcome
+			}
+		}
+	}
+	if result == nil {
+		return nil, fmt.Errorf("the test process failed (%s)",
+			strings.TrimSpace(output))
+	}
+	return result, nil
+}
+
+// runTestsInChild reads a verifyRequest from in, loads the program it
+// describes, writes the names of its tests to out, and then interprets the
+// tests; the interpreted program writes its output directly to this process's
+// standard output and error.  It returns the process's exit status.
+func runTestsInChild(in io.Reader, out io.Writer) int {
+	var request verifyRequest
+	if err := json.NewDecoder(in).Decode(&request); err != nil {
+		fmt.Fprintln(out, verifyError, err)
+		return 1
+	}
+	fs, err := childFileSystem(&request)
+	if err != nil {
+		fmt.Fprintln(out, verifyError, err)
+		return 1
+	}
+	config := &Config{
+		FileSystem: fs,
+		Scope:      request.Scope,
+		GoPath:     request.GoPath,
+		BuildTags:  request.BuildTags,
+		build:      request.Build,
+	}
+	prog, err := createLoader(config, "", func(error) {})
+	if err != nil {
+		fmt.Fprintln(out, verifyError, err)
+		return 1
+	}
+
+	ssaProg := ssa.Create(prog, 0)
+	ssaProg.BuildAll()
+	pkgs := []*ssa.Package{}
+	for _, info := range prog.InitialPackages() {
+		if pkg := ssaProg.Package(info.Pkg); pkg != nil {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	_, tests, _, _ := ssa.FindTests(pkgs)
+	names := []string{}
+	for _, test := range tests {
+		names = append(names, test.Name())
+	}
+	fmt.Fprintln(out, verifyTests, strings.Join(names, " "))
+	testmain := ssaProg.CreateTestMainPackage(pkgs...)
+	if len(tests) == 0 || testmain == nil {
+		return 0
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Fprintln(out, verifyError,
+				fmt.Sprintf("the interpreter failed (%v)", err))
+			os.Exit(1)
+		}
+	}()
+	return interp.Interpret(testmain, 0, sizes(), "testmain",
+		[]string{"-test.v"})
+}
+
+// childFileSystem returns a FileSystem containing the files in the given
+// request.  If the request is Local, other files are read from the local disk.
+func childFileSystem(request *verifyRequest) (filesystem.FileSystem, error) {
+	if !request.Local {
+		fs := filesystem.NewMemoryFileSystem()
+		for filename, contents := range request.Files {
+			if err := fs.WriteFile(filename, contents); err != nil {
+				return nil, err
+			}
+		}
+		return fs, nil
+	}
+
+	// Replace the entire contents of each file on disk (or, for standard
+	// input, which is not on disk, insert its contents into an empty file)
+	edits := map[string]*text.EditSet{}
+	for filename, contents := range request.Files {
+		size := 0
+		if info, err := os.Stat(filename); err == nil {
+			size = int(info.Size())
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		es := text.NewEditSet()
+		es.Add(&text.Extent{0, size}, contents)
+		edits[filename] = es
+	}
+	return filesystem.NewEditedFileSystem(filesystem.NewLocalFileSystem(), edits), nil
+}
+
+// isLocal reports whether the given FileSystem reads files from the local
+// disk, possibly with edits applied.
+func isLocal(fs filesystem.FileSystem) bool {
+	switch fs := fs.(type) {
+	case *filesystem.LocalFileSystem:
+		return true
+	case *filesystem.EditedFileSystem:
+		return isLocal(fs.BaseFS)
+	default:
+		return false
+	}
+}
+
+// onLocalDisk reports whether the given FileSystem reads the given file,
+// unedited, from the local disk.
+func onLocalDisk(fs filesystem.FileSystem, path string) bool {
+	if edited, ok := fs.(*filesystem.EditedFileSystem); ok {
+		if _, ok := edited.Edits[path]; ok {
+			return false
+		}
+		return onLocalDisk(edited.BaseFS, path)
+	}
+	_, ok := fs.(*filesystem.LocalFileSystem)
+	return ok
+}
+
+// A recordingFileSystem records the contents of every file read from it that
+// is not read, unedited, from the local disk.
+type recordingFileSystem struct {
+	filesystem.FileSystem
+	mutex sync.Mutex
+	files map[string]string
+}
+
+func (fs *recordingFileSystem) OpenFile(path string) (io.ReadCloser, error) {
+	reader, err := fs.FileSystem.OpenFile(path)
+	if err != nil || onLocalDisk(fs.FileSystem, path) {
+		return reader, err
+	}
+	defer reader.Close()
+	contents, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	fs.mutex.Lock()
+	fs.files[path] = string(contents)
+	fs.mutex.Unlock()
+	return ioutil.NopCloser(bytes.NewReader(contents)), nil
+}
+
+// sizes returns the type sizes for the current architecture, which the SSA
+// interpreter uses to emulate unsafe.Sizeof, etc.
+func sizes() types.Sizes {
+	switch runtime.GOARCH {
+	case "386", "arm":
+		return &types.StdSizes{WordSize: 4, MaxAlign: 4}
+	default:
+		return &types.StdSizes{WordSize: 8, MaxAlign: 8}
+	}
+}
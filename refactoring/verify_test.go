@@ -0,0 +1,175 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package refactoring_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/godoctor/godoctor/filesystem"
+	"github.com/godoctor/godoctor/refactoring"
+	"github.com/godoctor/godoctor/text"
+)
+
+func TestMain(m *testing.M) {
+	// The tests below restart this test binary to interpret tests
+	refactoring.RunTestsIfChild()
+	os.Exit(m.Run())
+}
+
+// verifyTesting is a minimal replacement for the standard library's runtime
+// and testing packages, which is all the SSA interpreter needs to run the
+// tests below.
+const verifyTesting = `-- runtime/runtime.go --
+package runtime
+
+type errorString string
+
+func (e errorString) Error() string { return string(e) }
+
+type MemStats struct{}
+
+var sizeof_C_MStats uintptr
+-- testing/testing.go --
+package testing
+
+import _ "runtime"
+
+type T struct{ failed bool }
+
+func (t *T) Fail() { t.failed = true }
+
+type B struct{}
+
+type InternalTest struct {
+	Name string
+	F    func(*T)
+}
+
+type InternalBenchmark struct {
+	Name string
+	F    func(*B)
+}
+
+type InternalExample struct {
+	Name   string
+	F      func()
+	Output string
+}
+
+func Main(matchString func(pat, str string) (bool, error), tests []InternalTest, benchmarks []InternalBenchmark, examples []InternalExample) {
+	for _, test := range tests {
+		t := &T{}
+		test.F(t)
+		if t.failed {
+			println("--- FAIL: " + test.Name + " (0.00s)")
+		} else {
+			println("--- PASS: " + test.Name + " (0.00s)")
+		}
+	}
+}
+`
+
+const verifyPkg = `-- calc/calc.go --
+package calc
+
+func Double(x int) int { return x * 2 }
+-- calc/calc_test.go --
+package calc
+
+import "testing"
+
+func TestDouble(t *testing.T) {
+	if Double(2) != 4 {
+		t.Fail()
+	}
+}
+-- change.diff --
+--- /gopath/src/calc/calc.go
++++ /gopath/src/calc/calc.go
+@@ -1,3 +1,3 @@
+ package calc
+ 
+-func Double(x int) int { return x * 2 }
++func Double(x int) int { return x * 3 }
+`
+
+// runVerified runs the given refactoring on calc.go with Verify set.  The
+// files in extra are added to the file system.  If cancel is true, the
+// refactoring is canceled as soon as the original program's tests start.
+func runVerified(t *testing.T, r refactoring.Refactoring, extra string, cancel bool, line, col int, args ...interface{}) *refactoring.Log {
+	if runtime.GOOS == "windows" {
+		t.Skip("verification is not supported on Windows")
+	}
+	fs := filesystem.NewMemoryFileSystem()
+	goroot := filepath.Join(runtime.GOROOT(), "src")
+	if err := fs.LoadTxtar(goroot, verifyTesting); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.LoadTxtar("/gopath/src", verifyPkg+extra); err != nil {
+		t.Fatal(err)
+	}
+	config := &refactoring.Config{
+		FileSystem: fs,
+		Scope:      []string{"calc"},
+		Selection: &text.LineColSelection{
+			Filename:  "/gopath/src/calc/calc.go",
+			StartLine: line, StartCol: col, EndLine: line, EndCol: col,
+		},
+		Args:   args,
+		GoPath: "/gopath",
+		Verify: true,
+	}
+	if cancel {
+		ch := make(chan struct{})
+		config.Cancel = ch
+		config.Progress = func(task string, done, total int) {
+			if task == "Testing original program" && total == 1 && done == 0 {
+				close(ch)
+			}
+		}
+	}
+	return r.Run(config).Log
+}
+
+func TestVerifyUnchanged(t *testing.T) {
+	log := runVerified(t, new(refactoring.Rename), "", false, 3, 6, "Twice")
+	if log.ContainsErrors() {
+		t.Fatalf("Unexpected errors:\n%s", log)
+	}
+	if !strings.Contains(log.String(), "Verified that the outcomes of 1 tests are unchanged") {
+		t.Fatalf("Behavior was not verified:\n%s", log)
+	}
+}
+
+func TestVerifyChanged(t *testing.T) {
+	log := runVerified(t, new(refactoring.ApplyPatch), "", false, 1, 1,
+		"/gopath/src/change.diff", false)
+	if !strings.Contains(log.String(), "TestDouble passes before the refactoring but fails after it") {
+		t.Fatalf("Changed behavior was not detected:\n%s", log)
+	}
+}
+
+func TestVerifyCanceled(t *testing.T) {
+	// The interpreted test never finishes, so the refactoring completes
+	// only if canceling it stops the tests
+	forever := `-- calc/forever_test.go --
+package calc
+
+import "testing"
+
+func TestForever(t *testing.T) {
+	for {
+	}
+}
+`
+	log := runVerified(t, new(refactoring.Rename), forever, true, 3, 6, "Twice")
+	if !strings.Contains(log.String(), refactoring.ErrCanceled.Error()) {
+		t.Fatalf("Refactoring was not canceled:\n%s", log)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file defines ColumnMode, which determines how the characters in a line
+// are counted when a position is described by a line and column number.
+
+package text
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+// A ColumnMode determines how column numbers are counted.  The Go Doctor
+// (like the go/token package) counts columns in bytes, but many editors count
+// characters (runes), and the Language Server Protocol counts UTF-16 code
+// units.  These differ only on lines containing non-ASCII text.
+type ColumnMode int
+
+const (
+	ByteColumns  ColumnMode = iota // columns count bytes (UTF-8 code units)
+	RuneColumns                    // columns count Unicode code points
+	UTF16Columns                   // columns count UTF-16 code units
+)
+
+// ParseColumnMode returns the ColumnMode named by the given string: "bytes",
+// "runes", or "utf16".
+func ParseColumnMode(name string) (ColumnMode, error) {
+	switch name {
+	case "bytes":
+		return ByteColumns, nil
+	case "runes":
+		return RuneColumns, nil
+	case "utf16":
+		return UTF16Columns, nil
+	default:
+		return ByteColumns, fmt.Errorf("invalid column mode %s (must be "+
+			"\"bytes\", \"runes\", or \"utf16\")", name)
+	}
+}
+
+func (m ColumnMode) String() string {
+	switch m {
+	case RuneColumns:
+		return "runes"
+	case UTF16Columns:
+		return "utf16"
+	default:
+		return "bytes"
+	}
+}
+
+// width returns the number of columns occupied by the given rune, which was
+// encoded in size bytes.
+func (m ColumnMode) width(r rune, size int) int {
+	switch m {
+	case RuneColumns:
+		return 1
+	case UTF16Columns:
+		if r >= 0x10000 && r <= utf8.MaxRune {
+			return 2 // Surrogate pair
+		}
+		return 1
+	default:
+		return size
+	}
+}
+
+// Column returns the column number of the character following the given
+// text, which is the text on a line preceding some position.  Columns are
+// 1-based, so Column returns 1 if prefix is empty.
+func (m ColumnMode) Column(prefix []byte) int {
+	col := 1
+	for len(prefix) > 0 {
+		r, size := utf8.DecodeRune(prefix)
+		col += m.width(r, size)
+		prefix = prefix[size:]
+	}
+	return col
+}
+
+// Position returns the 1-based line and column number of the given byte
+// offset in the given contents.
+func (m ColumnMode) Position(contents []byte, offset int) (line, col int) {
+	lineStart := bytes.LastIndexByte(contents[:offset], '\n') + 1
+	line = bytes.Count(contents[:lineStart], []byte{'\n'}) + 1
+	return line, m.Column(contents[lineStart:offset])
+}
+
+// ByteColumn converts the given column number on the given line (which may
+// include a trailing newline) into a column number counted in bytes.  It
+// returns an error if the column is past the end of the line, or if it is in
+// the middle of a character (e.g., between the two halves of a UTF-16
+// surrogate pair).
+func (m ColumnMode) ByteColumn(line []byte, col int) (int, error) {
+	if col < 1 {
+		return 0, fmt.Errorf("Invalid column %d", col)
+	}
+	offset, c := 0, 1
+	for c < col && offset < len(line) {
+		r, size := utf8.DecodeRune(line[offset:])
+		c += m.width(r, size)
+		offset += size
+	}
+	if c != col {
+		return 0, fmt.Errorf("Invalid column %d (%s)", col, m)
+	}
+	return offset + 1, nil
+}
+
+// lineText returns the text of the given (1-based) line in the given
+// contents, including its trailing newline, if any.
+func lineText(contents []byte, line int) ([]byte, error) {
+	start := 0
+	for l := 1; l < line; l++ {
+		i := bytes.IndexByte(contents[start:], '\n')
+		if i < 0 {
+			return nil, fmt.Errorf("Invalid line %d", line)
+		}
+		start += i + 1
+	}
+	end := len(contents)
+	if i := bytes.IndexByte(contents[start:], '\n'); i >= 0 {
+		end = start + i + 1
+	}
+	return contents[start:end], nil
+}
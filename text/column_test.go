@@ -0,0 +1,118 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package text_test
+
+import (
+	"go/token"
+	"testing"
+
+	"github.com/godoctor/godoctor/text"
+)
+
+// On the third line, x follows two non-ASCII characters: é (two bytes, one
+// UTF-16 code unit) and 😀 (four bytes, two UTF-16 code units)
+const columnSrc = "package p\n\nvar é, 😀, x int\n"
+
+func TestColumnMode(t *testing.T) {
+	for _, name := range []string{"bytes", "runes", "utf16"} {
+		mode, err := text.ParseColumnMode(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode.String() != name {
+			t.Fatalf("Expected %s, got %s", name, mode)
+		}
+	}
+	if _, err := text.ParseColumnMode("chars"); err == nil {
+		t.Fatal("ParseColumnMode should have failed")
+	}
+
+	line := []byte("var é, 😀, x int\n")
+	prefix := line[:14] // "var é, 😀, "
+	tests := []struct {
+		mode   text.ColumnMode
+		column int
+	}{
+		{text.ByteColumns, 15},
+		{text.RuneColumns, 11},
+		{text.UTF16Columns, 12},
+	}
+	for _, test := range tests {
+		if col := test.mode.Column(prefix); col != test.column {
+			t.Fatalf("%s: expected column %d, got %d",
+				test.mode, test.column, col)
+		}
+		byteCol, err := test.mode.ByteColumn(line, test.column)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if byteCol != 15 {
+			t.Fatalf("%s: expected byte column 15, got %d",
+				test.mode, byteCol)
+		}
+		l, c := test.mode.Position([]byte(columnSrc), 11+14)
+		if l != 3 || c != test.column {
+			t.Fatalf("%s: expected 3:%d, got %d:%d",
+				test.mode, test.column, l, c)
+		}
+	}
+
+	// Column 9 is between the two halves of the surrogate pair
+	if _, err := text.UTF16Columns.ByteColumn(line, 9); err == nil {
+		t.Fatal("ByteColumn should fail inside a surrogate pair")
+	}
+	if _, err := text.RuneColumns.ByteColumn(line, 18); err == nil {
+		t.Fatal("ByteColumn should fail past the end of the line")
+	}
+}
+
+func TestLineColSelectionColumns(t *testing.T) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("p.go", fset.Base(), len(columnSrc))
+	file.SetLinesForContent([]byte(columnSrc))
+	tests := []struct {
+		mode          text.ColumnMode
+		start, end    int
+		expectedText  string
+		expectedError bool
+	}{
+		{text.RuneColumns, 11, 11, "x", false},
+		{text.UTF16Columns, 12, 12, "x", false},
+		{text.RuneColumns, 8, 8, "😀", false},
+		{text.UTF16Columns, 5, 5, "é", false},
+		{text.UTF16Columns, 9, 10, "", true},
+		{text.RuneColumns, 11, 30, "", true},
+	}
+	for _, test := range tests {
+		lc := &text.LineColSelection{
+			Filename:  "p.go",
+			StartLine: 3,
+			StartCol:  test.start,
+			EndLine:   3,
+			EndCol:    test.end,
+			Columns:   test.mode,
+		}
+		if _, _, err := lc.Convert(fset); err == nil {
+			t.Fatalf("%v: Convert should fail before Resolve", lc)
+		}
+		err := lc.Resolve([]byte(columnSrc))
+		if test.expectedError {
+			if err == nil {
+				t.Fatalf("%v: Resolve should have failed", lc)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("%v: %s", lc, err)
+		}
+		start, end, err := lc.Convert(fset)
+		if err != nil {
+			t.Fatalf("%v: %s", lc, err)
+		}
+		actual := columnSrc[fset.Position(start).Offset:fset.Position(end).Offset]
+		if actual != test.expectedText {
+			t.Fatalf("%v: expected %q, got %q", lc, test.expectedText, actual)
+		}
+	}
+}
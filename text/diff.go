@@ -158,7 +158,8 @@ func offsetOfString(index int, ss []string) int {
 
 /* -=-=- Unified Diff Support =-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=- */
 
-// numCtxLines is the number of leading/trailing context lines in a unified diff
+// numCtxLines is the default number of leading/trailing context lines in a
+// unified diff
 const numCtxLines int = 3
 
 // A Patch is an object representing a unified diff.  It can be created from an
@@ -167,6 +168,7 @@ const numCtxLines int = 3
 type Patch struct {
 	filename string
 	hunks    []*hunk
+	context  int // Number of leading/trailing context lines
 }
 
 // IsEmpty returns true iff this patch contains no hunks
@@ -183,45 +185,124 @@ func (p *Patch) add(hunk *hunk) {
 // Write writes a unified diff to the given io.Writer.  The given filenames
 // are used in the diff output.
 func (p *Patch) Write(origFile, newFile string, origTime, newTime time.Time, out io.Writer) error {
-	if !p.IsEmpty() {
-		layout := ""
-		if !origTime.IsZero() || !newTime.IsZero() {
-			layout = "  2006-01-02 15:04:05 -0700"
-		}
+	return p.write(origFile, newFile, origTime, newTime, out, false)
+}
+
+// WriteColor is like Write, but it colors the diff using ANSI escape
+// sequences (for display on a terminal).  Deleted lines are red, added lines
+// are green, and the words that changed within a modified line are shown in
+// reverse video.
+func (p *Patch) WriteColor(origFile, newFile string, origTime, newTime time.Time, out io.Writer) error {
+	return p.write(origFile, newFile, origTime, newTime, out, true)
+}
+
+const (
+	ansiReset   = "\x1b[0m"
+	ansiBold    = "\x1b[1m"
+	ansiReverse = "\x1b[7m"
+	ansiNoRev   = "\x1b[27m"
+	ansiRed     = "\x1b[31m"
+	ansiGreen   = "\x1b[32m"
+	ansiCyan    = "\x1b[36m"
+)
+
+func (p *Patch) write(origFile, newFile string, origTime, newTime time.Time, out io.Writer, color bool) error {
+	if p.IsEmpty() {
+		return nil
+	}
+	hunks, err := p.Hunks()
+	if err != nil {
+		return err
+	}
+	layout := ""
+	if !origTime.IsZero() || !newTime.IsZero() {
+		layout = "  2006-01-02 15:04:05 -0700"
+	}
+	if color {
+		fmt.Fprintf(out, "%s--- %s%s%s\n%s+++ %s%s%s\n",
+			ansiBold, origFile, origTime.Format(layout), ansiReset,
+			ansiBold, newFile, newTime.Format(layout), ansiReset)
+	} else {
 		fmt.Fprintf(out, "--- %s%s\n+++ %s%s\n",
 			origFile, origTime.Format(layout),
 			newFile, newTime.Format(layout))
-		lineOffset := 0
-		for _, hunk := range p.hunks {
-			adjust, err := writeDiffHunk(hunk, lineOffset, out)
-			if err != nil {
-				return err
-			}
-			lineOffset += adjust
+	}
+	for _, hunk := range hunks {
+		if err := hunk.write(out, color); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-// writeDiffHunk writes a single hunk in unified diff format.  If the
-// edits in that hunk add lines, it returns the number of lines added; if the
-// edits delete lines, it returns a negative number indicating the number of
-// lines deleted (0 - number of lines deleted).  If the edits in the hunk do
-// not change the number of lines, returns 0.
-func writeDiffHunk(h *hunk, outputLineOffset int, out io.Writer) (int, error) {
+// A PatchHunk is a single hunk in a unified diff, as returned by
+// Patch.Hunks.
+type PatchHunk struct {
+	// The 1-based starting line and number of lines of the hunk in the
+	// original and new files, as given in the hunk header
+	// ("@@ -OrigStart,OrigLines +NewStart,NewLines @@")
+	OrigStart, OrigLines int
+	NewStart, NewLines   int
+	// The context (' '), deleted ('-'), and added ('+') lines in the hunk
+	Lines []*PatchLine
+}
+
+// A PatchLine is a single line of a unified diff hunk.
+type PatchLine struct {
+	Kind byte   // ' ' (context), '-' (deleted), or '+' (added)
+	Text string // Text of the line, including its trailing newline (if any)
+	// For a deleted line that was replaced by a similar added line (or
+	// vice versa), the regions of Text that differ between the two lines;
+	// otherwise, nil.  See WordDiff.
+	Changed []*Extent
+}
+
+// Hunks returns the hunks in this patch.  In each hunk, every run of deleted
+// lines followed immediately by added lines is compared word-by-word, and the
+// words that changed are recorded in the lines' Changed fields.
+func (p *Patch) Hunks() ([]*PatchHunk, error) {
+	result := make([]*PatchHunk, 0, len(p.hunks))
+	lineOffset := 0
+	for _, hunk := range p.hunks {
+		ph, err := hunkLines(hunk, lineOffset, p.context)
+		if err != nil {
+			return nil, err
+		}
+		highlightChanges(ph.Lines)
+		result = append(result, ph)
+		lineOffset += ph.NewLines - ph.OrigLines
+	}
+	return result, nil
+}
+
+// hunkLines computes the lines of a single hunk in unified diff format.  The
+// given line offset is the number of lines added (or, if negative, deleted)
+// by the preceding hunks, and context is the maximum number of trailing
+// context lines.
+func hunkLines(h *hunk, outputLineOffset int, context int) (*PatchHunk, error) {
 	// Determine the lines in this hunk before and after applying edits
-	origLines, newLines, err := computeLines(h)
+	origLines, newLines, err := computeLines(h, context)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	// Write the unified diff header
 	numOrigLines := lenWithoutLastIfEmpty(origLines)
 	numNewLines := lenWithoutLastIfEmpty(newLines)
-	if _, err = fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n",
-		h.startLine, numOrigLines,
-		h.startLine+outputLineOffset, numNewLines); err != nil {
-		return 0, err
+	result := &PatchHunk{
+		OrigStart: h.startLine,
+		OrigLines: numOrigLines,
+		NewStart:  h.startLine + outputLineOffset,
+		NewLines:  numNewLines,
+	}
+	// An empty range is identified by the line preceding it
+	if numOrigLines == 0 {
+		result.OrigStart--
+	}
+	if numNewLines == 0 {
+		result.NewStart--
+	}
+	addLine := func(kind byte, text string) {
+		result.Lines = append(result.Lines, &PatchLine{Kind: kind, Text: text})
 	}
 
 	// Create an iterator that will traverse deletions and additions
@@ -234,7 +315,7 @@ func writeDiffHunk(h *hunk, outputLineOffset int, out io.Writer) (int, error) {
 		if it.edit() == nil || it.edit().Offset > offset {
 			// This line was not affected by any edits
 			if i < len(origLines)-1 || line != "" {
-				fmt.Fprintf(out, " %s", origLines[i])
+				addLine(' ', origLines[i])
 			}
 		} else {
 			// This line was deleted (and possibly replaced by a
@@ -246,32 +327,92 @@ func writeDiffHunk(h *hunk, outputLineOffset int, out io.Writer) (int, error) {
 				edit := it.edit()
 				if edit.Length > 0 {
 					// Delete line
-					line := origLines[i]
-					fmt.Fprintf(out, "-%s", line)
-					if !strings.HasSuffix(line, "\n") {
-						fmt.Fprintf(out, "\n\\ No newline at end of file\n")
-					}
+					addLine('-', origLines[i])
 					deleted = true
 				} else if edit.replacement != "" {
 					// Insert line
-					repl := edit.replacement
-					fmt.Fprintf(out, "+%s", repl)
-					if !strings.HasSuffix(repl, "\n") {
-						fmt.Fprintf(out, "\n\\ No newline at end of file\n")
-					}
-
+					addLine('+', edit.replacement)
 				}
 				it.moveToNextEdit()
 			}
 			if !deleted {
 				if i < len(origLines)-1 || line != "" {
-					fmt.Fprintf(out, " %s", origLines[i])
+					addLine(' ', origLines[i])
 				}
 			}
 		}
 		offset += len(line)
 	}
-	return numNewLines - numOrigLines, nil
+	// Without trailing context, lines may be inserted after the last line
+	// (and an insertion into an empty file may span several lines)
+	for ; it.edit() != nil; it.moveToNextEdit() {
+		for _, line := range strings.SplitAfter(it.edit().replacement, "\n") {
+			if line != "" {
+				addLine('+', line)
+			}
+		}
+	}
+	return result, nil
+}
+
+// write writes this hunk in unified diff format, optionally using ANSI escape
+// sequences to color it.
+func (h *PatchHunk) write(out io.Writer, color bool) error {
+	header := fmt.Sprintf("@@ -%d,%d +%d,%d @@",
+		h.OrigStart, h.OrigLines, h.NewStart, h.NewLines)
+	if color {
+		header = ansiCyan + header + ansiReset
+	}
+	if _, err := fmt.Fprintln(out, header); err != nil {
+		return err
+	}
+	for _, line := range h.Lines {
+		if line.Kind == ' ' {
+			if _, err := fmt.Fprintf(out, " %s", line.Text); err != nil {
+				return err
+			}
+			continue
+		}
+		text := strings.TrimSuffix(line.Text, "\n")
+		if color {
+			lineColor := ansiRed
+			if line.Kind == '+' {
+				lineColor = ansiGreen
+			}
+			text = lineColor + string(line.Kind) +
+				highlight(text, line.Changed) + ansiReset
+		} else {
+			text = string(line.Kind) + text
+		}
+		if _, err := fmt.Fprintln(out, text); err != nil {
+			return err
+		}
+		if !strings.HasSuffix(line.Text, "\n") {
+			fmt.Fprintf(out, "\\ No newline at end of file\n")
+		}
+	}
+	return nil
+}
+
+// highlight surrounds the given regions of text with ANSI escape sequences
+// that turn reverse video on and off.  Regions beyond the end of the text
+// (i.e., in a trailing newline) are ignored.
+func highlight(text string, regions []*Extent) string {
+	var b bytes.Buffer
+	pos := 0
+	for _, r := range regions {
+		end := min(r.OffsetPastEnd(), len(text))
+		if r.Offset >= end {
+			continue
+		}
+		b.WriteString(text[pos:r.Offset])
+		b.WriteString(ansiReverse)
+		b.WriteString(text[r.Offset:end])
+		b.WriteString(ansiNoRev)
+		pos = end
+	}
+	b.WriteString(text[pos:])
+	return b.String()
 }
 
 // If the last string in the slice is the empty string, returns len(ss)-1;
@@ -285,9 +426,10 @@ func lenWithoutLastIfEmpty(ss []string) int {
 
 // computeLines computes the text that will result from applying the edits in
 // this hunk, then returns both the original text and the new text split into
-// lines on \n boundaries.  It returns a non-nil error if the edits in the hunk
-// cannot be applied.
-func computeLines(h *hunk) (origLines []string, newLines []string, err error) {
+// lines on \n boundaries, keeping at most the given number of trailing context
+// lines.  It returns a non-nil error if the edits in the hunk cannot be
+// applied.
+func computeLines(h *hunk, context int) (origLines []string, newLines []string, err error) {
 	hunk := h.hunk.String()
 	newText, err := ApplyToString(&EditSet{edits: h.edits}, hunk)
 	if err != nil {
@@ -307,7 +449,7 @@ func computeLines(h *hunk) (origLines []string, newLines []string, err error) {
 			break
 		}
 	}
-	linesToRemove := max(trailingCtxLines-numCtxLines, 0)
+	linesToRemove := max(trailingCtxLines-context, 0)
 
 	origLines = origLines[:numOrig-linesToRemove]
 	newLines = newLines[:numNew-linesToRemove]
@@ -358,10 +500,12 @@ func (h *hunk) addEdit(e *edit) {
 
 // A lineRdr reads lines, one at a time, from an io.Reader, keeping track of
 // the 0-based offset and 1-based line number of the line.  It also keeps
-// track of the previous numCtxLines lines that were read.  (This is used to
-// create leading context for a unified diff hunk.)
+// track of the previous context lines that were read (numCtxLines, by
+// default).  (This is used to create leading context for a unified diff
+// hunk.)
 type lineRdr struct {
 	reader          *bufio.Reader
+	context         int
 	line            string
 	lineOffset      int
 	lineNum         int
@@ -371,14 +515,14 @@ type lineRdr struct {
 
 // newLineRdr creates a new lineRdr that reads from the given io.Reader.
 func newLineRdr(in io.Reader) *lineRdr {
-	return &lineRdr{reader: bufio.NewReader(in)}
+	return &lineRdr{reader: bufio.NewReader(in), context: numCtxLines}
 }
 
 // readLine reads a single line from the wrapped io.Reader.  When the end of
 // the input is reached, it returns io.EOF.
 func (l *lineRdr) readLine() error {
-	if l.lineNum > 0 {
-		if len(l.leadingCtxLines) == numCtxLines {
+	if l.lineNum > 0 && l.context > 0 {
+		if len(l.leadingCtxLines) == l.context {
 			l.leadingCtxLines = l.leadingCtxLines[1:]
 		}
 		l.leadingCtxLines = append(l.leadingCtxLines, l.line)
@@ -432,8 +576,8 @@ func (l *lineRdr) nextLineIsAffectedBy(e *edit) bool {
 	}
 }
 
-// startHunk creats a new hunk, adding the current line and up to numCtxLines
-// lines of leading context.
+// startHunk creats a new hunk, adding the current line and up to the
+// lineRdr's number of context lines of leading context.
 func startHunk(lr *lineRdr) *hunk {
 	h := &hunk{
 		startOffset: lr.lineOffset,
@@ -479,22 +623,24 @@ func (e *editIter) moveToNextEdit() *edit {
 	return e.edit()
 }
 
-// createPatch creates a Patch from an EditSet.  (The CreatePatch method on
-// EditSet delegates to this function.)
-func createPatch(e *EditSet, in io.Reader) (result *Patch, err error) {
-	result = &Patch{}
+// createPatch creates a Patch from an EditSet, with the given number of
+// leading and trailing context lines in each hunk.  (The CreatePatch and
+// CreatePatchWithContext methods on EditSet delegate to this function.)
+func createPatch(e *EditSet, in io.Reader, context int) (result *Patch, err error) {
+	result = &Patch{context: context}
 
 	if len(e.edits) == 0 {
 		return
 	}
 
 	reader := newLineRdr(in) // Reads lines from the original file
+	reader.context = context
 	it := e.newEditIter()    // Traverses edits (in order)
 	var hunk *hunk           // Current hunk being added to
 	var trailingCtxLines int // Number of unchanged lines at end of hunk
 
 	// Iterate through each line, adding lines to a hunk if they are
-	// affected by an edit or at most 2*numCtxLines following an edit;
+	// affected by an edit or at most 2*context following an edit;
 	// add edits to the hunk whenever the last offset affected by that edit
 	// is on the current line
 	for err = reader.readLine(); err == nil || err == io.EOF; err = reader.readLine() {
@@ -528,7 +674,7 @@ func createPatch(e *EditSet, in io.Reader) (result *Patch, err error) {
 				}
 			} else {
 				trailingCtxLines++
-				if trailingCtxLines > 2*numCtxLines {
+				if trailingCtxLines > 2*context {
 					result.add(hunk)
 					hunk = nil
 				}
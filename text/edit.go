@@ -109,6 +109,16 @@ func NewEditSet() *EditSet {
 	return &EditSet{edits: []edit{}}
 }
 
+// Clone returns a copy of this EditSet.  Edits at the same offset keep their
+// order (which Add alone would not preserve).
+func (e *EditSet) Clone() *EditSet {
+	result := &EditSet{edits: make([]edit, len(e.edits))}
+	for i, ed := range e.edits {
+		result.edits[i] = edit{&Extent{ed.Offset, ed.Length}, ed.replacement}
+	}
+	return result
+}
+
 // RelativeToOffset returns a new edit whose offset is the offset of this edit
 // minus the given offset, i.e., it is an edit relative to the given offset.
 func (e *edit) RelativeToOffset(offset int) edit {
@@ -287,7 +297,18 @@ func (e *EditSet) applyTo(in *bufio.Reader, out *bufio.Writer) error {
 // CreatePatch creates a Patch from this EditSet.  A Patch can be output as a
 // unified diff by invoking the Patch's Write method.
 func (e *EditSet) CreatePatch(in io.Reader) (result *Patch, err error) {
-	return createPatch(e, in)
+	return createPatch(e, in, numCtxLines)
+}
+
+// CreatePatchWithContext is like CreatePatch, but each hunk in the Patch will
+// have the given number of lines of leading and trailing context (rather than
+// the usual 3).
+func (e *EditSet) CreatePatchWithContext(in io.Reader, context int) (result *Patch, err error) {
+	if context < 0 {
+		return nil, fmt.Errorf("number of context lines must be "+
+			"nonnegative (%d)", context)
+	}
+	return createPatch(e, in, context)
 }
 
 // ApplyToString reads bytes from a string, applying the edits in an EditSet
@@ -138,6 +138,17 @@ Replace offset 5, length 6 with "x"
 `, es.String(), t)
 }
 
+func TestEditClone(t *testing.T) {
+	es := NewEditSet()
+	es.Add(&Extent{0, 0}, "y")
+	es.Add(&Extent{0, 1}, "x")
+	clone := es.Clone()
+	es.Add(&Extent{3, 0}, "z")
+	assertEquals(`Replace offset 0, length 1 with "x"
+Replace offset 0, length 0 with "y"
+`, clone.String(), t)
+}
+
 func TestOverlap(t *testing.T) {
 	type test struct {
 		offset, length  int
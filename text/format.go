@@ -0,0 +1,89 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file defines FileFormat, which describes a file's line endings and
+// byte order mark, so that they can be preserved when the file is edited.
+
+package text
+
+import "strings"
+
+// utf8BOM is the UTF-8 encoding of the byte order mark (U+FEFF).
+const utf8BOM = "\uFEFF"
+
+// A FileFormat describes the conventions used in a text file that are not
+// significant to the Go parser but should be preserved when the file is
+// edited: its line endings and whether it begins with a byte order mark.
+// Tools like go/printer always produce "\n" line endings and no byte order
+// mark, so their output must be converted to match the original file.
+type FileFormat struct {
+	CRLF bool // Lines end with "\r\n" rather than "\n"
+	BOM  bool // The file begins with a UTF-8 byte order mark
+}
+
+// DetectFormat returns the FileFormat of the given file contents.  A file is
+// considered to have CRLF line endings if most of its lines end with "\r\n".
+func DetectFormat(contents string) FileFormat {
+	lines := strings.Count(contents, "\n")
+	crlf := strings.Count(contents, "\r\n")
+	return FileFormat{
+		CRLF: lines > 0 && crlf*2 > lines,
+		BOM:  strings.HasPrefix(contents, utf8BOM),
+	}
+}
+
+// Apply converts the given text, which may have "\n" or "\r\n" line endings
+// and may or may not begin with a byte order mark, to this format.
+func (f FileFormat) Apply(s string) string {
+	s = f.applyLineEndings(s)
+	if f.BOM && !strings.HasPrefix(s, utf8BOM) {
+		s = utf8BOM + s
+	} else if !f.BOM {
+		s = strings.TrimPrefix(s, utf8BOM)
+	}
+	return s
+}
+
+// applyLineEndings converts every line ending in s to this format's.
+func (f FileFormat) applyLineEndings(s string) string {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	if f.CRLF {
+		s = strings.Replace(s, "\n", "\r\n", -1)
+	}
+	return s
+}
+
+// NormalizeEdits returns an EditSet equivalent to es in which the line endings
+// in replacement text match this format, and which does not remove or
+// duplicate the byte order mark (if any) at the start of the given contents.
+// It returns an error only if adjusting edits near the byte order mark causes
+// them to overlap.
+func (f FileFormat) NormalizeEdits(es *EditSet, contents string) (*EditSet, error) {
+	hasBOM := f.BOM && strings.HasPrefix(contents, utf8BOM)
+	result := NewEditSet()
+	var err error
+	es.Iterate(func(extent *Extent, replacement string) bool {
+		offset, length := extent.Offset, extent.Length
+		if hasBOM && offset < len(utf8BOM) {
+			// Keep the byte order mark; edit the text after it
+			end := max(offset+length, len(utf8BOM))
+			offset = len(utf8BOM)
+			length = end - offset
+			replacement = strings.TrimPrefix(replacement, utf8BOM)
+		}
+		replacement = f.applyLineEndings(replacement)
+		if f.CRLF && strings.HasPrefix(replacement, "\r\n") &&
+			offset > 0 && offset <= len(contents) &&
+			contents[offset-1] == '\r' {
+			// The edit begins between \r and \n
+			replacement = replacement[1:]
+		}
+		err = result.Add(&Extent{offset, length}, replacement)
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package text
+
+import "testing"
+
+func TestDetectFormat(t *testing.T) {
+	tests := map[string]FileFormat{
+		"":                        FileFormat{},
+		"a\nb\n":                  FileFormat{},
+		"a\r\nb\r\n":              FileFormat{CRLF: true},
+		"a\r\nb\r\nc\n":           FileFormat{CRLF: true},
+		"a\r\nb\nc\n":             FileFormat{},
+		"\uFEFFpackage p":         FileFormat{BOM: true},
+		"\uFEFFpackage p\r\n":     FileFormat{CRLF: true, BOM: true},
+		"package p // \uFEFF\r\n": FileFormat{CRLF: true},
+	}
+	for contents, expected := range tests {
+		if actual := DetectFormat(contents); actual != expected {
+			t.Fatalf("DetectFormat(%q): expected %+v, got %+v",
+				contents, expected, actual)
+		}
+	}
+}
+
+func TestFormatApply(t *testing.T) {
+	f := FileFormat{CRLF: true, BOM: true}
+	assertEquals("\uFEFFa\r\nb\r\n", f.Apply("a\nb\r\n"), t)
+	assertEquals("\uFEFFa\r\n", f.Apply("\uFEFFa\n"), t)
+	f = FileFormat{}
+	assertEquals("a\nb\n", f.Apply("\uFEFFa\r\nb\n"), t)
+}
+
+func TestNormalizeEdits(t *testing.T) {
+	contents := "\uFEFFpackage p\r\n\r\nvar x int\r\n"
+	es := NewEditSet()
+	// Replace the entire first line (including the BOM)
+	es.Add(&Extent{0, 12}, "package q\n// Comment")
+	// Insert a line after the \r on the second line
+	es.Add(&Extent{15, 0}, "\nvar y int\r")
+	// Rename x
+	es.Add(&Extent{20, 1}, "z")
+	format := DetectFormat(contents)
+	normalized, err := format.NormalizeEdits(es, contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ApplyToString(normalized, contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEquals("\uFEFFpackage q\r\n// Comment\r\n\r\nvar y int\r\nvar z int\r\n",
+		result, t)
+
+	es = NewEditSet()
+	es.Add(&Extent{1, 2}, "a\r\nb")
+	normalized, err = FileFormat{}.NormalizeEdits(es, "xyz\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _ = ApplyToString(normalized, "xyz\n")
+	assertEquals("xa\nb\n", result, t)
+}
@@ -0,0 +1,140 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file contains support for writing patches in the format produced by
+// "git diff", which can be applied using "git apply" or "patch -p1".  In
+// addition to the unified diff, this format includes extended header lines
+// that identify new and renamed files and the (abbreviated) git object hashes
+// of each file's contents before and after the change.
+
+package text
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// gitFileMode is the mode given in git extended headers; the Go Doctor only
+// creates and modifies regular, non-executable files.
+const gitFileMode = "100644"
+
+// A GitDiff describes the changes to a single file in a git-format patch.
+type GitDiff struct {
+	// The file's name before and after the change, as slash-separated
+	// paths (usually relative to the root of the repository).  OrigFile is
+	// "" if the file is being created.  If OrigFile and NewFile differ, the
+	// file is being renamed.
+	OrigFile, NewFile string
+	// The contents of the file before the change ("" for a new file)
+	Orig string
+	// Edits to apply to Orig (may be nil if the file is renamed but not
+	// modified)
+	Edits *EditSet
+	// The number of lines of context to include in each hunk
+	Context int
+}
+
+// Write writes this GitDiff in git format, i.e., a "diff --git" line and
+// extended headers, followed by a unified diff using the a/ and b/ prefixes.
+// Nothing is written if the file is neither created, renamed, nor modified.
+func (d *GitDiff) Write(out io.Writer) error {
+	return d.write(out, false)
+}
+
+// WriteColor is like Write, but it colors the diff using ANSI escape
+// sequences, as described for Patch.WriteColor.
+func (d *GitDiff) WriteColor(out io.Writer) error {
+	return d.write(out, true)
+}
+
+func (d *GitDiff) write(out io.Writer, color bool) error {
+	edits := d.Edits
+	if edits == nil {
+		edits = NewEditSet()
+	}
+	newContents, err := ApplyToString(edits, d.Orig)
+	if err != nil {
+		return err
+	}
+	created := d.OrigFile == ""
+	origFile := d.OrigFile
+	if created {
+		origFile = d.NewFile
+	}
+	renamed := origFile != d.NewFile
+	modified := newContents != d.Orig
+	if !created && !renamed && !modified {
+		return nil
+	}
+
+	header := []string{fmt.Sprintf("diff --git a/%s b/%s", origFile, d.NewFile)}
+	if created {
+		header = append(header, "new file mode "+gitFileMode)
+	}
+	if renamed {
+		header = append(header,
+			fmt.Sprintf("similarity index %d%%", similarity(d.Orig, edits)),
+			"rename from "+origFile,
+			"rename to "+d.NewFile)
+	}
+	if created || modified {
+		origHash := strings.Repeat("0", 7)
+		if !created {
+			origHash = gitHash(d.Orig)
+		}
+		index := fmt.Sprintf("index %s..%s", origHash, gitHash(newContents))
+		if !created {
+			index += " " + gitFileMode
+		}
+		header = append(header, index)
+	}
+	for _, line := range header {
+		if color {
+			line = ansiBold + line + ansiReset
+		}
+		if _, err := fmt.Fprintln(out, line); err != nil {
+			return err
+		}
+	}
+
+	if !modified {
+		return nil
+	}
+	patch, err := edits.CreatePatchWithContext(strings.NewReader(d.Orig), d.Context)
+	if err != nil {
+		return err
+	}
+	origLabel := "a/" + origFile
+	if created {
+		origLabel = "/dev/null"
+	}
+	return patch.write(origLabel, "b/"+d.NewFile, time.Time{}, time.Time{}, out, color)
+}
+
+// gitHash returns the abbreviated hash git uses to identify a blob with the
+// given contents.
+func gitHash(contents string) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(contents))
+	io.WriteString(h, contents)
+	return fmt.Sprintf("%x", h.Sum(nil))[:7]
+}
+
+// similarity returns the percentage of the given text that is unchanged by
+// the given edits, relative to the size of the larger of the original and
+// modified texts.
+func similarity(orig string, edits *EditSet) int {
+	size := max(len(orig), len(orig)+int(edits.SizeChange()))
+	if size == 0 {
+		return 100
+	}
+	unchanged := len(orig)
+	for _, e := range edits.edits {
+		unchanged -= e.Length
+	}
+	return unchanged * 100 / size
+}
@@ -0,0 +1,147 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package text
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGitHash(t *testing.T) {
+	assertEquals("ce01362", gitHash("hello\n"), t)
+	assertEquals("e69de29", gitHash(""), t)
+}
+
+func TestGitDiffModify(t *testing.T) {
+	es := NewEditSet()
+	es.Add(&Extent{8, 4}, "p")
+	assertEquals(`diff --git a/dir/main.go b/dir/main.go
+index 06ab7d0..c89cd18 100644
+--- a/dir/main.go
++++ b/dir/main.go
+@@ -1,1 +1,1 @@
+-package main
++package p
+`, writeGitDiff(&GitDiff{
+		OrigFile: "dir/main.go",
+		NewFile:  "dir/main.go",
+		Orig:     "package main\n",
+		Edits:    es,
+		Context:  3,
+	}, t), t)
+
+	// Unchanged files are omitted
+	assertEquals("", writeGitDiff(&GitDiff{
+		OrigFile: "main.go",
+		NewFile:  "main.go",
+		Orig:     "package main\n",
+		Edits:    NewEditSet(),
+		Context:  3,
+	}, t), t)
+}
+
+func TestGitDiffNewFile(t *testing.T) {
+	es := NewEditSet()
+	es.Add(&Extent{0, 0}, "hello\n")
+	assertEquals(`diff --git a/hello.txt b/hello.txt
+new file mode 100644
+index 0000000..ce01362
+--- /dev/null
++++ b/hello.txt
+@@ -0,0 +1,1 @@
++hello
+`, writeGitDiff(&GitDiff{NewFile: "hello.txt", Edits: es, Context: 3}, t), t)
+}
+
+func TestGitDiffRename(t *testing.T) {
+	assertEquals(`diff --git a/old.go b/new.go
+similarity index 100%
+rename from old.go
+rename to new.go
+`, writeGitDiff(&GitDiff{
+		OrigFile: "old.go",
+		NewFile:  "new.go",
+		Orig:     "package main\n",
+		Context:  3,
+	}, t), t)
+
+	es := NewEditSet()
+	es.Add(&Extent{8, 4}, "p")
+	assertEquals(`diff --git a/old.go b/new.go
+similarity index 69%
+rename from old.go
+rename to new.go
+index 06ab7d0..c89cd18 100644
+--- a/old.go
++++ b/new.go
+@@ -1,1 +1,1 @@
+-package main
++package p
+`, writeGitDiff(&GitDiff{
+		OrigFile: "old.go",
+		NewFile:  "new.go",
+		Orig:     "package main\n",
+		Edits:    es,
+		Context:  3,
+	}, t), t)
+}
+
+func TestGitDiffContext(t *testing.T) {
+	es := NewEditSet()
+	es.Add(&Extent{4, 1}, "X")
+	assertEquals(`diff --git a/f b/f
+index 8a1218a..b098226 100644
+--- a/f
++++ b/f
+@@ -3,1 +3,1 @@
+-3
++X
+`, writeGitDiff(&GitDiff{
+		OrigFile: "f",
+		NewFile:  "f",
+		Orig:     "1\n2\n3\n4\n5\n",
+		Edits:    es,
+		Context:  0,
+	}, t), t)
+}
+
+func TestGitDiffContextInsert(t *testing.T) {
+	es := NewEditSet()
+	es.Add(&Extent{4, 0}, "x\ny\n")
+	assertEquals(`diff --git a/f b/f
+index d68dd40..195adfe 100644
+--- a/f
++++ b/f
+@@ -2,0 +3,2 @@
++x
++y
+`, writeGitDiff(&GitDiff{
+		OrigFile: "f",
+		NewFile:  "f",
+		Orig:     "a\nb\nc\nd\n",
+		Edits:    es,
+		Context:  0,
+	}, t), t)
+
+	es = NewEditSet()
+	es.Add(&Extent{0, 0}, "x\ny\n")
+	assertEquals(`diff --git a/f b/f
+new file mode 100644
+index 0000000..b77b4eb
+--- /dev/null
++++ b/f
+@@ -0,0 +1,2 @@
++x
++y
+`, writeGitDiff(&GitDiff{NewFile: "f", Edits: es, Context: 0}, t), t)
+}
+
+func writeGitDiff(d *GitDiff, t *testing.T) string {
+	var b bytes.Buffer
+	if err := d.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
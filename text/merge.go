@@ -0,0 +1,252 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file defines operations that combine EditSets: Compose, which chains
+// two EditSets (e.g., the results of two refactorings applied in sequence),
+// and Merge, which combines two EditSets made independently against the same
+// text (e.g., a refactoring's changes and a user's edits to an editor buffer
+// made while the refactoring was running).
+
+package text
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+/* -=-=- Compose -=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=- */
+
+// A span is an edit described in terms of the text produced by applying an
+// EditSet: [start, end) is the region of the output it affects.  For an edit
+// in the first EditSet given to Compose, this is the region containing its
+// replacement text; for an edit in the second, it is the region it replaces.
+type span struct {
+	start, end int
+	edit       edit
+	second     bool
+}
+
+// Compose returns a single EditSet that has the same effect as applying a
+// and then applying b, where the offsets in b refer to the text produced by
+// applying a.  The offsets in the result refer to the original text (the
+// text a applies to).
+//
+// Where edits in b modify text inserted by a (or are adjacent to it), the
+// edits are combined, so the result may contain fewer, larger edits than a
+// and b together.
+func Compose(a, b *EditSet) *EditSet {
+	// An edit that follows another edit at the same offset (e.g., an
+	// insertion after a replacement) takes effect where the preceding edit
+	// ends, so spans start at that point
+	spans := []span{}
+	delta, prevEnd := 0, 0
+	for _, e := range a.edits {
+		offset := max(e.Offset, prevEnd)
+		start := offset + delta
+		spans = append(spans, span{start, start + len(e.replacement), e, false})
+		delta += len(e.replacement) - e.Length
+		prevEnd = offset + e.Length
+	}
+	prevEnd = 0
+	for _, e := range b.edits {
+		start := max(e.Offset, prevEnd)
+		spans = append(spans, span{start, start + e.Length, e, true})
+		prevEnd = start + e.Length
+	}
+	sort.Stable(byStart(spans))
+
+	result := NewEditSet()
+	delta = 0 // Size change due to edits in a preceding the current group
+	for i := 0; i < len(spans); {
+		// Group spans that overlap or touch, since their edits cannot be
+		// expressed independently against the original text
+		start, end := spans[i].start, spans[i].end
+		j := i + 1
+		for j < len(spans) && spans[j].start <= end {
+			end = max(end, spans[j].end)
+			j++
+		}
+		group := spans[i:j]
+		i = j
+
+		// Reconstruct the text that replaces this group: a's replacement
+		// text, except where b's edits replace or insert text
+		firsts, seconds := []span{}, []span{}
+		groupDelta := 0
+		for _, s := range group {
+			if s.second {
+				seconds = append(seconds, s)
+			} else {
+				firsts = append(firsts, s)
+				groupDelta += len(s.edit.replacement) - s.edit.Length
+			}
+		}
+		var replacement bytes.Buffer
+		pos, ai, bi := start, 0, 0
+		for {
+			if bi < len(seconds) && seconds[bi].start == pos {
+				replacement.WriteString(seconds[bi].edit.replacement)
+				pos = seconds[bi].end
+				bi++
+				continue
+			}
+			for ai < len(firsts) && firsts[ai].end <= pos {
+				ai++
+			}
+			if pos >= end || ai == len(firsts) || firsts[ai].start > pos {
+				break
+			}
+			a := firsts[ai]
+			next := a.end
+			if bi < len(seconds) && seconds[bi].start < next {
+				next = seconds[bi].start
+			}
+			replacement.WriteString(a.edit.replacement[pos-a.start : next-a.start])
+			pos = next
+		}
+
+		origStart := start - delta
+		origEnd := end - delta - groupDelta
+		result.Add(&Extent{origStart, origEnd - origStart}, replacement.String())
+		delta += groupDelta
+	}
+	return result
+}
+
+type byStart []span
+
+func (s byStart) Len() int           { return len(s) }
+func (s byStart) Less(i, j int) bool { return s[i].start < s[j].start }
+func (s byStart) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+/* -=-=- Merge -=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=- */
+
+// A MergeConflict describes a region of the base text that was changed in
+// different ways by the two EditSets given to Merge.
+type MergeConflict struct {
+	// The region of the base text affected by the conflicting edits
+	Extent
+	// The text of that region in the base text, and after applying the
+	// first and second EditSets' edits, respectively
+	Base, A, B string
+}
+
+func (c *MergeConflict) String() string {
+	return fmt.Sprintf("conflicting edits at %s: %q and %q (base %q)",
+		c.Extent.String(), c.A, c.B, c.Base)
+}
+
+// A MergeError is returned by Merge when the EditSets being merged contain
+// conflicting edits.  The conflicts are sorted by offset.
+type MergeError struct {
+	Conflicts []*MergeConflict
+}
+
+func (e *MergeError) Error() string {
+	if len(e.Conflicts) == 1 {
+		return e.Conflicts[0].String()
+	}
+	return fmt.Sprintf("%d conflicts; first: %s",
+		len(e.Conflicts), e.Conflicts[0].String())
+}
+
+// Merge combines two EditSets, a and b, made independently against the same
+// base text.  Edits that appear in both EditSets are included only once.
+//
+// Two edits conflict if they overlap, or if they start at the same offset
+// (since the order of two insertions at the same offset is ambiguous), and
+// they are not identical.  Merge returns an EditSet containing every edit
+// that does not conflict.  If there are conflicts, it also returns a
+// *MergeError describing each of them; in that case, the conflicting regions
+// are not modified by the returned EditSet.  An error is also returned if an
+// edit is beyond the end of the base text.
+func Merge(base string, a, b *EditSet) (*EditSet, error) {
+	spans := []span{}
+	for _, e := range a.edits {
+		spans = append(spans, span{e.Offset, e.OffsetPastEnd(), e, false})
+	}
+	for _, e := range b.edits {
+		if !a.contains(e) {
+			spans = append(spans, span{e.Offset, e.OffsetPastEnd(), e, true})
+		}
+	}
+	sort.Stable(byStart(spans))
+	for _, s := range spans {
+		if s.end > len(base) {
+			return nil, fmt.Errorf("edit at offset %d is beyond the "+
+				"end of the text (%d bytes)", s.start, len(base))
+		}
+	}
+
+	result := NewEditSet()
+	conflicts := []*MergeConflict{}
+	for i := 0; i < len(spans); {
+		// Group edits that conflict, directly or transitively
+		group := spans[i : i+1]
+		j := i + 1
+		for ; j < len(spans); j++ {
+			conflict := false
+			for _, s := range group {
+				if s.second != spans[j].second && spansConflict(s, spans[j]) {
+					conflict = true
+					break
+				}
+			}
+			if !conflict {
+				break
+			}
+			group = spans[i : j+1]
+		}
+		i = j
+
+		if len(group) == 1 {
+			result.Add(group[0].edit.Extent, group[0].edit.replacement)
+			continue
+		}
+
+		start, end := group[0].start, group[0].end
+		aEdits, bEdits := NewEditSet(), NewEditSet()
+		for _, s := range group {
+			start, end = min(start, s.start), max(end, s.end)
+		}
+		for _, s := range group {
+			relative := s.edit.RelativeToOffset(start)
+			if s.second {
+				bEdits.edits = append(bEdits.edits, relative)
+			} else {
+				aEdits.edits = append(aEdits.edits, relative)
+			}
+		}
+		region := base[start:end]
+		aText, _ := ApplyToString(aEdits, region)
+		bText, _ := ApplyToString(bEdits, region)
+		conflicts = append(conflicts, &MergeConflict{
+			Extent: Extent{start, end - start},
+			Base:   region,
+			A:      aText,
+			B:      bText,
+		})
+	}
+	if len(conflicts) > 0 {
+		return result, &MergeError{conflicts}
+	}
+	return result, nil
+}
+
+// spansConflict returns true iff the edits in two spans, which must be
+// expressed against the same text, conflict.
+func spansConflict(s, t span) bool {
+	return s.start == t.start || s.edit.Intersect(t.edit.Extent) != nil
+}
+
+// contains returns true iff this EditSet contains an edit identical to e.
+func (e *EditSet) contains(other edit) bool {
+	for _, edit := range e.edits {
+		if *edit.Extent == *other.Extent && edit.replacement == other.replacement {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package text
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestCompose(t *testing.T) {
+	orig := "abcdefghij"
+
+	a := NewEditSet()
+	a.Add(&Extent{1, 2}, "XYZ") // bc -> XYZ
+	a.Add(&Extent{6, 0}, "123") // insert before g
+	// a applied: aXYZdef123ghij
+
+	b := NewEditSet()
+	b.Add(&Extent{2, 1}, "")  // delete Y
+	b.Add(&Extent{5, 2}, "")  // delete ef
+	b.Add(&Extent{9, 0}, "_") // insert before 3
+	// b applied: aXZd12_3ghij
+
+	tmp, err := ApplyToString(a, orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ApplyToString(b, tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	composed := Compose(a, b)
+	actual, err := ApplyToString(composed, orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEquals(expected, actual, t)
+	assertEquals("aXZd12_3ghij", actual, t)
+}
+
+func TestComposeEmpty(t *testing.T) {
+	a := NewEditSet()
+	a.Add(&Extent{0, 3}, "xyz")
+	result, _ := ApplyToString(Compose(a, NewEditSet()), "abcdef")
+	assertEquals("xyzdef", result, t)
+	result, _ = ApplyToString(Compose(NewEditSet(), a), "abcdef")
+	assertEquals("xyzdef", result, t)
+}
+
+func TestComposeRandom(t *testing.T) {
+	seed := time.Now().Unix()
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < 1000; i++ {
+		orig := randomString(r.Intn(30), r)
+		a := randomEditSet(len(orig), r)
+		tmp, err := ApplyToString(a, orig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := randomEditSet(len(tmp), r)
+		expected, err := ApplyToString(b, tmp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := ApplyToString(Compose(a, b), orig)
+		if err != nil {
+			t.Fatalf("Seed %d, iteration %d: %s", seed, i, err)
+		}
+		if actual != expected {
+			t.Fatalf("Seed %d, iteration %d: composing\n%s\nand\n%s\n"+
+				"applied to %q gave %q; expected %q", seed, i,
+				a, b, orig, actual, expected)
+		}
+	}
+}
+
+func TestComposeSameOffset(t *testing.T) {
+	a := NewEditSet()
+	b := NewEditSet()
+	b.Add(&Extent{0, 0}, "Y")
+	b.Add(&Extent{0, 1}, "X")
+	result, _ := ApplyToString(Compose(a, b), "ab")
+	assertEquals("XYb", result, t)
+}
+
+func TestComposeRandomSameOffset(t *testing.T) {
+	seed := time.Now().Unix()
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < 1000; i++ {
+		orig := randomString(r.Intn(30), r)
+		a := randomEditSetSameOffset(len(orig), r)
+		tmp, err := ApplyToString(a, orig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := randomEditSetSameOffset(len(tmp), r)
+		expected, err := ApplyToString(b, tmp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := ApplyToString(Compose(a, b), orig)
+		if err != nil {
+			t.Fatalf("Seed %d, iteration %d: %s", seed, i, err)
+		}
+		if actual != expected {
+			t.Fatalf("Seed %d, iteration %d: composing\n%s\nand\n%s\n"+
+				"applied to %q gave %q; expected %q", seed, i,
+				a, b, orig, actual, expected)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base := "one two three four five"
+
+	a := NewEditSet()
+	a.Add(&Extent{0, 3}, "ONE")   // one -> ONE
+	a.Add(&Extent{8, 5}, "THREE") // three -> THREE
+
+	b := NewEditSet()
+	b.Add(&Extent{8, 5}, "THREE") // Same as a
+	b.Add(&Extent{19, 4}, "FIVE") // five -> FIVE
+
+	merged, err := Merge(base, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _ := ApplyToString(merged, base)
+	assertEquals("ONE two THREE four FIVE", result, t)
+}
+
+func TestMergeConflicts(t *testing.T) {
+	base := "one two three four five"
+
+	a := NewEditSet()
+	a.Add(&Extent{0, 3}, "ONE")  // one -> ONE
+	a.Add(&Extent{4, 3}, "TWO")  // two -> TWO
+	a.Add(&Extent{14, 0}, "and") // insert before four
+
+	b := NewEditSet()
+	b.Add(&Extent{4, 9}, "2 3")   // two three -> 2 3
+	b.Add(&Extent{14, 0}, "or")   // insert before four
+	b.Add(&Extent{19, 4}, "FIVE") // five -> FIVE
+
+	merged, err := Merge(base, a, b)
+	if err == nil {
+		t.Fatal("Expected conflicts")
+	}
+	merr, ok := err.(*MergeError)
+	if !ok {
+		t.Fatalf("Expected *MergeError, got %T", err)
+	}
+	if len(merr.Conflicts) != 2 {
+		t.Fatalf("Expected 2 conflicts, got %d", len(merr.Conflicts))
+	}
+
+	c := merr.Conflicts[0]
+	assertEquals("offset 4, length 9", c.Extent.String(), t)
+	assertEquals("two three", c.Base, t)
+	assertEquals("TWO three", c.A, t)
+	assertEquals("2 3", c.B, t)
+
+	c = merr.Conflicts[1]
+	assertEquals("offset 14, length 0", c.Extent.String(), t)
+	assertEquals("", c.Base, t)
+	assertEquals("and", c.A, t)
+	assertEquals("or", c.B, t)
+
+	result, _ := ApplyToString(merged, base)
+	assertEquals("ONE two three four FIVE", result, t)
+}
+
+func TestMergeOutOfBounds(t *testing.T) {
+	a := NewEditSet()
+	a.Add(&Extent{2, 5}, "x")
+	if _, err := Merge("abc", a, NewEditSet()); err == nil {
+		t.Fatal("Expected error for edit beyond end of text")
+	}
+}
+
+// randomEditSet returns an EditSet containing random, non-overlapping edits
+// to a string of the given length.
+func randomEditSet(length int, r *rand.Rand) *EditSet {
+	es := NewEditSet()
+	for offset := r.Intn(3); offset <= length; offset += 1 + r.Intn(4) {
+		size := r.Intn(min(3, length-offset) + 1)
+		es.Add(&Extent{offset, size}, randomString(r.Intn(4), r))
+		offset += size
+	}
+	return es
+}
+
+// randomEditSetSameOffset is like randomEditSet, but some replacements are
+// paired with an insertion at the same offset, added either before or after
+// the replacement (as the Rewriter does).
+func randomEditSetSameOffset(length int, r *rand.Rand) *EditSet {
+	es := NewEditSet()
+	for offset := r.Intn(3); offset <= length; offset += 1 + r.Intn(4) {
+		size := r.Intn(min(3, length-offset) + 1)
+		insert := size > 0 && r.Intn(2) == 0
+		if insert && r.Intn(2) == 0 {
+			es.Add(&Extent{offset, 0}, randomString(1+r.Intn(3), r))
+			insert = false
+		}
+		es.Add(&Extent{offset, size}, randomString(r.Intn(4), r))
+		if insert {
+			es.Add(&Extent{offset, 0}, randomString(1+r.Intn(3), r))
+		}
+		offset += size
+	}
+	return es
+}
+
+func randomString(length int, r *rand.Rand) string {
+	const chars = "abcdefghijklmnopqrstuvwxyz"
+	b := make([]byte, length)
+	for i := range b {
+		b[i] = chars[r.Intn(len(chars))]
+	}
+	return string(b)
+}
@@ -0,0 +1,301 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file contains a parser for unified diffs (i.e., patch files), which
+// converts each file's hunks back into an EditSet.  It accepts the output of
+// Patch.Write as well as diffs produced by GNU diff and git.  The format is
+// documented in the POSIX standard (see diff.go).
+
+package text
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A FileDiff is the portion of a unified diff that describes changes to a
+// single file.
+type FileDiff struct {
+	// The filenames given on the "---" and "+++" lines, without any
+	// timestamps.  A filename is /dev/null if the file is being created
+	// or deleted.
+	OrigFile string
+	NewFile  string
+	hunks    []*diffHunk
+}
+
+// A diffHunk is a single "@@ -l,s +l,s @@" hunk in a unified diff.
+type diffHunk struct {
+	origStart int        // First line of the hunk in the original file
+	origLines []string   // Context and deleted lines, \n-terminated
+	lines     []diffLine // All lines of the hunk, in order
+}
+
+// A diffLine is a single context (' '), deleted ('-'), or added ('+') line in
+// a diffHunk.  The text is \n-terminated unless it is the last line of a file
+// that did not end with a newline.
+type diffLine struct {
+	kind byte
+	text string
+}
+
+var (
+	hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+	timestamp  = regexp.MustCompile(`  \d{4}-\d\d-\d\d \d\d:\d\d:\d\d.*$`)
+)
+
+// ParsePatch reads a multi-file unified diff, returning one FileDiff for each
+// file it modifies.  Lines outside of file headers and hunks (e.g., "diff -u"
+// lines and git extended headers) are ignored.
+func ParsePatch(in io.Reader) ([]*FileDiff, error) {
+	result := []*FileDiff{}
+	reader := bufio.NewReader(in)
+	lineNum := 0
+	readLine := func() (string, error) {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		if err == nil {
+			lineNum++
+		}
+		return line, err
+	}
+
+	var file *FileDiff
+	var hunk *diffHunk
+	origLeft, newLeft := 0, 0
+	for {
+		line, err := readLine()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if hunk != nil && (origLeft > 0 || newLeft > 0) {
+			// Inside a hunk.  Some tools omit the leading space
+			// on blank context lines, which may end with \r\n
+			// (e.g., in a diff of files with CRLF line endings).
+			kind := byte(' ')
+			text := "\n"
+			if strings.TrimRight(line, "\r\n") != "" {
+				kind, text = line[0], line[1:]
+			} else if line != "" {
+				text = line
+			}
+			switch kind {
+			case ' ':
+				origLeft--
+				newLeft--
+			case '-':
+				origLeft--
+			case '+':
+				newLeft--
+			case '\\':
+				// "\ No newline at end of file"
+				if len(hunk.lines) > 0 {
+					last := &hunk.lines[len(hunk.lines)-1]
+					last.text = strings.TrimSuffix(last.text, "\n")
+				}
+				continue
+			default:
+				return nil, fmt.Errorf("line %d: invalid line in hunk", lineNum)
+			}
+			if origLeft < 0 || newLeft < 0 {
+				return nil, fmt.Errorf("line %d: hunk is longer than its header indicates", lineNum)
+			}
+			hunk.lines = append(hunk.lines, diffLine{kind, text})
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, `\`):
+			// "\ No newline at end of file" applies to the
+			// preceding line of the hunk
+			if hunk == nil || len(hunk.lines) == 0 {
+				return nil, fmt.Errorf("line %d: unexpected %s", lineNum, strings.TrimSpace(line))
+			}
+			last := &hunk.lines[len(hunk.lines)-1]
+			last.text = strings.TrimSuffix(last.text, "\n")
+
+		case strings.HasPrefix(line, "--- "):
+			next, err := readLine()
+			if err != nil || !strings.HasPrefix(next, "+++ ") {
+				return nil, fmt.Errorf("line %d: expected +++ line", lineNum)
+			}
+			file = &FileDiff{
+				OrigFile: headerFilename(line[4:]),
+				NewFile:  headerFilename(next[4:]),
+			}
+			result = append(result, file)
+			hunk = nil
+
+		case strings.HasPrefix(line, "@@ "):
+			if file == nil {
+				return nil, fmt.Errorf("line %d: hunk without --- and +++ lines", lineNum)
+			}
+			m := hunkHeader.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("line %d: invalid hunk header", lineNum)
+			}
+			hunk = &diffHunk{origStart: atoi(m[1], 0)}
+			origLeft, newLeft = atoi(m[2], 1), atoi(m[4], 1)
+			file.hunks = append(file.hunks, hunk)
+
+		default:
+			hunk = nil
+		}
+	}
+	if hunk != nil && (origLeft > 0 || newLeft > 0) {
+		return nil, fmt.Errorf("line %d: unexpected end of patch in hunk", lineNum)
+	}
+
+	for _, file := range result {
+		for _, hunk := range file.hunks {
+			for _, line := range hunk.lines {
+				if line.kind != '+' {
+					hunk.origLines = append(hunk.origLines, line.text)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// headerFilename returns the filename on a "---" or "+++" line, removing any
+// trailing timestamp.
+func headerFilename(s string) string {
+	s = strings.TrimRight(s, "\r\n")
+	if i := strings.Index(s, "\t"); i >= 0 {
+		s = s[:i]
+	}
+	return timestamp.ReplaceAllString(s, "")
+}
+
+// atoi converts s to an int, returning the given default if s is empty.
+func atoi(s string, dflt int) int {
+	if s == "" {
+		return dflt
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// Filename returns the name of the file this FileDiff modifies: NewFile,
+// unless the file is being deleted.  If both filenames start with the a/ and
+// b/ prefixes used by git, the prefix is removed.
+func (f *FileDiff) Filename() string {
+	orig, new := f.OrigFile, f.NewFile
+	if (orig == "/dev/null" || strings.HasPrefix(orig, "a/")) &&
+		(new == "/dev/null" || strings.HasPrefix(new, "b/")) {
+		orig = strings.TrimPrefix(orig, "a/")
+		new = strings.TrimPrefix(new, "b/")
+	}
+	if new == "/dev/null" {
+		return orig
+	}
+	return new
+}
+
+// EditSet returns an EditSet that makes the changes described by this
+// FileDiff when applied to the given file contents.
+//
+// If fuzzy is false, each hunk must match the contents exactly at the line
+// number given in its header.  If fuzzy is true, and a hunk does not match at
+// that line, nearby lines are searched (nearest first), and the hunk is
+// applied at the first location where its context and deleted lines match,
+// as long as that location follows the previous hunk.  An error is returned
+// if any hunk cannot be applied.
+func (f *FileDiff) EditSet(contents string, fuzzy bool) (*EditSet, error) {
+	lines := strings.SplitAfter(contents, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	offsets := make([]int, len(lines)+1)
+	for i, line := range lines {
+		offsets[i+1] = offsets[i] + len(line)
+	}
+
+	result := NewEditSet()
+	minLine := 0 // Hunks may not overlap preceding hunks
+	delta := 0   // Number of lines by which earlier hunks were displaced
+	for i, hunk := range f.hunks {
+		// Index of the first line of the hunk; a hunk that only adds
+		// lines gives the number of the line it follows
+		expected := hunk.origStart - 1
+		if len(hunk.origLines) == 0 {
+			expected = hunk.origStart
+		}
+		at := -1
+		if hunk.matches(lines, expected) && expected >= minLine {
+			at = expected
+		} else if fuzzy {
+			at = hunk.search(lines, expected+delta, minLine)
+		}
+		if at < 0 {
+			return nil, fmt.Errorf("hunk %d of %s does not apply at line %d",
+				i+1, f.Filename(), hunk.origStart)
+		}
+		delta = at - expected
+
+		// Each run of deleted and added lines becomes one edit
+		line := at
+		for j := 0; j < len(hunk.lines); {
+			if hunk.lines[j].kind == ' ' {
+				line++
+				j++
+				continue
+			}
+			start := line
+			replacement := ""
+			for ; j < len(hunk.lines) && hunk.lines[j].kind != ' '; j++ {
+				if hunk.lines[j].kind == '-' {
+					line++
+				} else {
+					replacement += hunk.lines[j].text
+				}
+			}
+			extent := &Extent{offsets[start], offsets[line] - offsets[start]}
+			if err := result.Add(extent, replacement); err != nil {
+				return nil, err
+			}
+		}
+		minLine = at + len(hunk.origLines)
+	}
+	return result, nil
+}
+
+// matches returns true iff this hunk's context and deleted lines match the
+// given lines, starting at the given index.
+func (h *diffHunk) matches(lines []string, at int) bool {
+	if at < 0 || at+len(h.origLines) > len(lines) {
+		return false
+	}
+	for i, line := range h.origLines {
+		if lines[at+i] != line {
+			return false
+		}
+	}
+	return true
+}
+
+// search returns the index nearest to the given index, but not less than
+// minLine, at which this hunk matches the given lines, or -1 if it does not
+// match anywhere.
+func (h *diffHunk) search(lines []string, near, minLine int) int {
+	for dist := 0; near-dist >= minLine || near+dist <= len(lines); dist++ {
+		if at := near - dist; at >= minLine && h.matches(lines, at) {
+			return at
+		}
+		if at := near + dist; at >= minLine && h.matches(lines, at) {
+			return at
+		}
+	}
+	return -1
+}
@@ -0,0 +1,185 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package text
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePatchTestdata(t *testing.T) {
+	testDirs, err := ioutil.ReadDir(diffTestDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, testDirInfo := range testDirs {
+		if testDirInfo.IsDir() {
+			dir := filepath.Join(diffTestDir, testDirInfo.Name())
+			from := readFile(filepath.Join(dir, "from.txt"), t)
+			to := readFile(filepath.Join(dir, "to.txt"), t)
+			diff := readFile(filepath.Join(dir, "diff.txt"), t)
+			if diff == "" {
+				continue
+			}
+			assertEquals(to, applyPatch(diff, from, false, t), t)
+		}
+	}
+}
+
+func TestParsePatchRandom(t *testing.T) {
+	seed := time.Now().Unix()
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < 100; i++ {
+		s1 := strings.Join(makeLines(100, r), "")
+		s2 := strings.Join(makeLines(100, r), "")
+		patch, err := Diff(strings.SplitAfter(s1, "\n"),
+			strings.SplitAfter(s2, "\n")).CreatePatch(strings.NewReader(s1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var diff bytes.Buffer
+		patch.Write("filename", "filename", time.Time{}, time.Time{}, &diff)
+		if result := applyPatch(diff.String(), s1, false, t); result != s2 {
+			t.Fatalf("Random patch failed - seed %d, iteration %d",
+				seed, i)
+		}
+	}
+}
+
+func TestParsePatchNoNewline(t *testing.T) {
+	diff := `--- a.txt  2015-01-02 03:04:05 -0600
++++ a.txt  2015-01-02 03:04:05 -0600
+@@ -1,2 +1,2 @@
+ one
+-two
+\ No newline at end of file
++TWO
+\ No newline at end of file
+`
+	assertEquals("one\nTWO", applyPatch(diff, "one\ntwo", false, t), t)
+}
+
+func TestParsePatchCRLF(t *testing.T) {
+	// The blank context line is "\r\n", without a leading space
+	diff := "--- a.txt\r\n" +
+		"+++ a.txt\r\n" +
+		"@@ -1,3 +1,3 @@\r\n" +
+		" one\r\n" +
+		"\r\n" +
+		"-two\r\n" +
+		"+TWO\r\n"
+	assertEquals("one\r\n\r\nTWO\r\n",
+		applyPatch(diff, "one\r\n\r\ntwo\r\n", false, t), t)
+}
+
+func TestParsePatchFuzzy(t *testing.T) {
+	diff := `--- a/file.txt
++++ b/file.txt
+@@ -2,3 +2,3 @@
+ b
+-c
++C
+ d
+@@ -6,3 +6,4 @@
+ f
+ g
++G
+ h
+`
+	orig := "a\nb\nc\nd\ne\nf\ng\nh\n"
+	assertEquals("a\nb\nC\nd\ne\nf\ng\nG\nh\n",
+		applyPatch(diff, orig, false, t), t)
+
+	shifted := "x\ny\n" + orig
+	files, err := ParsePatch(strings.NewReader(diff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := files[0].EditSet(shifted, false); err == nil {
+		t.Fatal("Strict matching should fail when lines are displaced")
+	}
+	assertEquals("x\ny\na\nb\nC\nd\ne\nf\ng\nG\nh\n",
+		applyPatch(diff, shifted, true, t), t)
+
+	if _, err := files[0].EditSet("a\nb\nX\nd\n", true); err == nil {
+		t.Fatal("Fuzzy matching should fail when context does not match")
+	}
+}
+
+func TestParsePatchMultipleFiles(t *testing.T) {
+	diff := `diff --git a/one.go b/one.go
+index 0123456..789abcd 100644
+--- a/one.go
++++ b/one.go
+@@ -1 +1 @@
+-package one
++package uno
+diff -u two.go two.go
+--- two.go	2015-01-02 03:04:05.000000000 -0600
++++ two.go	2015-01-02 03:04:05.000000000 -0600
+@@ -1 +1 @@
+-package two
++package dos
+--- a/three.go
++++ /dev/null
+@@ -1 +0,0 @@
+-package three
+`
+	files, err := ParsePatch(strings.NewReader(diff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("Expected 3 files, got %d", len(files))
+	}
+	assertEquals("one.go", files[0].Filename(), t)
+	assertEquals("two.go", files[1].Filename(), t)
+	assertEquals("three.go", files[2].Filename(), t)
+
+	es, err := files[1].EditSet("package two\n", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _ := ApplyToString(es, "package two\n")
+	assertEquals("package dos\n", result, t)
+}
+
+func TestParsePatchErrors(t *testing.T) {
+	invalid := []string{
+		"@@ -1 +1 @@\n-a\n+b\n",
+		"--- a\n@@ -1 +1 @@\n",
+		"--- a\n+++ a\n@@ -1 +1 @@\n-a\n",
+		"--- a\n+++ a\n@@ -1 +1 @@\n*a\n+b\n",
+	}
+	for _, diff := range invalid {
+		if _, err := ParsePatch(strings.NewReader(diff)); err == nil {
+			t.Fatalf("Expected error parsing:\n%s", diff)
+		}
+	}
+}
+
+func applyPatch(diff, contents string, fuzzy bool, t *testing.T) string {
+	files, err := ParsePatch(strings.NewReader(diff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 file in patch, got %d", len(files))
+	}
+	es, err := files[0].EditSet(contents, fuzzy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ApplyToString(es, contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
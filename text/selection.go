@@ -41,18 +41,69 @@ type Selection interface {
 // line and column, respectively.  Line and column numbers are 1-based.  The
 // end position is inclusive, so a LineColSelection always represents a
 // selection of at least one character.
+//
+// By default, columns are counted in bytes.  If Columns is RuneColumns or
+// UTF16Columns, the selection must be resolved against the contents of the
+// file (see Resolve) before it can be converted to positions.
 type LineColSelection struct {
 	Filename  string
 	StartLine int
 	StartCol  int
 	EndLine   int
 	EndCol    int
+	Columns   ColumnMode
+
+	resolved *LineColSelection // Set by Resolve (columns in bytes)
+}
+
+// Resolve converts this selection's columns into byte columns using the given
+// contents of the selected file.  It returns an error if a line or column is
+// not in range.  Resolve has no effect if columns are already counted in
+// bytes.
+func (lc *LineColSelection) Resolve(contents []byte) error {
+	lc.resolved = nil
+	if lc.Columns == ByteColumns {
+		return nil
+	}
+	startLine, err := lineText(contents, lc.StartLine)
+	if err != nil {
+		return err
+	}
+	startCol, err := lc.Columns.ByteColumn(startLine, lc.StartCol)
+	if err != nil {
+		return err
+	}
+	endLine, err := lineText(contents, lc.EndLine)
+	if err != nil {
+		return err
+	}
+	// The end column is inclusive, so select every byte of the character
+	// at that column
+	endCol, err := lc.Columns.ByteColumn(endLine, lc.EndCol+1)
+	if err != nil {
+		return err
+	}
+	lc.resolved = &LineColSelection{
+		Filename:  lc.Filename,
+		StartLine: lc.StartLine,
+		StartCol:  startCol,
+		EndLine:   lc.EndLine,
+		EndCol:    endCol - 1,
+	}
+	return nil
 }
 
 // Convert returns start and end positions corresponding to this selection.  It
 // returns an error if this selection corresponds to a file that is not in the
 // given FileSet, or if the selected region is not in range.
 func (lc *LineColSelection) Convert(fset *token.FileSet) (token.Pos, token.Pos, error) {
+	if lc.Columns != ByteColumns {
+		if lc.resolved == nil {
+			return 0, 0, fmt.Errorf("The selection %v has not been "+
+				"resolved", lc)
+		}
+		return lc.resolved.Convert(fset)
+	}
 	file := findFile(fset, lc.Filename)
 	if file == nil {
 		return 0, 0, fmt.Errorf(fileNotFoundFmt, lc.Filename)
@@ -87,6 +138,11 @@ func (lc *LineColSelection) GetFilename() string {
 }
 
 func (lc *LineColSelection) String() string {
+	if lc.Columns != ByteColumns {
+		return fmt.Sprintf("%s: %d,%d:%d,%d (%s)", lc.Filename,
+			lc.StartLine, lc.StartCol, lc.EndLine, lc.EndCol,
+			lc.Columns)
+	}
 	return fmt.Sprintf("%s: %d,%d:%d,%d", lc.Filename,
 		lc.StartLine, lc.StartCol, lc.EndLine, lc.EndCol)
 }
@@ -204,10 +260,14 @@ func lineColToPos(file *token.File, line int, column int) (token.Pos, error) {
 	return pos, nil
 }
 
-// NewSelection takes an input string of the form "line,col:line,col" or
-// "offset,length" and returns a Selection (either LineColSelection or
-// OffsetLengthSelection) corresponding to that selection in the given file.
+// NewSelection takes an input string of the form "line,col:line,col",
+// "offset,length", or a qualified name (such as "pkg/path.Type.Method") and
+// returns a Selection (LineColSelection, OffsetLengthSelection, or
+// SymbolSelection) corresponding to that selection in the given file.
 func NewSelection(filename string, pos string) (Selection, error) {
+	if IsSymbol(pos) {
+		return NewSymbolSelection(filename, pos)
+	}
 	if ok, _ := regexp.MatchString("^\\d+,\\d+:\\d+,\\d+$", pos); ok {
 		args := strings.Split(pos, ":")
 		sl, sc := parseLineCol(args[0])
@@ -0,0 +1,309 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file defines SymbolSelection, a Selection that identifies a
+// declaration by its qualified name rather than by its position in a file.
+
+package text
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"regexp"
+	"strings"
+)
+
+// symbolRegexp matches a qualified name: an import path (or package name),
+// followed by one or more dot-separated identifiers, optionally followed by
+// # and the name of a parameter.
+var symbolRegexp = regexp.MustCompile(`^((?:[^\s/#]+/)*[^\s/.#]+)((?:\.[\pL_][\pL\pN_]*)+)(?:#([\pL_][\pL\pN_]*))?$`)
+
+// A SymbolSelection is a Selection that identifies a declaration by name.  Its
+// Symbol is an import path (or package name), followed by the name of a
+// package-level declaration, optionally followed by the name of a method or
+// field, and optionally followed by # and the name of a parameter or result.
+// For example:
+//
+//	github.com/godoctor/godoctor/text.EditSet       (a type)
+//	github.com/godoctor/godoctor/text.EditSet.Add   (a method)
+//	text.Extent.Offset                              (a struct field)
+//	text.NewSelection#pos                           (a parameter)
+//
+// A SymbolSelection cannot be converted to positions until it has been
+// resolved against a loaded program (see Resolve); it then selects the name
+// in the declaration.
+type SymbolSelection struct {
+	// A file in the package containing the declaration, used to determine
+	// the scope of the refactoring before the program is loaded
+	Filename string
+	// The qualified name, as given by the user
+	Symbol string
+	// The components of Symbol: the import path (or package name), the
+	// names of the declaration and any method or field, and the name of
+	// the parameter ("" if none)
+	Package string
+	Names   []string
+	Param   string
+
+	resolved *OffsetLengthSelection // Set by Resolve
+}
+
+// A SymbolPackage describes a package in a loaded program, against which a
+// SymbolSelection can be resolved.
+type SymbolPackage struct {
+	Path    string      // Import path
+	Name    string      // Package name
+	Initial bool        // True iff the package was requested (not a dependency)
+	Files   []*ast.File // Syntax trees for the package's files
+}
+
+// IsSymbol returns true iff the given string has the syntax of a qualified
+// name accepted by NewSymbolSelection.
+func IsSymbol(symbol string) bool {
+	return symbolRegexp.MatchString(symbol)
+}
+
+// NewSymbolSelection returns a SymbolSelection for the given qualified name.
+// The given filename should be a file in the package containing the
+// declaration; it is returned by GetFilename until the selection is resolved.
+func NewSymbolSelection(filename, symbol string) (*SymbolSelection, error) {
+	m := symbolRegexp.FindStringSubmatch(symbol)
+	if m == nil {
+		return nil, fmt.Errorf("invalid symbol %s", symbol)
+	}
+	return &SymbolSelection{
+		Filename: filename,
+		Symbol:   symbol,
+		Package:  m[1],
+		Names:    strings.Split(m[2][1:], "."),
+		Param:    m[3],
+	}, nil
+}
+
+// Resolve finds the declaration named by this selection in the given
+// packages.  The package is identified by its import path or, if no package
+// has that path, by its name (preferring initial packages if several have the
+// same name).  It returns an error if the package or declaration cannot be
+// found.
+func (s *SymbolSelection) Resolve(fset *token.FileSet, pkgs []*SymbolPackage) error {
+	s.resolved = nil
+	pkg, err := s.findPackage(pkgs)
+	if err != nil {
+		return err
+	}
+	var id *ast.Ident
+	for _, file := range pkg.Files {
+		if id = s.findInFile(file); id != nil {
+			break
+		}
+	}
+	if id == nil {
+		return fmt.Errorf("No declaration of %s was found", s.Symbol)
+	}
+	pos := fset.Position(id.Pos())
+	s.resolved = &OffsetLengthSelection{
+		Filename: pos.Filename,
+		Offset:   pos.Offset,
+		Length:   len(id.Name),
+	}
+	return nil
+}
+
+// findPackage returns the package identified by this selection's Package.
+func (s *SymbolSelection) findPackage(pkgs []*SymbolPackage) (*SymbolPackage, error) {
+	byName := []*SymbolPackage{}
+	for _, pkg := range pkgs {
+		if pkg.Path == s.Package {
+			return pkg, nil
+		} else if pkg.Name == s.Package {
+			byName = append(byName, pkg)
+		}
+	}
+	if len(byName) > 1 {
+		initial := []*SymbolPackage{}
+		for _, pkg := range byName {
+			if pkg.Initial {
+				initial = append(initial, pkg)
+			}
+		}
+		byName = initial
+	}
+	switch len(byName) {
+	case 0:
+		return nil, fmt.Errorf("The package %s was not found or was "+
+			"not loaded", s.Package)
+	case 1:
+		return byName[0], nil
+	default:
+		return nil, fmt.Errorf("The package name %s is ambiguous; "+
+			"use the full import path", s.Package)
+	}
+}
+
+// findInFile returns the identifier naming the selected declaration, if it is
+// declared in the given file, or nil otherwise.
+func (s *SymbolSelection) findInFile(file *ast.File) *ast.Ident {
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Name.Name != s.Names[len(s.Names)-1] {
+				continue
+			}
+			if decl.Recv == nil && len(s.Names) == 1 ||
+				decl.Recv != nil && len(s.Names) == 2 &&
+					receiverName(decl) == s.Names[0] {
+				return s.findParam(decl.Name, decl.Type)
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				if id := s.findInSpec(spec); id != nil {
+					return id
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// findInSpec returns the identifier naming the selected declaration, if it is
+// (or is a member of) the type, variable, or constant declared by the given
+// spec, or nil otherwise.
+func (s *SymbolSelection) findInSpec(spec ast.Spec) *ast.Ident {
+	switch spec := spec.(type) {
+	case *ast.TypeSpec:
+		if spec.Name.Name != s.Names[0] {
+			return nil
+		}
+		if len(s.Names) == 1 {
+			return s.findParam(spec.Name, spec.Type)
+		}
+		// Fields of nested struct types may be selected (e.g., T.F.G)
+		typ := spec.Type
+		var id *ast.Ident
+		for _, name := range s.Names[1:] {
+			if id, typ = findMember(typ, name); id == nil {
+				return nil
+			}
+		}
+		return s.findParam(id, typ)
+	case *ast.ValueSpec:
+		if len(s.Names) != 1 {
+			return nil
+		}
+		for _, id := range spec.Names {
+			if id.Name == s.Names[0] {
+				return s.findParam(id, spec.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// findParam returns id if this selection does not name a parameter.
+// Otherwise, typ must be a function type, and findParam returns the
+// identifier naming the parameter or result, or nil if there is none.
+func (s *SymbolSelection) findParam(id *ast.Ident, typ ast.Expr) *ast.Ident {
+	if s.Param == "" {
+		return id
+	}
+	fn, ok := typ.(*ast.FuncType)
+	if !ok {
+		return nil
+	}
+	for _, list := range []*ast.FieldList{fn.Params, fn.Results} {
+		if list == nil {
+			continue
+		}
+		for _, field := range list.List {
+			for _, name := range field.Names {
+				if name.Name == s.Param {
+					return name
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// findMember returns the identifier naming the field (of a struct type) or
+// method (of an interface type) with the given name, along with its type, or
+// nil if there is none.
+func findMember(typ ast.Expr, name string) (*ast.Ident, ast.Expr) {
+	var fields *ast.FieldList
+	switch typ := typ.(type) {
+	case *ast.StructType:
+		fields = typ.Fields
+	case *ast.InterfaceType:
+		fields = typ.Methods
+	default:
+		return nil, nil
+	}
+	for _, field := range fields.List {
+		if len(field.Names) == 0 {
+			// Embedded field or interface
+			if id := embeddedName(field.Type); id != nil && id.Name == name {
+				return id, field.Type
+			}
+		}
+		for _, id := range field.Names {
+			if id.Name == name {
+				return id, field.Type
+			}
+		}
+	}
+	return nil, nil
+}
+
+// receiverName returns the name of the type of a method's receiver.
+func receiverName(decl *ast.FuncDecl) string {
+	if len(decl.Recv.List) == 0 {
+		return ""
+	}
+	if id := embeddedName(decl.Recv.List[0].Type); id != nil {
+		return id.Name
+	}
+	return ""
+}
+
+// embeddedName returns the identifier naming the type T, *T, pkg.T, or *pkg.T,
+// or nil if expr is none of these.
+func embeddedName(expr ast.Expr) *ast.Ident {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		return expr
+	case *ast.SelectorExpr:
+		return expr.Sel
+	}
+	return nil
+}
+
+// Convert returns start and end positions corresponding to the name in the
+// selected declaration.  It returns an error if this selection has not been
+// resolved (see Resolve).
+func (s *SymbolSelection) Convert(fset *token.FileSet) (token.Pos, token.Pos, error) {
+	if s.resolved == nil {
+		return 0, 0, fmt.Errorf("The symbol %s has not been resolved",
+			s.Symbol)
+	}
+	return s.resolved.Convert(fset)
+}
+
+// GetFilename returns the file containing the selected declaration, if this
+// selection has been resolved, and otherwise the file given when it was
+// created.  The returned filename may be an absolute or relative path and is
+// not guaranteed to correspond to a valid file.
+func (s *SymbolSelection) GetFilename() string {
+	if s.resolved != nil {
+		return s.resolved.Filename
+	}
+	return s.Filename
+}
+
+func (s *SymbolSelection) String() string {
+	return s.Symbol
+}
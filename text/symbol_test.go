@@ -0,0 +1,145 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package text_test
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/godoctor/godoctor/text"
+)
+
+const symbolSrc = `package shapes
+
+type Shape interface {
+	Area() float64
+	Scale(factor float64) Shape
+}
+
+type Rect struct {
+	Width, Height float64
+	Origin        struct{ X, Y int }
+	*Label
+}
+
+type Label struct{}
+
+var Unit, Zero = Rect{1, 1}, Rect{}
+
+func (r *Rect) Area() float64 { return r.Width * r.Height }
+
+func (r Rect) Scale(factor float64) Shape { return r }
+
+func NewRect(width, height float64) (rect *Rect, err error) { return }
+`
+
+func TestNewSymbolSelection(t *testing.T) {
+	sel, err := text.NewSelection("main.go", "github.com/a/b-c/shapes.Rect.Scale#factor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sym, ok := sel.(*text.SymbolSelection)
+	if !ok {
+		t.Fatalf("Expected *SymbolSelection, got %T", sel)
+	}
+	if sym.Package != "github.com/a/b-c/shapes" || len(sym.Names) != 2 ||
+		sym.Names[0] != "Rect" || sym.Names[1] != "Scale" ||
+		sym.Param != "factor" || sym.GetFilename() != "main.go" {
+		t.Fatalf("Incorrect SymbolSelection: %+v", sym)
+	}
+
+	invalid := []string{
+		"shapes",
+		"shapes.",
+		"shapes.Rect.",
+		"shapes.Rect#",
+		"shapes.Rect#a#b",
+		"shapes.1Rect",
+		"a/b/.Rect",
+		"shapes.Rect Area",
+	}
+	for _, s := range invalid {
+		if _, err := text.NewSymbolSelection("main.go", s); err == nil {
+			t.Fatalf("NewSymbolSelection should have failed for %s", s)
+		}
+	}
+}
+
+func TestSymbolSelectionResolve(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "shapes.go", symbolSrc, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkgs := []*text.SymbolPackage{
+		&text.SymbolPackage{
+			Path:    "example.com/shapes",
+			Name:    "shapes",
+			Initial: true,
+			Files:   []*ast.File{file},
+		},
+	}
+
+	tests := map[string]string{
+		"example.com/shapes.Shape":       "Shape",
+		"shapes.Shape.Scale":             "Scale",
+		"shapes.Shape.Scale#factor":      "factor",
+		"shapes.Rect.Height":             "Height",
+		"shapes.Rect.Origin.Y":           "Y",
+		"shapes.Rect.Label":              "Label",
+		"shapes.Rect.Area":               "Area",
+		"shapes.Rect.Scale#factor":       "factor",
+		"shapes.Zero":                    "Zero",
+		"shapes.NewRect":                 "NewRect",
+		"shapes.NewRect#height":          "height",
+		"example.com/shapes.NewRect#err": "err",
+	}
+	for symbol, expected := range tests {
+		sel, err := text.NewSymbolSelection("", symbol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := sel.Convert(fset); err == nil {
+			t.Fatalf("%s: Convert should fail before Resolve", symbol)
+		}
+		if err := sel.Resolve(fset, pkgs); err != nil {
+			t.Fatalf("%s: %s", symbol, err)
+		}
+		start, end, err := sel.Convert(fset)
+		if err != nil {
+			t.Fatalf("%s: %s", symbol, err)
+		}
+		offset := fset.Position(start).Offset
+		length := fset.Position(end).Offset - offset
+		if actual := symbolSrc[offset : offset+length]; actual != expected {
+			t.Fatalf("%s: expected %s, got %s", symbol, expected, actual)
+		}
+		if sel.GetFilename() != "shapes.go" {
+			t.Fatalf("%s: incorrect filename %s", symbol, sel.GetFilename())
+		}
+	}
+
+	notFound := []string{
+		"other.Shape",
+		"shapes.Circle",
+		"shapes.Rect.Perimeter",
+		"shapes.Area",
+		"shapes.Rect.Area#r",
+		"shapes.NewRect#depth",
+		"shapes.Zero.X",
+		"shapes.Rect.Width.X",
+	}
+	for _, symbol := range notFound {
+		sel, err := text.NewSymbolSelection("", symbol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sel.Resolve(fset, pkgs); err == nil {
+			t.Fatalf("%s: Resolve should have failed", symbol)
+		}
+	}
+}
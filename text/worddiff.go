@@ -0,0 +1,130 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file contains a word-by-word diff, which is used to identify the
+// changes within a line of a unified diff (e.g., so that a renamed identifier
+// can be highlighted, rather than just showing that the entire line changed).
+
+package text
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// WordDiff creates an EditSet containing the changes necessary to change a
+// into b, treating each word as an indivisible unit.  A word is a maximal
+// sequence of letters, digits, and underscores (e.g., an identifier or a
+// number), a maximal sequence of whitespace, or any other single character.
+//
+// Unlike Diff, adjacent changes are combined, so each edit in the resulting
+// EditSet replaces a (possibly empty) sequence of words in a with a
+// (possibly empty) sequence of words in b.
+func WordDiff(a, b string) *EditSet {
+	result := NewEditSet()
+	var cur *edit
+	for _, e := range Diff(splitWords(a), splitWords(b)).edits {
+		if cur != nil && e.Offset <= cur.OffsetPastEnd() {
+			end := max(cur.OffsetPastEnd(), e.OffsetPastEnd())
+			cur.Length = end - cur.Offset
+			cur.replacement += e.replacement
+			continue
+		}
+		if cur != nil {
+			result.Add(cur.Extent, cur.replacement)
+		}
+		cur = &edit{&Extent{e.Offset, e.Length}, e.replacement}
+	}
+	if cur != nil {
+		result.Add(cur.Extent, cur.replacement)
+	}
+	return result
+}
+
+// splitWords splits a string into words, as defined by WordDiff.
+func splitWords(s string) []string {
+	result := []string{}
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		if isWordChar(r) || unicode.IsSpace(r) {
+			class := isWordChar(r)
+			for size < len(s) {
+				next, n := utf8.DecodeRuneInString(s[size:])
+				if isWordChar(next) != class ||
+					!class && !unicode.IsSpace(next) {
+					break
+				}
+				size += n
+			}
+		}
+		result = append(result, s[:size])
+		s = s[size:]
+	}
+	return result
+}
+
+// isWordChar returns true iff r is a letter, digit, or underscore.
+func isWordChar(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// highlightChanges finds each run of deleted lines followed by added lines in
+// the given hunk lines and pairs them up (the first deleted line with the
+// first added line, and so forth).  For each pair, it sets the Changed field
+// of both lines to the regions that differ between them, as determined by
+// WordDiff.  If a pair of lines has nothing in common other than whitespace,
+// no regions are highlighted.
+func highlightChanges(lines []*PatchLine) {
+	for i := 0; i < len(lines); {
+		if lines[i].Kind != '-' {
+			i++
+			continue
+		}
+		delStart := i
+		for i < len(lines) && lines[i].Kind == '-' {
+			i++
+		}
+		addStart := i
+		for i < len(lines) && lines[i].Kind == '+' {
+			i++
+		}
+		for j := 0; j < addStart-delStart && addStart+j < i; j++ {
+			highlightPair(lines[delStart+j], lines[addStart+j])
+		}
+	}
+}
+
+// highlightPair sets the Changed fields of a deleted line and the added line
+// that replaces it.
+func highlightPair(del, add *PatchLine) {
+	oldText := strings.TrimSuffix(del.Text, "\n")
+	newText := strings.TrimSuffix(add.Text, "\n")
+	es := WordDiff(oldText, newText)
+	if len(es.edits) == 0 {
+		return
+	}
+
+	oldChanged, newChanged := []*Extent{}, []*Extent{}
+	var unchanged []string
+	pos, delta := 0, 0
+	for _, e := range es.edits {
+		unchanged = append(unchanged, oldText[pos:e.Offset])
+		if e.Length > 0 {
+			oldChanged = append(oldChanged, &Extent{e.Offset, e.Length})
+		}
+		if len(e.replacement) > 0 {
+			newChanged = append(newChanged,
+				&Extent{e.Offset + delta, len(e.replacement)})
+		}
+		delta += len(e.replacement) - e.Length
+		pos = e.OffsetPastEnd()
+	}
+	unchanged = append(unchanged, oldText[pos:])
+	if strings.TrimSpace(strings.Join(unchanged, "")) == "" {
+		return
+	}
+	del.Changed = oldChanged
+	add.Changed = newChanged
+}
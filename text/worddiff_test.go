@@ -0,0 +1,114 @@
+// Copyright 2015 Auburn University. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package text
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitWords(t *testing.T) {
+	words := splitWords("x := fooBar(y_1, 2.5)  // héllo\n")
+	assertEquals("[x| |:|=| |fooBar|(|y_1|,| |2|.|5|)|  |/|/| |héllo|\n]",
+		"["+strings.Join(words, "|")+"]", t)
+}
+
+func TestWordDiff(t *testing.T) {
+	a := "func foo(x int) int {"
+	b := "func bar(x, y int) int {"
+	es := WordDiff(a, b)
+	assertEquals("Replace offset 5, length 3 with \"bar\"\n"+
+		"Replace offset 10, length 0 with \",\"\n"+
+		"Replace offset 11, length 0 with \"y \"\n", es.String(), t)
+	result, _ := ApplyToString(es, a)
+	assertEquals(b, result, t)
+}
+
+func TestWordDiffRandom(t *testing.T) {
+	seed := time.Now().Unix()
+	r := rand.New(rand.NewSource(seed))
+	words := []string{"a", "b", "foo", " ", "  ", "(", ")", ".", "\t"}
+	randomLine := func() string {
+		var b bytes.Buffer
+		for i := r.Intn(10); i > 0; i-- {
+			b.WriteString(words[r.Intn(len(words))])
+		}
+		return b.String()
+	}
+	for i := 0; i < 1000; i++ {
+		a, b := randomLine(), randomLine()
+		result, err := ApplyToString(WordDiff(a, b), a)
+		if err != nil {
+			t.Fatalf("Seed %d, iteration %d: %s", seed, i, err)
+		}
+		if result != b {
+			t.Fatalf("Seed %d, iteration %d: WordDiff(%q, %q) gave %q",
+				seed, i, a, b, result)
+		}
+	}
+}
+
+func TestPatchHunks(t *testing.T) {
+	a := "package main\n\nfunc foo() {\n\tfoo()\n}\n"
+	b := "package main\n\nfunc fooBar() {\n\tfooBar()\n}\n"
+	hunks := patchHunks(a, b, t)
+	if len(hunks) != 1 {
+		t.Fatalf("Expected 1 hunk, got %d", len(hunks))
+	}
+	var actual bytes.Buffer
+	for _, line := range hunks[0].Lines {
+		actual.WriteString(string(line.Kind))
+		for _, c := range line.Changed {
+			actual.WriteString(" " + line.Text[c.Offset:c.OffsetPastEnd()])
+		}
+		actual.WriteString("\n")
+	}
+	assertEquals(" \n \n- foo\n- foo\n+ fooBar\n+ fooBar\n \n",
+		actual.String(), t)
+}
+
+func TestPatchHunksDissimilar(t *testing.T) {
+	hunks := patchHunks("a\nb b b\nc\n", "a\nx y\nc\n", t)
+	for _, line := range hunks[0].Lines {
+		if line.Changed != nil {
+			t.Fatalf("Unexpected highlighting of %q", line.Text)
+		}
+	}
+}
+
+func TestWriteColor(t *testing.T) {
+	a := "one\nvar x = 1\nthree"
+	b := "one\nvar y = 1\nthree"
+	edits := Diff(strings.SplitAfter(a, "\n"), strings.SplitAfter(b, "\n"))
+	patch, err := edits.CreatePatch(strings.NewReader(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result bytes.Buffer
+	patch.WriteColor("f", "f", time.Time{}, time.Time{}, &result)
+	assertEquals("\x1b[1m--- f\x1b[0m\n"+
+		"\x1b[1m+++ f\x1b[0m\n"+
+		"\x1b[36m@@ -1,3 +1,3 @@\x1b[0m\n"+
+		" one\n"+
+		"\x1b[31m-var \x1b[7mx\x1b[27m = 1\x1b[0m\n"+
+		"\x1b[32m+var \x1b[7my\x1b[27m = 1\x1b[0m\n"+
+		" three", result.String(), t)
+}
+
+func patchHunks(a, b string, t *testing.T) []*PatchHunk {
+	edits := Diff(strings.SplitAfter(a, "\n"), strings.SplitAfter(b, "\n"))
+	patch, err := edits.CreatePatch(strings.NewReader(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hunks, err := patch.Hunks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hunks
+}